@@ -0,0 +1,347 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Encoding of `GIT binary patch` hunks: zlib deflated literal content or git
+// delta, wrapped in base85 lines.
+
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// encode85 encodes data in 4 byte groups, last group is zero padded.
+func encode85(data []byte) []byte {
+	out := make([]byte, 0, (len(data)+3)/4*5)
+	for len(data) > 0 {
+		var acc uint32
+		for idx := 0; idx < 4; idx++ {
+			acc <<= 8
+			if idx < len(data) {
+				acc |= uint32(data[idx])
+			}
+		}
+
+		var group [5]byte
+		for idx := 4; idx >= 0; idx-- {
+			group[idx] = base85Alphabet[acc%85]
+			acc /= 85
+		}
+		out = append(out, group[:]...)
+
+		if len(data) < 4 {
+			break
+		}
+		data = data[4:]
+	}
+	return out
+}
+
+func deflate(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// delta format constants
+const (
+	deltaBlockSize = 16
+	deltaMaxInsert = 0x7f
+	deltaMaxCopy   = 0xffff
+)
+
+func appendDeltaSize(out []byte, size int) []byte {
+	for size >= 0x80 {
+		out = append(out, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(out, byte(size))
+}
+
+func appendDeltaInsert(out, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > deltaMaxInsert {
+			n = deltaMaxInsert
+		}
+		out = append(out, byte(n))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+func appendDeltaCopy(out []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > deltaMaxCopy {
+			n = deltaMaxCopy
+		}
+
+		cmd := byte(0x80)
+		args := make([]byte, 0, 6)
+		for idx := uint(0); idx < 4; idx++ {
+			if b := byte(offset >> (8 * idx)); b != 0 {
+				cmd |= 1 << idx
+				args = append(args, b)
+			}
+		}
+		for idx := uint(0); idx < 2; idx++ {
+			if b := byte(n >> (8 * idx)); b != 0 {
+				cmd |= 0x10 << idx
+				args = append(args, b)
+			}
+		}
+
+		out = append(out, cmd)
+		out = append(out, args...)
+		offset += n
+		size -= n
+	}
+	return out
+}
+
+func blockHash(data []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32()
+}
+
+// makeDelta builds git delta transforming src into dst. It returns nil when
+// delta would be larger than maxSize.
+func makeDelta(src, dst []byte, maxSize int) []byte {
+	index := map[uint32]int{}
+	for offset := 0; offset+deltaBlockSize <= len(src); offset += deltaBlockSize {
+		hash := blockHash(src[offset : offset+deltaBlockSize])
+		if _, ok := index[hash]; !ok {
+			index[hash] = offset
+		}
+	}
+
+	out := appendDeltaSize(nil, len(src))
+	out = appendDeltaSize(out, len(dst))
+
+	literal := 0
+	pos := 0
+	for pos < len(dst) {
+		if maxSize > 0 && len(out) > maxSize {
+			return nil
+		}
+
+		if pos+deltaBlockSize <= len(dst) {
+			offset, ok := index[blockHash(dst[pos:pos+deltaBlockSize])]
+			if ok && bytes.Equal(src[offset:offset+deltaBlockSize], dst[pos:pos+deltaBlockSize]) {
+				// extend match backwards into pending literal and forwards
+				for pos > literal && offset > 0 && src[offset-1] == dst[pos-1] {
+					offset--
+					pos--
+				}
+				size := deltaBlockSize
+				for offset+size < len(src) && pos+size < len(dst) && src[offset+size] == dst[pos+size] {
+					size++
+				}
+
+				out = appendDeltaInsert(out, dst[literal:pos])
+				out = appendDeltaCopy(out, offset, size)
+				pos += size
+				literal = pos
+				continue
+			}
+		}
+		pos++
+	}
+
+	out = appendDeltaInsert(out, dst[literal:])
+	if maxSize > 0 && len(out) > maxSize {
+		return nil
+	}
+	return out
+}
+
+// writeBinaryHunk writes one direction of a binary patch, choosing delta when
+// it is smaller than the literal content.
+func writeBinaryHunk(w io.Writer, src, dst []byte) error {
+	deflated := deflate(dst)
+
+	var delta []byte
+	var deltaSize int
+	if len(src) > 0 && len(dst) > 0 {
+		if raw := makeDelta(src, dst, len(deflated)); raw != nil {
+			deltaSize = len(raw)
+			delta = deflate(raw)
+		}
+	}
+
+	data := deflated
+	if delta != nil && len(delta) < len(deflated) {
+		data = delta
+		if _, err := fmt.Fprintf(w, "delta %d\n", deltaSize); err != nil {
+			return err
+		}
+	} else if _, err := fmt.Fprintf(w, "literal %d\n", len(dst)); err != nil {
+		return err
+	}
+
+	line := make([]byte, 0, 72)
+	for len(data) > 0 {
+		n := len(data)
+		if n > 52 {
+			n = 52
+		}
+
+		line = line[:0]
+		if n <= 26 {
+			line = append(line, byte('A'+n-1))
+		} else {
+			line = append(line, byte('a'+n-27))
+		}
+		line = append(line, encode85(data[:n])...)
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeBinaryPatch writes forward and reverse binary hunks, so the patch can
+// be applied in both directions.
+func writeBinaryPatch(w io.Writer, oldData, newData []byte) error {
+	if _, err := io.WriteString(w, "GIT binary patch\n"); err != nil {
+		return err
+	}
+
+	if err := writeBinaryHunk(w, oldData, newData); err != nil {
+		return err
+	}
+	return writeBinaryHunk(w, newData, oldData)
+}
+
+// decode85 decodes base85 data, size is the number of bytes encoded.
+func decode85(data []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, (len(data)/5)*4)
+	for len(data) >= 5 && len(out) < size {
+		var acc uint64
+		for _, ch := range data[:5] {
+			value := strings.IndexByte(base85Alphabet, ch)
+			if value == -1 {
+				return nil, fmt.Errorf("invalid base85 character %q", ch)
+			}
+			acc = acc*85 + uint64(value)
+		}
+		if acc > 0xffffffff {
+			return nil, fmt.Errorf("invalid base85 group %q", data[:5])
+		}
+
+		for shift := 24; shift >= 0 && len(out) < size; shift -= 8 {
+			out = append(out, byte(acc>>uint(shift)))
+		}
+		data = data[5:]
+	}
+
+	if len(out) != size {
+		return nil, fmt.Errorf("truncated base85 data")
+	}
+	return out, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+func readDeltaSize(delta []byte) (int, []byte, error) {
+	size := 0
+	for shift := uint(0); ; shift += 7 {
+		if len(delta) == 0 {
+			return 0, nil, fmt.Errorf("truncated delta header")
+		}
+		ch := delta[0]
+		delta = delta[1:]
+		size |= int(ch&0x7f) << shift
+		if ch&0x80 == 0 {
+			return size, delta, nil
+		}
+	}
+}
+
+// applyDelta builds content from the base and git delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("delta base size mismatch: %d != %d", srcSize, len(base))
+	}
+
+	dstSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case cmd&0x80 != 0:
+			var offset, size int
+			for idx := uint(0); idx < 7; idx++ {
+				if cmd&(1<<idx) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, fmt.Errorf("truncated delta copy")
+				}
+				if idx < 4 {
+					offset |= int(delta[0]) << (8 * idx)
+				} else {
+					size |= int(delta[0]) << (8 * (idx - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("delta copy out of bounds")
+			}
+			out = append(out, base[offset:offset+size]...)
+
+		case cmd != 0:
+			if int(cmd) > len(delta) {
+				return nil, fmt.Errorf("truncated delta insert")
+			}
+			out = append(out, delta[:cmd]...)
+			delta = delta[cmd:]
+
+		default:
+			return nil, fmt.Errorf("invalid delta opcode")
+		}
+	}
+
+	if len(out) != dstSize {
+		return nil, fmt.Errorf("delta result size mismatch: %d != %d", len(out), dstSize)
+	}
+	return out, nil
+}
@@ -15,7 +15,12 @@ type Blob struct {
 // Data gets content of blob all at once and wrap it as io.Reader.
 // This can be very slow and memory consuming for huge content.
 func (b *Blob) Data() (io.Reader, error) {
-	_, body, err := b.repo.repo.OpenObject(sha2oidp(b.ID))
+	owner := b.repo.objectOwner(b.ID)
+	if owner == nil {
+		return nil, ErrNotExist{b.ID.String(), ""}
+	}
+
+	_, body, err := owner.repo.OpenObject(sha2oidp(b.ID))
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +40,12 @@ func (b *Blob) Data() (io.Reader, error) {
 }
 
 func (b *Blob) DataPipeline(stdout, stderr io.Writer) error {
-	_, body, err := b.repo.repo.OpenObject(sha2oidp(b.ID))
+	owner := b.repo.objectOwner(b.ID)
+	if owner == nil {
+		return ErrNotExist{b.ID.String(), ""}
+	}
+
+	_, body, err := owner.repo.OpenObject(sha2oidp(b.ID))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,161 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CherryPickOptions controls cherry-picks and reverts.
+type CherryPickOptions struct {
+	MergeOptions
+	// Mainline is the number of the parent, starting from 1, which changes
+	// of a merge commit are taken against. It is set for merge commits only.
+	Mainline int
+}
+
+// DefaultCherryPickOptions returns options picking the same way `git
+// cherry-pick` does by default.
+func DefaultCherryPickOptions() CherryPickOptions {
+	return CherryPickOptions{MergeOptions: DefaultMergeOptions()}
+}
+
+// CherryPick applies changes of commit on top of onto and returns the new
+// commit, which keeps the original author and message with "(cherry picked
+// from commit ...)" line added, as `git cherry-pick -x` does. If the changes
+// conflict, nothing is written and ErrMergeConflict lists the conflicts.
+func (repo *Repository) CherryPick(commit, onto string, committer *Signature, opts CherryPickOptions) (sha1, error) {
+	node, parentTree, _, err := repo.pickedCommit(commit, opts.Mainline)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	raw, err := node.rawCommit(repo)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	message := cherryPickMessage(raw.Message, node.id)
+	return repo.pickOnto(onto, parentTree, node.tree, raw2signature(raw.Author), committer, message, &opts.MergeOptions)
+}
+
+// Revert applies changes undoing commit on top of onto and returns the new
+// commit, authored by the committer, with the message `git revert` makes.
+// If the changes conflict, nothing is written and ErrMergeConflict lists
+// the conflicts.
+func (repo *Repository) Revert(commit, onto string, committer *Signature, opts CherryPickOptions) (sha1, error) {
+	node, parentTree, parentID, err := repo.pickedCommit(commit, opts.Mainline)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	raw, err := node.rawCommit(repo)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	subject, _ := splitMessage(raw.Message)
+	message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject, node.id)
+	if len(node.parents) > 1 {
+		message += fmt.Sprintf(", reversing\nchanges made to %s", parentID)
+	}
+	message += ".\n"
+
+	return repo.pickOnto(onto, node.tree, parentTree, committer, committer, message, &opts.MergeOptions)
+}
+
+// pickedCommit resolves commit and returns it with the tree and id of the
+// parent its changes are taken against.
+func (repo *Repository) pickedCommit(commit string, mainline int) (*walkNode, sha1, sha1, error) {
+	id, err := repo.resolveRevision(commit)
+	if err != nil {
+		return nil, sha1{}, sha1{}, err
+	}
+
+	node, err := repo.loadWalkNode(id)
+	if err != nil {
+		return nil, sha1{}, sha1{}, err
+	}
+
+	parent := 0
+	switch {
+	case len(node.parents) > 1 && mainline == 0:
+		return nil, sha1{}, sha1{}, fmt.Errorf("commit %s is a merge but no mainline was given", id)
+	case len(node.parents) > 1 && mainline > len(node.parents):
+		return nil, sha1{}, sha1{}, fmt.Errorf("commit %s does not have parent %d", id, mainline)
+	case len(node.parents) > 1:
+		parent = mainline - 1
+	case mainline != 0:
+		return nil, sha1{}, sha1{}, fmt.Errorf("mainline was given but commit %s is not a merge", id)
+	case len(node.parents) == 0:
+		// root commit adds everything
+		return node, sha1{}, sha1{}, nil
+	}
+
+	parentNode, err := repo.loadWalkNode(node.parents[parent])
+	if err != nil {
+		return nil, sha1{}, sha1{}, err
+	}
+	return node, parentNode.tree, parentNode.id, nil
+}
+
+// pickOnto merges changes from base to theirs into the tip of onto and
+// writes them as a commit on top of it.
+func (repo *Repository) pickOnto(onto string, base, theirs sha1, author, committer *Signature, message string, opts *MergeOptions) (sha1, error) {
+	ontoID, err := repo.resolveRevision(onto)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	ontoNode, err := repo.loadWalkNode(ontoID)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	m, err := repo.withOverlay(newMemoryObjects()).mergeTrees(base, ontoNode.tree, theirs, opts, 0)
+	if err != nil {
+		return sha1{}, err
+	}
+	if len(m.conflicts) > 0 {
+		return sha1{}, ErrMergeConflict{Conflicts: m.conflicts}
+	}
+
+	tree, err := m.editor.write(repo)
+	if err != nil {
+		return sha1{}, err
+	}
+	if tree == ontoNode.tree {
+		return sha1{}, fmt.Errorf("changes are already in %s, nothing to commit", onto)
+	}
+
+	return writeCommit(repo, tree, []sha1{ontoID}, author, committer, message)
+}
+
+// cherryPickMessage adds origin of the commit to its message. The line
+// continues trailers ending the message, otherwise it is a paragraph.
+func cherryPickMessage(message string, id sha1) string {
+	message = strings.TrimRight(message, "\n")
+	if !endsWithTrailers(message) {
+		message += "\n"
+	}
+	return message + "\n(cherry picked from commit " + id.String() + ")\n"
+}
+
+// endsWithTrailers tells whether the last paragraph of message, other than
+// the subject, consists of "Key: value" lines.
+func endsWithTrailers(message string) bool {
+	paragraphs := strings.Split(message, "\n\n")
+	if len(paragraphs) < 2 {
+		return false
+	}
+
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if strings.HasPrefix(line, "(cherry picked from commit ") {
+			continue
+		}
+		colon := strings.Index(line, ": ")
+		if colon <= 0 || strings.ContainsAny(line[:colon], " \t") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRevertMainline(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testCommitFiles(t, repo, map[string]string{"a": "a\n"}, 100)
+	ours := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "b\n"}, 200, base)
+	theirs := testCommitFiles(t, repo, map[string]string{"a": "a\n", "c": "c\n"}, 300, base)
+	merge := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "b\n", "c": "c\n"}, 400, ours, theirs)
+
+	committer := &Signature{Name: "C O Mitter", Email: "committer@example.com", When: time.Unix(500, 0).UTC()}
+	for _, test := range []struct {
+		mainline int
+		parent   sha1
+		want     []string
+	}{
+		{1, ours, []string{"a", "b"}},
+		{2, theirs, []string{"a", "c"}},
+	} {
+		opts := DefaultCherryPickOptions()
+		opts.Mainline = test.mainline
+		id, err := repo.Revert(merge.String(), merge.String(), committer, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		commit, err := repo.GetCommit(id.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "Revert \"message\"\n\nThis reverts commit " + merge.String() + ", reversing\nchanges made to " + test.parent.String() + ".\n"
+		if commit.CommitMessage != want {
+			t.Errorf("mainline %d: got message %q, want %q", test.mainline, commit.CommitMessage, want)
+		}
+		if commit.Author.Email != committer.Email {
+			t.Errorf("mainline %d: got author %s", test.mainline, commit.Author.Email)
+		}
+		if got := treeNames(t, repo, commit.Tree.ID); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("mainline %d: got tree entries %v, want %v", test.mainline, got, test.want)
+		}
+	}
+
+	// merges can not be picked without a mainline
+	if _, err := repo.Revert(merge.String(), merge.String(), committer, DefaultCherryPickOptions()); err == nil {
+		t.Error("expected error reverting merge without mainline")
+	}
+}
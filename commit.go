@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/mechmind/git-go/history"
 	"github.com/mechmind/git-go/rawgit"
 )
 
@@ -32,6 +31,7 @@ type Signature struct {
 
 func raw2commit(repo *Repository, raw *rawgit.Commit) (*Commit, error) {
 	commit := &Commit{
+		Tree:          *NewTree(repo, rawTreeID(raw)),
 		ID:            sha1(*raw.GetOID()),
 		Author:        raw2signature(raw.Author),
 		Committer:     raw2signature(raw.Committer),
@@ -51,6 +51,11 @@ func raw2signature(raw rawgit.UserTime) *Signature {
 	}
 }
 
+// TreeID returns id of the root tree of the commit.
+func (c *Commit) TreeID() sha1 {
+	return c.Tree.ID
+}
+
 // Message returns the commit message. Same as retrieving CommitMessage directly.
 func (c *Commit) Message() string {
 	return c.CommitMessage
@@ -119,22 +124,18 @@ func (c *Commit) IsImageFile(name string) bool {
 
 // GetCommitByPath return the commit of relative path object.
 func (c *Commit) GetCommitByPath(relpath string) (*Commit, error) {
-	pathCb := history.MakePathChecker(c.repo.repo, relpath)
-	pager := history.MakePager(c.repo.repo, pathCb, 0, 1)
-	cmp := history.MakePathComparator(c.repo.repo, relpath)
-
-	hist := history.New(c.repo.repo)
-	result, err := hist.WalkFilteredHistory(sha2oidp(c.ID), pager, cmp)
+	walk, err := c.repo.newPathWalk(c.ID, relpath)
 	if err != nil {
 		return nil, err
 	}
 
-	if result.Len() == 0 {
+	result, err := c.repo.commitList(walk, 0, 1, nil)
+	if err != nil || result.Len() == 0 {
 		// no entries
-		return nil, nil
+		return nil, err
 	}
 
-	return raw2commit(c.repo, result.Front().Value.(*rawgit.Commit))
+	return result.Front().Value.(*Commit), nil
 }
 
 // AddAllChanges marks local changes to be ready for commit.
@@ -185,49 +186,33 @@ func CommitsCount(repoPath, revision string) (int64, error) {
 }
 
 func (c *Commit) CommitsCount() (int64, error) {
-	counter, result := history.MakeCounter(nil)
-
-	hist := history.New(c.repo.repo)
-	_, err := hist.WalkHistory(sha2oidp(c.ID), counter)
-	if err != nil {
-		return 0, err
-	}
-
-	return int64(result()), nil
+	return c.repo.countReachable(c.ID)
 }
 
 var CommitsRangeSize = 50
 
 func (c *Commit) CommitsByRange(page int) (*list.List, error) {
-	pager := history.MakePager(c.repo.repo, nil, (page-1)*CommitsRangeSize, CommitsRangeSize)
-	hist := history.New(c.repo.repo)
-	result, err := hist.WalkHistory(sha2oidp(c.ID), pager)
+	walk, err := c.repo.newPathWalk(c.ID, "")
 	if err != nil {
 		return nil, err
 	}
 
-	return c.repo.raw2commitList(result)
+	return c.repo.commitList(walk, (page-1)*CommitsRangeSize, CommitsRangeSize, nil)
 }
 
 func (c *Commit) CommitsBefore() (*list.List, error) {
-	hist := history.New(c.repo.repo)
-	result, err := hist.WalkHistory(sha2oidp(c.ID), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.repo.raw2commitList(result)
+	return c.CommitsBeforeLimit(0)
 }
 
+// CommitsBeforeLimit returns at most num commits starting with this one,
+// zero num means all of them.
 func (c *Commit) CommitsBeforeLimit(num int) (*list.List, error) {
-	pager := history.MakePager(c.repo.repo, nil, 0, num)
-	hist := history.New(c.repo.repo)
-	result, err := hist.WalkHistory(sha2oidp(c.ID), pager)
+	walk, err := c.repo.newPathWalk(c.ID, "")
 	if err != nil {
 		return nil, err
 	}
 
-	return c.repo.raw2commitList(result)
+	return c.repo.commitList(walk, 0, num, nil)
 }
 
 func (c *Commit) CommitsBeforeUntil(commitID string) (*list.List, error) {
@@ -243,19 +228,20 @@ func (c *Commit) CommitsBeforeUntil(commitID string) (*list.List, error) {
 	return nil, nil
 }
 
+// SearchCommits returns commits whose message matches keyword, a regular
+// expression matched ignoring case.
 func (c *Commit) SearchCommits(keyword string) (*list.List, error) {
-	searcher, err := history.MakeHistorySearcher(keyword)
+	match, err := messageMatcher(c.repo, keyword)
 	if err != nil {
 		return nil, err
 	}
 
-	hist := history.New(c.repo.repo)
-	result, err := hist.WalkHistory(sha2oidp(c.ID), searcher)
+	walk, err := c.repo.newPathWalk(c.ID, "")
 	if err != nil {
 		return nil, err
 	}
 
-	return c.repo.raw2commitList(result)
+	return c.repo.commitList(walk, 0, 0, match)
 }
 
 func (c *Commit) GetSubModules() (*objectCache, error) {
@@ -0,0 +1,257 @@
+package git
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// Cursors are opaque to callers. Internally a cursor is the frontier of the
+// history walk: commits which were queued but not visited yet, plus the
+// filtered path. Resuming from a frontier costs only as much as the page
+// itself and does not depend on commits added to the branch since the first
+// page was served.
+//
+// A frontier alone does not tell what was served already: a commit served
+// on an earlier page can be reachable from the frontier again. So a cursor
+// also keeps a boundary, the oldest committer date served so far together
+// with the commits served at exactly that date, and the resumed walk leaves
+// out everything at or above it. Pages of one cursor chain therefore never
+// repeat a commit. As long as no commit is dated later than its children,
+// they also never miss one and list commits in the same order as a single
+// walk. With skewed clocks, a commit dated later than the boundary when the
+// walk reaches it is left out, though history behind it is still walked.
+//
+// A cursor is bound to the commit and path the first page started from. It
+// is accepted only for the same path and a commit which is that one or has
+// it in history, as a branch tip has after a push. Cursors of other
+// branches, files or repositories are rejected as invalid.
+
+const cursorVersion = 3
+
+// cursorWalk is a walk resumed from a cursor, with the boundary of what
+// earlier pages served.
+type cursorWalk struct {
+	*revWalk
+	// tip is the commit the first page started from
+	tip sha1
+	// when is the oldest committer date served, served lists commits served
+	// at exactly that date. Zero served means nothing was served yet.
+	when   int64
+	served []sha1
+}
+
+func newCursorWalk(walk *revWalk, tip sha1) *cursorWalk {
+	return &cursorWalk{revWalk: walk, tip: tip}
+}
+
+// isServed tells whether an earlier page served the commit, or would have
+// served it had the walk reached it in date order.
+func (w *cursorWalk) isServed(node *walkNode) bool {
+	if len(w.served) == 0 || node.when < w.when {
+		return false
+	}
+	if node.when > w.when {
+		return true
+	}
+	for _, id := range w.served {
+		if id == node.id {
+			return true
+		}
+	}
+	return false
+}
+
+// serve moves the boundary past the commit.
+func (w *cursorWalk) serve(node *walkNode) {
+	switch {
+	case len(w.served) == 0 || node.when < w.when:
+		w.when = node.when
+		w.served = []sha1{node.id}
+	case node.when == w.when:
+		w.served = append(w.served, node.id)
+	}
+}
+
+func putUvarint(buf *bytes.Buffer, x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	buf.Write(tmp[:n])
+}
+
+func encodeCursor(walk *cursorWalk) string {
+	ids := walk.frontier()
+	if len(ids) == 0 {
+		return ""
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(cursorVersion)
+	buf.Write(walk.tip[:])
+
+	putUvarint(buf, uint64(len(walk.path)))
+	buf.WriteString(walk.path)
+
+	var when [binary.MaxVarintLen64]byte
+	buf.Write(when[:binary.PutVarint(when[:], walk.when)])
+	putUvarint(buf, uint64(len(walk.served)))
+	for _, id := range walk.served {
+		buf.Write(id[:])
+	}
+
+	for _, id := range ids {
+		buf.Write(id[:])
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+// decodeCursor restores the walk saved by encodeCursor. The cursor must
+// have been made for path and tip or an ancestor of it.
+func (repo *Repository) decodeCursor(cursor string, tip sha1, path string) (*cursorWalk, error) {
+	invalid := ErrInvalidCursor{cursor}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) == 0 || data[0] != cursorVersion {
+		return nil, invalid
+	}
+
+	rd := bytes.NewReader(data[1:])
+	var start sha1
+	if _, err = io.ReadFull(rd, start[:]); err != nil {
+		return nil, invalid
+	}
+
+	pathLen, err := binary.ReadUvarint(rd)
+	if err != nil || pathLen > uint64(rd.Len()) {
+		return nil, invalid
+	}
+	cursorPath := make([]byte, pathLen)
+	if _, err = io.ReadFull(rd, cursorPath); err != nil || string(cursorPath) != path {
+		return nil, invalid
+	}
+
+	walk := newCursorWalk(newRevWalk(repo), start)
+	walk.limitToPath(path, false)
+
+	if walk.when, err = binary.ReadVarint(rd); err != nil {
+		return nil, invalid
+	}
+	count, err := binary.ReadUvarint(rd)
+	if err != nil || count == 0 || count > uint64(rd.Len()/20) || rd.Len()%20 != 0 || rd.Len() == int(count)*20 {
+		return nil, invalid
+	}
+
+	walk.served = make([]sha1, count)
+	for idx := range walk.served {
+		if _, err = io.ReadFull(rd, walk.served[idx][:]); err != nil {
+			return nil, invalid
+		}
+	}
+
+	frontier := make([]sha1, rd.Len()/20)
+	for idx := range frontier {
+		if _, err = io.ReadFull(rd, frontier[idx][:]); err != nil {
+			return nil, invalid
+		}
+	}
+
+	if start != tip {
+		if repo.objectOwner(start) == nil {
+			return nil, invalid
+		}
+		ok, err := repo.isAncestor(start, tip)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, invalid
+		}
+	}
+
+	for _, id := range frontier {
+		if err = walk.push(id); err != nil {
+			return nil, err
+		}
+	}
+	return walk, nil
+}
+
+// cursorPage reads the next page of the walk and returns cursor for the
+// page after it, or empty string when history is exhausted.
+func (repo *Repository) cursorPage(walk *cursorWalk) (*list.List, string, error) {
+	result := list.New()
+	for result.Len() < CommitsRangeSize {
+		node, err := walk.next()
+		if err == io.EOF {
+			return result, "", nil
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		if walk.isServed(node) {
+			continue
+		}
+		walk.serve(node)
+
+		commit, err := node.commit(repo)
+		if err != nil {
+			return nil, "", err
+		}
+		result.PushBack(commit)
+	}
+
+	return result, encodeCursor(walk), nil
+}
+
+// CommitsByCursor returns a page of CommitsRangeSize commits and the cursor
+// for the next page. Empty cursor starts from this commit, empty next cursor
+// means there are no more commits. Unlike CommitsByRange, pages stay stable
+// when new commits are pushed between requests and never repeat commits of
+// earlier pages.
+func (c *Commit) CommitsByCursor(cursor string) (*list.List, string, error) {
+	if cursor != "" {
+		walk, err := c.repo.decodeCursor(cursor, c.ID, "")
+		if err != nil {
+			return nil, "", err
+		}
+		return c.repo.cursorPage(walk)
+	}
+
+	walk := newRevWalk(c.repo)
+	if err := walk.push(c.ID); err != nil {
+		return nil, "", err
+	}
+
+	return c.repo.cursorPage(newCursorWalk(walk, c.ID))
+}
+
+// CommitsByFileAndCursor is a cursor based variant of CommitsByFileAndRange.
+// A cursor is accepted only for the same file and a revision having the
+// first page in history.
+func (repo *Repository) CommitsByFileAndCursor(revision, file, cursor string) (*list.List, string, error) {
+	oid, err := rawgit.ResolveName(repo.repo, revision)
+	if err != nil {
+		return nil, "", err
+	}
+	tip := sha1(*oid)
+
+	if cursor != "" {
+		walk, err := repo.decodeCursor(cursor, tip, file)
+		if err != nil {
+			return nil, "", err
+		}
+		return repo.cursorPage(walk)
+	}
+
+	walk := newRevWalk(repo)
+	walk.limitToPath(file, false)
+	if err = walk.push(tip); err != nil {
+		return nil, "", err
+	}
+
+	return repo.cursorPage(newCursorWalk(walk, tip))
+}
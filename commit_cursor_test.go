@@ -0,0 +1,122 @@
+package git
+
+import (
+	"container/list"
+	"encoding/base64"
+	"testing"
+)
+
+// commitIDs returns ids of commits in l.
+func commitIDs(l *list.List) []sha1 {
+	ids := []sha1{}
+	for e := l.Front(); e != nil; e = e.Next() {
+		ids = append(ids, e.Value.(*Commit).ID)
+	}
+	return ids
+}
+
+func sameIDs(a, b []sha1) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCommitsByCursor(t *testing.T) {
+	repo := newTestRepository(t)
+	defer func(size int) { CommitsRangeSize = size }(CommitsRangeSize)
+	CommitsRangeSize = 2
+
+	ids := []sha1{}
+	var parents []sha1
+	for when := int64(100); when <= 500; when += 100 {
+		id := testCommitFiles(t, repo, map[string]string{"file": string(rune('a' + when/100))}, when, parents...)
+		ids = append([]sha1{id}, ids...)
+		parents = []sha1{id}
+	}
+
+	tip, err := repo.GetCommit(ids[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, cursor, err := tip.CommitsByCursor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(commitIDs(page), ids[:2]) || cursor == "" {
+		t.Fatalf("first page: got %v, cursor %q", commitIDs(page), cursor)
+	}
+
+	// a push does not move pages of the old tip
+	pushed := testCommitFiles(t, repo, map[string]string{"file": "pushed"}, 600, ids[0])
+	newTip, err := repo.GetCommit(pushed.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := commitIDs(page)
+	for cursor != "" {
+		if page, cursor, err = newTip.CommitsByCursor(cursor); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, commitIDs(page)...)
+	}
+	if !sameIDs(got, ids) {
+		t.Errorf("got pages %v, want %v", got, ids)
+	}
+}
+
+func TestCommitsByCursorInvalid(t *testing.T) {
+	repo := newTestRepository(t)
+	defer func(size int) { CommitsRangeSize = size }(CommitsRangeSize)
+	CommitsRangeSize = 1
+
+	root := testCommitFiles(t, repo, map[string]string{"file": "a"}, 100)
+	head := testCommitFiles(t, repo, map[string]string{"file": "b"}, 200, root)
+	other := testCommitFiles(t, repo, map[string]string{"other": "c"}, 300)
+
+	_, cursor, err := repo.CommitsByFileAndCursor(head.String(), "file", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor == "" {
+		t.Fatal("expected cursor for the second page")
+	}
+	if _, _, err = repo.CommitsByFileAndCursor(head.String(), "file", cursor); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, revision, file, cursor string
+	}{
+		{"other file", head.String(), "other", cursor},
+		{"other history", other.String(), "file", cursor},
+		{"older revision", root.String(), "file", cursor},
+		{"truncated", head.String(), "file", base64.RawURLEncoding.EncodeToString(data[:len(data)-1])},
+		{"truncated tip", head.String(), "file", base64.RawURLEncoding.EncodeToString(data[:10])},
+		{"garbage", head.String(), "file", "!!"},
+	}
+	for _, test := range tests {
+		if _, _, err = repo.CommitsByFileAndCursor(test.revision, test.file, test.cursor); !IsErrInvalidCursor(err) {
+			t.Errorf("%s: got error %v, want invalid cursor", test.name, err)
+		}
+	}
+
+	// cursors of file history are not taken for the whole history
+	commit, err := repo.GetCommit(head.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = commit.CommitsByCursor(cursor); !IsErrInvalidCursor(err) {
+		t.Errorf("got error %v, want invalid cursor", err)
+	}
+}
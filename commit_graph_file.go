@@ -0,0 +1,478 @@
+package git
+
+import (
+	"bytes"
+	cryptosha1 "crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Support for objects/info/commit-graph files, format version 1 as
+// documented in git's technical/commit-graph-format.txt. The graph keeps
+// parents, root tree, commit date and generation number of every commit, so
+// walkers do not have to inflate and parse commit objects.
+//
+// The graph is only a cache: commits missing from it (pushed after it was
+// written) are read from objects as usual.
+
+const (
+	graphSignature     = "CGPH"
+	graphVersion       = 1
+	graphHashVersion   = 1
+	graphHeaderSize    = 8
+	graphChunkEntry    = 12
+	graphFanoutSize    = 256 * 4
+	graphDataSize      = 20 + 16
+	graphParentNone    = 0x70000000
+	graphParentOctopus = 0x80000000
+	graphEdgeLast      = 0x80000000
+	graphMaxGen        = 0x3FFFFFFF
+
+	graphChunkFanout = 0x4f494446 // "OIDF"
+	graphChunkLookup = 0x4f49444c // "OIDL"
+	graphChunkData   = 0x43444154 // "CDAT"
+	graphChunkEdges  = 0x45444745 // "EDGE"
+)
+
+var errBadCommitGraph = errors.New("malformed commit-graph file")
+
+type commitGraph struct {
+	fanout []byte
+	oids   []byte
+	data   []byte
+	edges  []byte
+	count  int
+}
+
+func (repo *Repository) commitGraphPath() string {
+	return filepath.Join(repo.objectsDir(), "info", "commit-graph")
+}
+
+// graphCheckInterval limits how often the commit-graph file is checked for
+// changes.
+const graphCheckInterval = time.Second
+
+// commitGraphFile caches the parsed commit-graph of a repository. The file
+// is read again when its size or modification time changes.
+type commitGraphFile struct {
+	lock    sync.Mutex
+	graph   *commitGraph
+	checked time.Time
+	size    int64
+	modTime time.Time
+}
+
+// commitGraph returns commit-graph of the repository, or nil if there is no
+// usable one. A graph written or removed by anyone is noticed within
+// graphCheckInterval.
+func (repo *Repository) commitGraph() *commitGraph {
+	file := repo.graph
+	if file == nil {
+		return nil
+	}
+
+	file.lock.Lock()
+	defer file.lock.Unlock()
+
+	now := time.Now()
+	if !file.checked.IsZero() && now.Sub(file.checked) < graphCheckInterval {
+		return file.graph
+	}
+	file.checked = now
+
+	info, err := os.Stat(repo.commitGraphPath())
+	if err != nil {
+		file.graph, file.size, file.modTime = nil, 0, time.Time{}
+		return nil
+	}
+	if info.Size() == file.size && info.ModTime().Equal(file.modTime) {
+		return file.graph
+	}
+	file.graph, file.size, file.modTime = nil, info.Size(), info.ModTime()
+
+	data, err := ioutil.ReadFile(repo.commitGraphPath())
+	if err != nil {
+		return nil
+	}
+
+	graph, err := parseCommitGraph(data)
+	if err != nil {
+		log("ignoring commit-graph of %s: %v", repo.Path, err)
+		return nil
+	}
+	file.graph = graph
+	return graph
+}
+
+// forgetCommitGraph makes the next lookup read commit-graph file again.
+func (repo *Repository) forgetCommitGraph() {
+	if file := repo.graph; file != nil {
+		file.lock.Lock()
+		file.checked = time.Time{}
+		file.lock.Unlock()
+	}
+}
+
+func parseCommitGraph(data []byte) (*commitGraph, error) {
+	if len(data) < graphHeaderSize+graphChunkEntry+20 || string(data[:4]) != graphSignature ||
+		data[4] != graphVersion || data[5] != graphHashVersion {
+		return nil, errBadCommitGraph
+	}
+
+	body, trailer := data[:len(data)-20], data[len(data)-20:]
+	if sum := cryptosha1.Sum(body); !bytes.Equal(sum[:], trailer) {
+		return nil, errors.New("commit-graph checksum mismatch")
+	}
+
+	graph := &commitGraph{}
+	chunks := int(data[6])
+	table := data[graphHeaderSize:]
+	if len(table) < (chunks+1)*graphChunkEntry {
+		return nil, errBadCommitGraph
+	}
+
+	for idx := 0; idx < chunks; idx++ {
+		entry := table[idx*graphChunkEntry:]
+		id := binary.BigEndian.Uint32(entry)
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[graphChunkEntry+4:])
+		if start > end || end > uint64(len(body)) {
+			return nil, errBadCommitGraph
+		}
+
+		chunk := body[start:end]
+		switch id {
+		case graphChunkFanout:
+			graph.fanout = chunk
+		case graphChunkLookup:
+			graph.oids = chunk
+		case graphChunkData:
+			graph.data = chunk
+		case graphChunkEdges:
+			graph.edges = chunk
+		}
+	}
+
+	if len(graph.fanout) != graphFanoutSize {
+		return nil, errBadCommitGraph
+	}
+
+	graph.count = int(binary.BigEndian.Uint32(graph.fanout[graphFanoutSize-4:]))
+	if len(graph.oids) != graph.count*20 || len(graph.data) != graph.count*graphDataSize {
+		return nil, errBadCommitGraph
+	}
+
+	// lookups trust the fanout, so it must not run backwards
+	var prev uint32
+	for idx := 0; idx < graphFanoutSize; idx += 4 {
+		cur := binary.BigEndian.Uint32(graph.fanout[idx:])
+		if cur < prev {
+			return nil, errBadCommitGraph
+		}
+		prev = cur
+	}
+
+	return graph, nil
+}
+
+func (g *commitGraph) idAt(pos int) sha1 {
+	return MustID(g.oids[pos*20:])
+}
+
+// lookup returns position of the commit in the graph.
+func (g *commitGraph) lookup(id sha1) (int, bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(g.fanout[(int(id[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(g.fanout[int(id[0])*4:]))
+
+	pos := lo + sort.Search(hi-lo, func(idx int) bool {
+		return bytes.Compare(g.oids[(lo+idx)*20:(lo+idx+1)*20], id[:]) >= 0
+	})
+	if pos < hi && bytes.Equal(g.oids[pos*20:(pos+1)*20], id[:]) {
+		return pos, true
+	}
+
+	return 0, false
+}
+
+// node builds walk node for commit at given position. Commit object itself
+// is not loaded. Parent positions are checked, as the checksum only proves
+// that the file was not damaged after it was written.
+func (g *commitGraph) node(pos int) (*walkNode, error) {
+	data := g.data[pos*graphDataSize:]
+	node := &walkNode{
+		id:   g.idAt(pos),
+		tree: MustID(data),
+	}
+
+	first := binary.BigEndian.Uint32(data[20:])
+	second := binary.BigEndian.Uint32(data[24:])
+	high := binary.BigEndian.Uint32(data[28:])
+	low := binary.BigEndian.Uint32(data[32:])
+
+	node.generation = high >> 2
+	node.when = int64(high&3)<<32 | int64(low)
+
+	parent := func(parentPos uint32) error {
+		if int64(parentPos) >= int64(g.count) {
+			return errBadCommitGraph
+		}
+		node.parents = append(node.parents, g.idAt(int(parentPos)))
+		return nil
+	}
+
+	if first != graphParentNone {
+		if err := parent(first); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case second == graphParentNone:
+	case second&graphParentOctopus != 0:
+		for idx := int(second &^ graphParentOctopus); ; idx++ {
+			if idx*4+4 > len(g.edges) {
+				return nil, errBadCommitGraph
+			}
+			edge := binary.BigEndian.Uint32(g.edges[idx*4:])
+			if err := parent(edge &^ graphEdgeLast); err != nil {
+				return nil, err
+			}
+			if edge&graphEdgeLast != 0 {
+				break
+			}
+		}
+	default:
+		if err := parent(second); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// graphTips returns commits pointed to by HEAD, branches and tags.
+func (repo *Repository) graphTips() ([]sha1, error) {
+	tips := []sha1{}
+	if head, err := repo.repo.ResolveRef("HEAD"); err == nil {
+		tips = append(tips, sha1(*head))
+	}
+
+	branches, err := repo.GetBranches()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, branch := range branches {
+		oid, err := repo.repo.ResolveBranch(branch)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, sha1(*oid))
+	}
+
+	tags, err := repo.GetTags()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range tags {
+		commit, err := repo.GetTagCommit(tag)
+		if err != nil {
+			// tags of trees and blobs have no place in the graph
+			continue
+		}
+		tips = append(tips, commit.ID)
+	}
+
+	return tips, nil
+}
+
+// WriteCommitGraph writes objects/info/commit-graph covering all commits
+// reachable from HEAD, branches and tags. Walkers and counters of this
+// handle use it right away, other open handles within graphCheckInterval.
+func (repo *Repository) WriteCommitGraph() error {
+	tips, err := repo.graphTips()
+	if err != nil {
+		return err
+	}
+
+	nodes := map[sha1]*walkNode{}
+	stack := tips
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := nodes[id]; ok {
+			continue
+		}
+
+		node, err := repo.loadWalkNode(id)
+		if err != nil {
+			return err
+		}
+		nodes[id] = node
+		stack = append(stack, node.parents...)
+	}
+
+	data := encodeCommitGraph(nodes)
+
+	path := repo.commitGraphPath()
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp_graph_")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	repo.forgetCommitGraph()
+	return nil
+}
+
+// computeGenerations assigns topological levels: roots get 1, other commits
+// one more than the maximum of their parents.
+func computeGenerations(nodes map[sha1]*walkNode) {
+	for _, start := range nodes {
+		if start.generation != 0 {
+			continue
+		}
+
+		stack := []*walkNode{start}
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+
+			ready := true
+			var gen uint32
+			for _, id := range node.parents {
+				parent := nodes[id]
+				if parent.generation == 0 {
+					ready = false
+					stack = append(stack, parent)
+				} else if parent.generation > gen {
+					gen = parent.generation
+				}
+			}
+
+			if ready {
+				stack = stack[:len(stack)-1]
+				if gen < graphMaxGen {
+					gen++
+				}
+				node.generation = gen
+			}
+		}
+	}
+}
+
+func encodeCommitGraph(nodes map[sha1]*walkNode) []byte {
+	computeGenerations(nodes)
+
+	ids := make([]sha1, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+
+	positions := make(map[sha1]uint32, len(ids))
+	for pos, id := range ids {
+		positions[id] = uint32(pos)
+	}
+
+	var fanout, lookup, cdat, edges bytes.Buffer
+	var counts [256]uint32
+	for _, id := range ids {
+		counts[id[0]]++
+	}
+
+	var total uint32
+	for _, count := range counts {
+		total += count
+		binary.Write(&fanout, binary.BigEndian, total)
+	}
+
+	for _, id := range ids {
+		node := nodes[id]
+		lookup.Write(id[:])
+		cdat.Write(node.tree[:])
+
+		first, second := uint32(graphParentNone), uint32(graphParentNone)
+		if len(node.parents) > 0 {
+			first = positions[node.parents[0]]
+		}
+		if len(node.parents) == 2 {
+			second = positions[node.parents[1]]
+		} else if len(node.parents) > 2 {
+			second = graphParentOctopus | uint32(edges.Len()/4)
+			for idx, parent := range node.parents[1:] {
+				edge := positions[parent]
+				if idx == len(node.parents)-2 {
+					edge |= graphEdgeLast
+				}
+				binary.Write(&edges, binary.BigEndian, edge)
+			}
+		}
+
+		when := uint64(node.when)
+		binary.Write(&cdat, binary.BigEndian, first)
+		binary.Write(&cdat, binary.BigEndian, second)
+		binary.Write(&cdat, binary.BigEndian, node.generation<<2|uint32(when>>32)&3)
+		binary.Write(&cdat, binary.BigEndian, uint32(when))
+	}
+
+	type chunk struct {
+		id   uint32
+		data []byte
+	}
+	chunks := []chunk{
+		{graphChunkFanout, fanout.Bytes()},
+		{graphChunkLookup, lookup.Bytes()},
+		{graphChunkData, cdat.Bytes()},
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{graphChunkEdges, edges.Bytes()})
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString(graphSignature)
+	out.Write([]byte{graphVersion, graphHashVersion, byte(len(chunks)), 0})
+
+	offset := uint64(graphHeaderSize + (len(chunks)+1)*graphChunkEntry)
+	for _, c := range chunks {
+		binary.Write(out, binary.BigEndian, c.id)
+		binary.Write(out, binary.BigEndian, offset)
+		offset += uint64(len(c.data))
+	}
+	binary.Write(out, binary.BigEndian, uint32(0))
+	binary.Write(out, binary.BigEndian, offset)
+
+	for _, c := range chunks {
+		out.Write(c.data)
+	}
+
+	sum := cryptosha1.Sum(out.Bytes())
+	out.Write(sum[:])
+	return out.Bytes()
+}
@@ -0,0 +1,111 @@
+package git
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testID(b byte) sha1 {
+	var id sha1
+	id[0], id[19] = b, b
+	return id
+}
+
+func TestCommitGraphRoundTrip(t *testing.T) {
+	root := &walkNode{id: testID(0x10), tree: testID(0xa0), when: 1000}
+	left := &walkNode{id: testID(0x20), tree: testID(0xa1), when: 2000, parents: []sha1{root.id}}
+	right := &walkNode{id: testID(0x30), tree: testID(0xa2), when: 3000, parents: []sha1{root.id}}
+	octopus := &walkNode{id: testID(0x05), tree: testID(0xa3), when: 1 << 33, parents: []sha1{left.id, right.id, root.id}}
+
+	nodes := map[sha1]*walkNode{}
+	for _, node := range []*walkNode{root, left, right, octopus} {
+		nodes[node.id] = node
+	}
+
+	graph, err := parseCommitGraph(encodeCommitGraph(nodes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generations := map[sha1]uint32{root.id: 1, left.id: 2, right.id: 2, octopus.id: 3}
+	for id, want := range nodes {
+		pos, ok := graph.lookup(id)
+		if !ok {
+			t.Fatalf("commit %s not found", id)
+		}
+
+		got, err := graph.node(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.id != want.id || got.tree != want.tree || got.when != want.when {
+			t.Errorf("commit %s: got %+v, want %+v", id, got, want)
+		}
+		if len(got.parents) != len(want.parents) {
+			t.Fatalf("commit %s: got parents %v, want %v", id, got.parents, want.parents)
+		}
+		for idx := range want.parents {
+			if got.parents[idx] != want.parents[idx] {
+				t.Errorf("commit %s: got parents %v, want %v", id, got.parents, want.parents)
+			}
+		}
+		if got.generation != generations[id] {
+			t.Errorf("commit %s: got generation %d, want %d", id, got.generation, generations[id])
+		}
+	}
+
+	if _, ok := graph.lookup(testID(0x40)); ok {
+		t.Error("found commit missing in the graph")
+	}
+}
+
+func TestCommitGraphBadParent(t *testing.T) {
+	root := &walkNode{id: testID(0x10), tree: testID(0xa0), when: 1000}
+	child := &walkNode{id: testID(0x20), tree: testID(0xa1), when: 2000, parents: []sha1{root.id}}
+	graph, err := parseCommitGraph(encodeCommitGraph(map[sha1]*walkNode{root.id: root, child.id: child}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a parent position past the end, as a buggy writer could store it
+	pos, _ := graph.lookup(child.id)
+	binary.BigEndian.PutUint32(graph.data[pos*graphDataSize+20:], 7)
+	if _, err = graph.node(pos); err == nil {
+		t.Error("expected error for parent position out of range")
+	}
+}
+
+func TestCommitGraphChecksum(t *testing.T) {
+	root := &walkNode{id: testID(0x10), tree: testID(0xa0), when: 1000}
+	data := encodeCommitGraph(map[sha1]*walkNode{root.id: root})
+	data[len(data)/2] ^= 0xff
+	if _, err := parseCommitGraph(data); err == nil {
+		t.Error("expected error for damaged file")
+	}
+}
+
+func TestCommitGraphShared(t *testing.T) {
+	repo := newTestRepository(t)
+
+	root := &walkNode{id: testID(0x10), tree: testID(0xa0), when: 1000}
+	path := repo.commitGraphPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, encodeCommitGraph(map[sha1]*walkNode{root.id: root}), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	graph := repo.commitGraph()
+	if graph == nil {
+		t.Fatal("commit-graph is not read")
+	}
+	for _, view := range []*Repository{repo.withOverlay(newMemoryObjects()), repo.withFallback(repo)} {
+		if view.commitGraph() != graph {
+			t.Error("derived handle does not share the parsed commit-graph")
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package git
+
+import (
+	"context"
+	"io"
+	"regexp"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// CommitIterator yields history commits one at a time. Unlike list based
+// functions it does not materialize the whole history: commits are parsed
+// one by one and dropped once yielded. Ids of visited commits are still
+// kept until Close to avoid walking merged history twice, so memory grows
+// by a few dozen bytes per visited commit, as it does for git itself.
+type CommitIterator interface {
+	// Next returns the next commit, io.EOF when history is exhausted, or
+	// context error if the walk was cancelled.
+	Next() (*Commit, error)
+	// Close releases walk state. Next returns io.EOF after Close.
+	Close()
+}
+
+type walkIterator struct {
+	repo  *Repository
+	walk  *revWalk
+	match nodeMatcher
+	// skip is the number of matching commits to pass over, left is the
+	// number of commits still to yield, negative if not limited
+	skip, left int
+}
+
+// nodeMatcher filters commits yielded by iterator.
+type nodeMatcher func(node *walkNode) (bool, error)
+
+func newWalkIterator(ctx context.Context, repo *Repository, walk *revWalk, match nodeMatcher) *walkIterator {
+	walk.withContext(ctx)
+	return &walkIterator{
+		repo:  repo,
+		walk:  walk,
+		match: match,
+		left:  -1,
+	}
+}
+
+func (it *walkIterator) Next() (*Commit, error) {
+	if it.walk == nil {
+		return nil, io.EOF
+	}
+
+	for it.left != 0 {
+		node, err := it.walk.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if it.match != nil {
+			ok, err := it.match(node)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+
+		if it.skip > 0 {
+			it.skip--
+			continue
+		}
+		if it.left > 0 {
+			it.left--
+		}
+		return node.commit(it.repo)
+	}
+	return nil, io.EOF
+}
+
+func (it *walkIterator) Close() {
+	it.walk = nil
+}
+
+// iterate starts walk at id. Errors of the first step are reported by Next.
+func (repo *Repository) iterate(ctx context.Context, walk *revWalk, id sha1, match nodeMatcher) CommitIterator {
+	return repo.iterateRange(ctx, walk, id, match, 0, -1)
+}
+
+// iterateRange is iterate yielding at most limit commits after skipping
+// skip of them, negative limit means all of them.
+func (repo *Repository) iterateRange(ctx context.Context, walk *revWalk, id sha1, match nodeMatcher, skip, limit int) CommitIterator {
+	it := newWalkIterator(ctx, repo, walk, match)
+	it.skip, it.left = skip, limit
+	if err := walk.push(id); err != nil {
+		return &errIterator{err}
+	}
+	return it
+}
+
+// errIterator fails on every Next until closed.
+type errIterator struct {
+	err error
+}
+
+func (it *errIterator) Next() (*Commit, error) {
+	return nil, it.err
+}
+
+func (it *errIterator) Close() {
+	it.err = io.EOF
+}
+
+// messageMatcher matches commits whose message matches keyword as a regular
+// expression, ignoring case, the way `git log -i --grep` does.
+func messageMatcher(repo *Repository, keyword string) (nodeMatcher, error) {
+	pattern, err := regexp.Compile("(?i)" + keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(node *walkNode) (bool, error) {
+		raw, err := node.rawCommit(repo)
+		if err != nil {
+			return false, err
+		}
+		return pattern.MatchString(raw.Message), nil
+	}, nil
+}
+
+// CommitsBeforeIter iterates over all commits reachable from this one.
+func (c *Commit) CommitsBeforeIter(ctx context.Context) CommitIterator {
+	return c.repo.iterate(ctx, newRevWalk(c.repo), c.ID, nil)
+}
+
+// CommitsByRangeIter iterates over the same page of commits as
+// CommitsByRange returns.
+func (c *Commit) CommitsByRangeIter(ctx context.Context, page int) CommitIterator {
+	return c.repo.iterateRange(ctx, newRevWalk(c.repo), c.ID, nil, (page-1)*CommitsRangeSize, CommitsRangeSize)
+}
+
+// SearchCommitsIter iterates over history commits matching keyword the same
+// way SearchCommits does.
+func (c *Commit) SearchCommitsIter(ctx context.Context, keyword string) CommitIterator {
+	match, err := messageMatcher(c.repo, keyword)
+	if err != nil {
+		return &errIterator{err}
+	}
+
+	return c.repo.iterate(ctx, newRevWalk(c.repo), c.ID, match)
+}
+
+// CommitsByFileIter iterates over commits changing file in history of revision.
+func (repo *Repository) CommitsByFileIter(ctx context.Context, revision, file string) CommitIterator {
+	oid, err := rawgit.ResolveName(repo.repo, revision)
+	if err != nil {
+		return &errIterator{err}
+	}
+
+	walk := newRevWalk(repo)
+	walk.limitToPath(file, false)
+	return repo.iterate(ctx, walk, sha1(*oid), nil)
+}
@@ -0,0 +1,90 @@
+package git
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// iterIDs returns ids of all commits yielded by it.
+func iterIDs(t *testing.T, it CommitIterator) []sha1 {
+	defer it.Close()
+
+	ids := []sha1{}
+	for {
+		commit, err := it.Next()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, commit.ID)
+	}
+}
+
+func TestSearchCommitsIter(t *testing.T) {
+	repo := newTestRepository(t)
+
+	var parents []sha1
+	for idx, message := range []string{"Fix a.b\n", "fix aXb\n", "other\n"} {
+		sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(int64(100*(idx+1)), 0).UTC()}
+		id, err := writeCommit(repo, testTree(t, repo, nil).ID, parents, sig, nil, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parents = []sha1{id}
+	}
+
+	tip, err := repo.GetCommit(parents[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyword := range []string{"a.b", "FIX", `a\.b`, "nothing"} {
+		commits, err := tip.SearchCommits(keyword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := iterIDs(t, tip.SearchCommitsIter(context.Background(), keyword)), commitIDs(commits); !sameIDs(got, want) {
+			t.Errorf("%q: iterator found %v, list %v", keyword, got, want)
+		}
+	}
+
+	if _, err = tip.SearchCommits("("); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	it := tip.SearchCommitsIter(context.Background(), "(")
+	if _, err = it.Next(); err == nil || err == io.EOF {
+		t.Errorf("got %v, want error for invalid pattern", err)
+	}
+	it.Close()
+	if _, err = it.Next(); err != io.EOF {
+		t.Errorf("got %v after Close, want io.EOF", err)
+	}
+}
+
+func TestCommitsByRangeIter(t *testing.T) {
+	repo := newTestRepository(t)
+	defer func(size int) { CommitsRangeSize = size }(CommitsRangeSize)
+	CommitsRangeSize = 2
+
+	var parents []sha1
+	for when := int64(100); when <= 500; when += 100 {
+		parents = []sha1{testCommitFiles(t, repo, map[string]string{"file": string(rune('a' + when/100))}, when, parents...)}
+	}
+	tip, err := repo.GetCommit(parents[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for page := 1; page <= 4; page++ {
+		commits, err := tip.CommitsByRange(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := iterIDs(t, tip.CommitsByRangeIter(context.Background(), page)), commitIDs(commits); !sameIDs(got, want) {
+			t.Errorf("page %d: iterator got %v, list %v", page, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package git
+
+// FileStats is the change summary of a single file, as in `git diff --numstat`.
+type FileStats struct {
+	Path string
+	// OldPath is set for renamed and copied files only
+	OldPath    string
+	Insertions int
+	Deletions  int
+	// IsBinary files have no line counts
+	IsBinary bool
+}
+
+// CommitStats is the change summary of a commit against its first parent.
+type CommitStats struct {
+	Files      []*FileStats
+	Insertions int
+	Deletions  int
+}
+
+func (s *CommitStats) FilesChanged() int {
+	return len(s.Files)
+}
+
+// StatsCacheSize is how many change summaries of commits a repository
+// handle keeps, it applies to handles opened afterwards.
+var StatsCacheSize = 1000
+
+// Stats returns change summary of the commit. Merges are compared with
+// their first parent, root commits with an empty tree. Result is cached by
+// commit id and shared, it must not be modified.
+func (c *Commit) Stats() (*CommitStats, error) {
+	return c.repo.commitStats(c.ID)
+}
+
+// CommitStats returns change summaries of given commits in the same order,
+// see Commit.Stats.
+func (repo *Repository) CommitStats(ids []sha1) ([]*CommitStats, error) {
+	stats := make([]*CommitStats, len(ids))
+	for idx, id := range ids {
+		var err error
+		if stats[idx], err = repo.commitStats(id); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+func (repo *Repository) commitStats(id sha1) (*CommitStats, error) {
+	if cached, ok := repo.statsCache.Get(id.String()); ok {
+		return cached.(*CommitStats), nil
+	}
+
+	parentTree, treeID, err := repo.commitTrees(id)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := repo.diffTrees(parentTree, treeID, DiffTreeOptions{DetectRenames: true})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &CommitStats{Files: make([]*FileStats, 0, len(changes))}
+	for _, change := range changes {
+		file, err := repo.fileStats(change)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Files = append(stats.Files, file)
+		stats.Insertions += file.Insertions
+		stats.Deletions += file.Deletions
+	}
+
+	repo.statsCache.Set(id.String(), stats)
+	return stats, nil
+}
+
+func (repo *Repository) fileStats(change *TreeChange) (*FileStats, error) {
+	file := &FileStats{Path: change.Path()}
+	if change.Type == CHANGE_RENAMED || change.Type == CHANGE_COPIED {
+		file.OldPath = change.OldPath
+	}
+	if change.OldID == change.NewID {
+		return file, nil
+	}
+
+	oldData, err := repo.diffContent(change.OldID, change.OldMode)
+	if err != nil {
+		return nil, err
+	}
+
+	newData, err := repo.diffContent(change.NewID, change.NewMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBinary(oldData) || isBinary(newData) {
+		file.IsBinary = true
+		return file, nil
+	}
+
+	// line counts do not depend on how changes are placed, so no
+	// compaction and hunks are needed
+	a, b := newDiffFiles(oldData, newData, &LineDiffOptions{})
+	myersRange(a, b, 0, len(a.recs), 0, len(b.recs), false)
+	for idx := range a.recs {
+		if a.isChanged(idx) {
+			file.Deletions++
+		}
+	}
+	for idx := range b.recs {
+		if b.isChanged(idx) {
+			file.Insertions++
+		}
+	}
+
+	return file, nil
+}
@@ -0,0 +1,745 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Combined diff of a merge commit against all its parents, as `git diff -c`
+// and `git diff --cc` show it. Only files which differ from every parent are
+// included, each line carries a marker column per parent.
+
+// maxCombinedParents is the limit of parents, lines keep parent sets in
+// bitmasks.
+const maxCombinedParents = 64
+
+// CombinedDiffOptions controls combined diff generation.
+type CombinedDiffOptions struct {
+	LineDiffOptions
+	// Dense drops hunks where the result takes one of two versions without
+	// modification, as --cc does.
+	Dense bool
+}
+
+// DefaultCombinedDiffOptions returns options matching `git show` on merges.
+func DefaultCombinedDiffOptions() CombinedDiffOptions {
+	return CombinedDiffOptions{
+		LineDiffOptions: LineDiffOptions{
+			ContextLines:    DefaultContextLines,
+			IndentHeuristic: true,
+		},
+		Dense: true,
+	}
+}
+
+// CombinedLine is a line of combined hunk. Markers has a column per parent:
+// '+' when line was added relative to that parent, '-' when it was removed
+// from it and ' ' otherwise.
+type CombinedLine struct {
+	Type    DiffLineType
+	Markers string
+	Content string
+	// NewLine is 1-based line number in the result, 0 for removed lines
+	NewLine int
+}
+
+// CombinedHunk is a group of changed lines of the result.
+type CombinedHunk struct {
+	// ParentStarts and ParentLines are ranges of every parent
+	ParentStarts []int
+	ParentLines  []int
+	NewStart     int
+	NewLines     int
+	Section      string
+	Lines        []*CombinedLine
+}
+
+// Header returns hunk header line without line terminator.
+func (h *CombinedHunk) Header() string {
+	marker := strings.Repeat("@", len(h.ParentStarts)+1)
+	header := &bytes.Buffer{}
+	header.WriteString(marker)
+	for idx := range h.ParentStarts {
+		fmt.Fprintf(header, " -%d,%d", h.ParentStarts[idx], h.ParentLines[idx])
+	}
+	fmt.Fprintf(header, " +%d,%d %s", h.NewStart, h.NewLines, marker)
+	if h.Section != "" {
+		header.WriteString(" " + h.Section)
+	}
+	return header.String()
+}
+
+// CombinedFilePatch is a combined diff of a single file. Mode is zero when
+// the merge deleted the file, parent modes are zero where it did not exist.
+type CombinedFilePatch struct {
+	Path        string
+	Mode        EntryMode
+	ID          sha1
+	ParentModes []EntryMode
+	ParentIDs   []sha1
+	IsBinary    bool
+	Hunks       []*CombinedHunk
+}
+
+func (p *CombinedFilePatch) modeDiffers() bool {
+	for _, mode := range p.ParentModes {
+		if mode != p.Mode {
+			return true
+		}
+	}
+	return false
+}
+
+// CombinedDiff returns combined diff of the commit against its parents.
+func (c *Commit) CombinedDiff(opts CombinedDiffOptions) ([]*CombinedFilePatch, error) {
+	return c.repo.combinedDiff(c.ID, opts)
+}
+
+// WriteCombinedPatch writes combined diff of the commit to w in the format
+// of `git diff --cc`, or `git diff -c` when opts.Dense is not set.
+func (c *Commit) WriteCombinedPatch(w io.Writer, opts CombinedDiffOptions) error {
+	patches, err := c.repo.combinedDiff(c.ID, opts)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, patch := range patches {
+		if err = writeCombinedFilePatch(bw, patch, opts.Dense); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (repo *Repository) combinedDiff(id sha1, opts CombinedDiffOptions) ([]*CombinedFilePatch, error) {
+	node, err := repo.loadWalkNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(node.parents) > maxCombinedParents {
+		return nil, fmt.Errorf("combined diff supports at most %d parents", maxCombinedParents)
+	}
+
+	byPath := map[string][]*TreeChange{}
+	paths := []string{}
+	for n, parent := range node.parents {
+		parentNode, err := repo.loadWalkNode(parent)
+		if err != nil {
+			return nil, err
+		}
+
+		changes, err := repo.diffTrees(parentNode.tree, node.tree, DiffTreeOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, change := range changes {
+			path := change.Path()
+			if n == 0 {
+				byPath[path] = make([]*TreeChange, len(node.parents))
+				paths = append(paths, path)
+			}
+			if perParent, ok := byPath[path]; ok {
+				perParent[n] = change
+			}
+		}
+	}
+
+	patches := []*CombinedFilePatch{}
+nextPath:
+	for _, path := range paths {
+		for _, change := range byPath[path] {
+			if change == nil {
+				// same as in one of parents
+				continue nextPath
+			}
+		}
+
+		patch, err := repo.combinedFilePatch(path, byPath[path], &opts)
+		if err != nil {
+			return nil, err
+		}
+		if patch != nil {
+			patches = append(patches, patch)
+		}
+	}
+
+	return patches, nil
+}
+
+// combinedFilePatch computes combined hunks of the file. It returns nil if
+// the file has nothing interesting to show.
+func (repo *Repository) combinedFilePatch(path string, changes []*TreeChange, opts *CombinedDiffOptions) (*CombinedFilePatch, error) {
+	patch := &CombinedFilePatch{
+		Path:        path,
+		Mode:        changes[0].NewMode,
+		ID:          changes[0].NewID,
+		ParentModes: make([]EntryMode, len(changes)),
+		ParentIDs:   make([]sha1, len(changes)),
+	}
+	for n, change := range changes {
+		patch.ParentModes[n] = change.OldMode
+		patch.ParentIDs[n] = change.OldID
+	}
+
+	result, err := repo.diffContent(patch.ID, patch.Mode)
+	if err != nil {
+		return nil, err
+	}
+	patch.IsBinary = isBinary(result)
+
+	parents := make([][]byte, len(changes))
+	for n := range changes {
+		if parents[n], err = repo.diffContent(patch.ParentIDs[n], patch.ParentModes[n]); err != nil {
+			return nil, err
+		}
+		patch.IsBinary = patch.IsBinary || isBinary(parents[n])
+	}
+
+	if patch.IsBinary {
+		return patch, nil
+	}
+
+	lines := newCombinedLines(result, len(parents))
+	lines.context = opts.ContextLines
+	for n := range parents {
+		lines.combineParent(parents[n], result, n, &opts.LineDiffOptions)
+	}
+
+	if !lines.makeHunks(opts.Dense) && !patch.modeDiffers() {
+		return nil, nil
+	}
+
+	patch.Hunks = lines.hunks()
+	return patch, nil
+}
+
+// combinedLost is a line removed from some of the parents.
+type combinedLost struct {
+	line    []byte
+	parents uint64
+}
+
+// combinedLine is a line of the result, lines removed before it and its
+// status. The line after the last one holds removals at the end.
+type combinedLine struct {
+	line []byte
+	// flag has a bit for every parent the line was added to
+	flag        uint64
+	mark        bool
+	noPreDelete bool
+
+	lost []*combinedLost
+	// lost lines of the parent being processed
+	pending [][]byte
+	// number of the first line of every parent shown with this line
+	parentLine []int
+}
+
+type combinedLines struct {
+	lines   []*combinedLine
+	cnt     int
+	parents int
+	allMask uint64
+	context int
+}
+
+func newCombinedLines(result []byte, parents int) *combinedLines {
+	split := splitLines(result)
+	cl := &combinedLines{
+		lines:   make([]*combinedLine, len(split)+2),
+		cnt:     len(split),
+		parents: parents,
+	}
+	if parents == maxCombinedParents {
+		cl.allMask = ^uint64(0)
+	} else {
+		cl.allMask = uint64(1)<<uint(parents) - 1
+	}
+
+	for idx := range cl.lines {
+		cl.lines[idx] = &combinedLine{parentLine: make([]int, parents)}
+		if idx < len(split) {
+			cl.lines[idx].line = split[idx]
+		}
+	}
+	return cl
+}
+
+// combineParent diffs parent against the result and records which lines
+// were added and removed relative to it.
+func (cl *combinedLines) combineParent(parent, result []byte, n int, opts *LineDiffOptions) {
+	nmask := uint64(1) << uint(n)
+	a, _, changes := diffLineFiles(parent, result, opts)
+	for _, change := range changes {
+		if change.ignore {
+			continue
+		}
+
+		bucket := cl.lines[change.newStart]
+		for idx := change.oldStart; idx < change.oldStart+change.oldCount; idx++ {
+			bucket.pending = append(bucket.pending, bytes.TrimSuffix(a.lines[idx], []byte{'\n'}))
+		}
+		for idx := change.newStart; idx < change.newStart+change.newCount; idx++ {
+			cl.lines[idx].flag |= nmask
+		}
+	}
+
+	parentLine := 1
+	lno := 0
+	for ; lno <= cl.cnt; lno++ {
+		line := cl.lines[lno]
+		line.parentLine[n] = parentLine
+
+		if len(line.pending) > 0 {
+			line.lost = coalesceLost(line.lost, line.pending, nmask, opts)
+			line.pending = nil
+		}
+
+		for _, lost := range line.lost {
+			if lost.parents&nmask != 0 {
+				parentLine++
+			}
+		}
+		if lno < cl.cnt && line.flag&nmask == 0 {
+			parentLine++
+		}
+	}
+	cl.lines[lno].parentLine[n] = parentLine
+}
+
+// coalesceLost merges lines removed from another parent into the list of
+// lost lines, using their longest common subsequence so lines removed from
+// several parents are shown once.
+func coalesceLost(base []*combinedLost, added [][]byte, nmask uint64, opts *LineDiffOptions) []*combinedLost {
+	if len(base) == 0 {
+		result := make([]*combinedLost, len(added))
+		for idx, line := range added {
+			result[idx] = &combinedLost{line: line, parents: nmask}
+		}
+		return result
+	}
+
+	const (
+		fromBase = iota
+		fromNew
+		fromBoth
+	)
+
+	lcs := make([][]int, len(base)+1)
+	directions := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(added)+1)
+		directions[i] = make([]int, len(added)+1)
+		directions[i][0] = fromBase
+	}
+	for j := 1; j <= len(added); j++ {
+		directions[0][j] = fromNew
+	}
+
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(added); j++ {
+			switch {
+			case bytes.Equal(normalizeLine(base[i-1].line, opts), normalizeLine(added[j-1], opts)):
+				lcs[i][j] = lcs[i-1][j-1] + 1
+				directions[i][j] = fromBoth
+			case lcs[i][j-1] >= lcs[i-1][j]:
+				lcs[i][j] = lcs[i][j-1]
+				directions[i][j] = fromNew
+			default:
+				lcs[i][j] = lcs[i-1][j]
+				directions[i][j] = fromBase
+			}
+		}
+	}
+
+	// walk back from the ends, collecting lines in reverse
+	reversed := make([]*combinedLost, 0, len(base)+len(added))
+	for i, j := len(base), len(added); i != 0 || j != 0; {
+		switch directions[i][j] {
+		case fromBoth:
+			base[i-1].parents |= nmask
+			reversed = append(reversed, base[i-1])
+			i--
+			j--
+		case fromNew:
+			reversed = append(reversed, &combinedLost{line: added[j-1], parents: nmask})
+			j--
+		default:
+			reversed = append(reversed, base[i-1])
+			i--
+		}
+	}
+
+	result := make([]*combinedLost, len(reversed))
+	for idx, lost := range reversed {
+		result[len(reversed)-1-idx] = lost
+	}
+	return result
+}
+
+func (cl *combinedLines) interesting(idx int) bool {
+	return cl.lines[idx].flag&cl.allMask != 0 || len(cl.lines[idx].lost) > 0
+}
+
+// adjustHunkTail steps back from the first uninteresting line i if the last
+// line of the hunk is interesting only for removals before it, the line
+// itself serves as context then.
+func (cl *combinedLines) adjustHunkTail(hunkBegin, i int) int {
+	if hunkBegin+1 <= i && cl.lines[i-1].flag&cl.allMask == 0 {
+		i--
+	}
+	return i
+}
+
+// findNext returns the next marked line starting from i, or the next
+// unmarked one.
+func (cl *combinedLines) findNext(i int, unmarked bool) int {
+	for ; i <= cl.cnt; i++ {
+		if cl.lines[i].mark != unmarked {
+			return i
+		}
+	}
+	return i
+}
+
+// makeHunks marks lines to show and reports whether there are any.
+func (cl *combinedLines) makeHunks(dense bool) bool {
+	for idx := 0; idx <= cl.cnt; idx++ {
+		cl.lines[idx].mark = cl.interesting(idx)
+	}
+	if !dense {
+		return cl.giveContext()
+	}
+
+	// hunks with changes from one parent only, or the same changes from all
+	// but one, are not interesting
+	for i := 0; i <= cl.cnt; {
+		for i <= cl.cnt && !cl.lines[i].mark {
+			i++
+		}
+		if i > cl.cnt {
+			break
+		}
+
+		hunkBegin := i
+		j := i + 1
+		for ; j <= cl.cnt; j++ {
+			if cl.lines[j].mark {
+				continue
+			}
+
+			// look beyond the end for an interesting line within context
+			la := cl.adjustHunkTail(hunkBegin, j) + cl.context
+			if la > cl.cnt+1 {
+				la = cl.cnt + 1
+			}
+			contin := false
+			for la > 0 {
+				if la--; la < j {
+					break
+				}
+				if cl.lines[la].mark {
+					contin = true
+					break
+				}
+			}
+			if !contin {
+				break
+			}
+			j = la
+		}
+		hunkEnd := j
+
+		var sameDiff uint64
+		hasInteresting := false
+		for j = i; j < hunkEnd && !hasInteresting; j++ {
+			if thisDiff := cl.lines[j].flag & cl.allMask; thisDiff != 0 {
+				if sameDiff == 0 {
+					sameDiff = thisDiff
+				} else if sameDiff != thisDiff {
+					hasInteresting = true
+					break
+				}
+			}
+			for _, lost := range cl.lines[j].lost {
+				if sameDiff == 0 {
+					sameDiff = lost.parents
+				} else if sameDiff != lost.parents {
+					hasInteresting = true
+					break
+				}
+			}
+		}
+
+		if !hasInteresting && sameDiff != cl.allMask {
+			for j = hunkBegin; j < hunkEnd; j++ {
+				cl.lines[j].mark = false
+			}
+		}
+		i = hunkEnd
+	}
+
+	return cl.giveContext()
+}
+
+// giveContext marks context lines around interesting ones, joining groups
+// separated by short gaps.
+func (cl *combinedLines) giveContext() bool {
+	i := cl.findNext(0, false)
+	if i > cl.cnt {
+		return false
+	}
+
+	for i <= cl.cnt {
+		j := 0
+		if cl.context < i {
+			j = i - cl.context
+		}
+
+		// paint a few lines before the first interesting line
+		for ; j < i; j++ {
+			if !cl.lines[j].mark {
+				cl.lines[j].noPreDelete = true
+			}
+			cl.lines[j].mark = true
+		}
+
+		for {
+			j = cl.findNext(i, true)
+			if j > cl.cnt {
+				// the rest are all interesting
+				return true
+			}
+
+			// lookahead context lines
+			k := cl.findNext(j, false)
+			j = cl.adjustHunkTail(i, j)
+
+			if k < j+cl.context {
+				// gap is small, paint it
+				for ; j < k; j++ {
+					cl.lines[j].mark = true
+				}
+				i = k
+				continue
+			}
+
+			// paint the trailing edge
+			i = k
+			k = j + cl.context
+			if k > cl.cnt+1 {
+				k = cl.cnt + 1
+			}
+			for ; j < k; j++ {
+				cl.lines[j].mark = true
+			}
+			break
+		}
+	}
+	return true
+}
+
+// combinedSection returns hunk header context from the line, as git does for
+// combined diffs: at most 40 bytes, without the last non-blank one.
+func combinedSection(line []byte) string {
+	end := 0
+	for idx := 0; idx < 40 && idx < len(line); idx++ {
+		if line[idx] == '\n' || line[idx] == 0 {
+			break
+		}
+		if !isSpace(line[idx]) {
+			end = idx
+		}
+	}
+	return string(line[:end])
+}
+
+// hunks turns marked lines into hunks.
+func (cl *combinedLines) hunks() []*CombinedHunk {
+	hunks := []*CombinedHunk{}
+	lno := 0
+	for {
+		var section []byte
+		for lno <= cl.cnt && !cl.lines[lno].mark {
+			if line := cl.lines[lno].line; len(line) > 0 && isFuncLine(line) {
+				section = line
+			}
+			lno++
+		}
+		if lno > cl.cnt {
+			break
+		}
+
+		hunkEnd := lno + 1
+		for hunkEnd <= cl.cnt && cl.lines[hunkEnd].mark {
+			hunkEnd++
+		}
+
+		rlines := hunkEnd - lno
+		if hunkEnd > cl.cnt {
+			// pointing at the last removal
+			rlines--
+		}
+
+		nullContext := 0
+		if cl.context == 0 {
+			// lines which only hold removals in front of them are not shown
+			for j := lno; j < hunkEnd; j++ {
+				if cl.lines[j].flag == 0 {
+					nullContext++
+				}
+			}
+			rlines -= nullContext
+			if rlines < 0 {
+				// removal of the whole file
+				rlines = 0
+			}
+		}
+
+		hunk := &CombinedHunk{
+			ParentStarts: make([]int, cl.parents),
+			ParentLines:  make([]int, cl.parents),
+			NewStart:     lno + 1,
+			NewLines:     rlines,
+			Section:      combinedSection(section),
+		}
+		for n := 0; n < cl.parents; n++ {
+			start, end := cl.lines[lno].parentLine[n], cl.lines[hunkEnd].parentLine[n]
+			hunk.ParentStarts[n] = start
+			hunk.ParentLines[n] = end - start - nullContext
+		}
+
+		markers := make([]byte, cl.parents)
+		for lno < hunkEnd {
+			line := cl.lines[lno]
+			lno++
+
+			if !line.noPreDelete {
+				for _, lost := range line.lost {
+					for n := range markers {
+						markers[n] = ' '
+						if lost.parents&(1<<uint(n)) != 0 {
+							markers[n] = '-'
+						}
+					}
+					hunk.Lines = append(hunk.Lines, &CombinedLine{
+						Type:    DIFF_LINE_DEL,
+						Markers: string(markers),
+						Content: string(lost.line),
+					})
+				}
+			}
+
+			if lno > cl.cnt {
+				break
+			}
+			if line.flag == 0 && cl.context == 0 {
+				continue
+			}
+
+			typ := DIFF_LINE_PLAIN
+			if line.flag != 0 {
+				typ = DIFF_LINE_ADD
+			}
+			for n := range markers {
+				markers[n] = ' '
+				if line.flag&(1<<uint(n)) != 0 {
+					markers[n] = '+'
+				}
+			}
+			hunk.Lines = append(hunk.Lines, &CombinedLine{
+				Type:    typ,
+				Markers: string(markers),
+				Content: string(bytes.TrimSuffix(line.line, []byte{'\n'})),
+				NewLine: lno,
+			})
+		}
+
+		hunks = append(hunks, hunk)
+	}
+
+	return hunks
+}
+
+func writeCombinedFilePatch(w io.Writer, patch *CombinedFilePatch, dense bool) error {
+	header := &bytes.Buffer{}
+	if dense {
+		fmt.Fprintf(header, "diff --cc %s\n", quotePath(patch.Path))
+	} else {
+		fmt.Fprintf(header, "diff --combined %s\n", quotePath(patch.Path))
+	}
+
+	header.WriteString("index ")
+	for n, id := range patch.ParentIDs {
+		if n > 0 {
+			header.WriteByte(',')
+		}
+		header.WriteString(id.String()[:indexAbbrev])
+	}
+	header.WriteString(".." + patch.ID.String()[:indexAbbrev] + "\n")
+
+	deleted := patch.Mode == 0
+	added := !deleted
+	for _, mode := range patch.ParentModes {
+		if mode != 0 {
+			added = false
+		}
+	}
+
+	if patch.modeDiffers() {
+		if added {
+			fmt.Fprintf(header, "new file mode %06o\n", patch.Mode)
+		} else {
+			if deleted {
+				header.WriteString("deleted file ")
+			}
+			header.WriteString("mode ")
+			for n, mode := range patch.ParentModes {
+				if n > 0 {
+					header.WriteByte(',')
+				}
+				fmt.Fprintf(header, "%06o", mode)
+			}
+			if !deleted {
+				fmt.Fprintf(header, "..%06o", patch.Mode)
+			}
+			header.WriteByte('\n')
+		}
+	} else {
+		added, deleted = false, false
+	}
+
+	if patch.IsBinary {
+		header.WriteString("Binary files differ\n")
+		_, err := w.Write(header.Bytes())
+		return err
+	}
+
+	oldName, newName := quotePath("a/"+patch.Path), quotePath("b/"+patch.Path)
+	if added {
+		oldName = "/dev/null"
+	}
+	if deleted {
+		newName = "/dev/null"
+	}
+	fmt.Fprintf(header, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, hunk := range patch.Hunks {
+		header.WriteString(hunk.Header())
+		header.WriteByte('\n')
+		for _, line := range hunk.Lines {
+			header.WriteString(line.Markers)
+			header.WriteString(line.Content)
+			header.WriteByte('\n')
+		}
+	}
+
+	_, err := w.Write(header.Bytes())
+	return err
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWriteCombinedPatchGolden compares combined diff of a merge with
+// `git diff-tree -c -p` and `git diff-tree --cc` output. The merge takes
+// both sides of trivial.txt, resolves conflict.txt and makes an evil change
+// to evil.txt, which both sides merged cleanly.
+func TestWriteCombinedPatchGolden(t *testing.T) {
+	repo := newTestRepository(t)
+
+	lines := func(replace map[int]string) string {
+		buf := &bytes.Buffer{}
+		for idx := 1; idx <= 12; idx++ {
+			if line, ok := replace[idx]; ok {
+				buf.WriteString(line + "\n")
+				continue
+			}
+			fmt.Fprintf(buf, "line %d\n", idx)
+		}
+		return buf.String()
+	}
+
+	base := testCommitFiles(t, repo, map[string]string{
+		"trivial.txt":  lines(nil),
+		"evil.txt":     lines(nil),
+		"conflict.txt": lines(nil),
+	}, 100)
+	ours := testCommitFiles(t, repo, map[string]string{
+		"trivial.txt":  lines(map[int]string{2: "line two"}),
+		"evil.txt":     lines(map[int]string{2: "line two"}),
+		"conflict.txt": lines(map[int]string{6: "line six (ours)"}),
+	}, 200, base)
+	theirs := testCommitFiles(t, repo, map[string]string{
+		"trivial.txt":  lines(map[int]string{11: "line eleven"}),
+		"evil.txt":     lines(map[int]string{11: "line eleven"}),
+		"conflict.txt": lines(map[int]string{6: "line six (theirs)"}),
+	}, 300, base)
+	merge := testCommitFiles(t, repo, map[string]string{
+		"trivial.txt":  lines(map[int]string{2: "line two", 11: "line eleven"}),
+		"evil.txt":     lines(map[int]string{2: "line two", 6: "line six (evil)", 11: "line eleven"}),
+		"conflict.txt": lines(map[int]string{6: "line six (merged)"}),
+	}, 400, ours, theirs)
+
+	commit, err := repo.GetCommit(merge.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		golden string
+		dense  bool
+	}{
+		{"combined_c.golden", false},
+		{"combined_cc.golden", true},
+	} {
+		want, err := ioutil.ReadFile(filepath.Join("testdata", test.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := DefaultCombinedDiffOptions()
+		opts.Dense = test.dense
+		buf := &bytes.Buffer{}
+		if err = commit.WriteCombinedPatch(buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != string(want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.golden, buf, want)
+		}
+	}
+
+	// trivial.txt takes one side in every hunk and is hidden by --cc
+	patches, err := commit.CombinedDiff(DefaultCombinedDiffOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, patch := range patches {
+		paths = append(paths, patch.Path)
+	}
+	if got := strings.Join(paths, " "); got != "conflict.txt evil.txt" {
+		t.Errorf("got paths %q, want %q", got, "conflict.txt evil.txt")
+	}
+}
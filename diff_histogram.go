@@ -0,0 +1,173 @@
+package git
+
+// Histogram diff, extension of patience diff which anchors on the common
+// lines with the lowest number of occurrences instead of unique ones only.
+// Lines are 1-based here, same as in xdiff, 0 means no line.
+
+const histogramMaxChain = 64
+
+type histogramRecord struct {
+	ptr int
+	cnt int
+}
+
+type histogramIndex struct {
+	a, b *diffFile
+
+	records map[int]*histogramRecord
+	lineMap map[int]*histogramRecord
+	nextPtr map[int]int
+
+	cnt       int
+	hasCommon bool
+}
+
+type histogramRegion struct {
+	begin1, end1 int
+	begin2, end2 int
+}
+
+func (idx *histogramIndex) cmp(line1, line2 int) bool {
+	return idx.a.recs[line1-1] == idx.b.recs[line2-1]
+}
+
+func (idx *histogramIndex) scanA(line1, count1 int) {
+	for ptr := line1 + count1 - 1; line1 <= ptr; ptr-- {
+		rec, ok := idx.records[idx.a.recs[ptr-1]]
+		if ok {
+			// prepend to the chain of equal lines
+			idx.nextPtr[ptr] = rec.ptr
+			rec.ptr = ptr
+			rec.cnt++
+		} else {
+			rec = &histogramRecord{ptr: ptr, cnt: 1}
+			idx.records[idx.a.recs[ptr-1]] = rec
+		}
+		idx.lineMap[ptr] = rec
+	}
+}
+
+func (idx *histogramIndex) tryLCS(lcs *histogramRegion, bPtr, line1, count1, line2, count2 int) int {
+	bNext := bPtr + 1
+	rec, ok := idx.records[idx.b.recs[bPtr-1]]
+	if !ok {
+		return bNext
+	}
+
+	if rec.cnt > idx.cnt {
+		idx.hasCommon = true
+		return bNext
+	}
+
+	idx.hasCommon = true
+	end1, end2 := line1+count1-1, line2+count2-1
+	as := rec.ptr
+	for {
+		np := idx.nextPtr[as]
+		bs := bPtr
+		ae, be := as, bs
+		rc := rec.cnt
+
+		for line1 < as && line2 < bs && idx.cmp(as-1, bs-1) {
+			as--
+			bs--
+			if rc > 1 && idx.lineMap[as].cnt < rc {
+				rc = idx.lineMap[as].cnt
+			}
+		}
+		for ae < end1 && be < end2 && idx.cmp(ae+1, be+1) {
+			ae++
+			be++
+			if rc > 1 && idx.lineMap[ae].cnt < rc {
+				rc = idx.lineMap[ae].cnt
+			}
+		}
+
+		if bNext <= be {
+			bNext = be + 1
+		}
+		if lcs.end1-lcs.begin1 < ae-as || rc < idx.cnt {
+			*lcs = histogramRegion{begin1: as, end1: ae, begin2: bs, end2: be}
+			idx.cnt = rc
+		}
+
+		if np == 0 {
+			break
+		}
+		for np <= ae {
+			np = idx.nextPtr[np]
+			if np == 0 {
+				return bNext
+			}
+		}
+		as = np
+	}
+
+	return bNext
+}
+
+// findLCS returns whether the diff should fall back to Myers because common
+// lines occur too often.
+func (idx *histogramIndex) findLCS(lcs *histogramRegion, line1, count1, line2, count2 int) bool {
+	idx.scanA(line1, count1)
+	idx.cnt = histogramMaxChain + 1
+
+	for bPtr := line2; bPtr <= line2+count2-1; {
+		bPtr = idx.tryLCS(lcs, bPtr, line1, count1, line2, count2)
+	}
+
+	return idx.hasCommon && histogramMaxChain < idx.cnt
+}
+
+func histogramDiff(a, b *diffFile, line1, count1, line2, count2 int, minimal bool) {
+	for {
+		switch {
+		case count1 <= 0 && count2 <= 0:
+			return
+		case count1 == 0:
+			for ; count2 > 0; count2-- {
+				b.setChanged(line2-1, true)
+				line2++
+			}
+			return
+		case count2 == 0:
+			for ; count1 > 0; count1-- {
+				a.setChanged(line1-1, true)
+				line1++
+			}
+			return
+		}
+
+		idx := &histogramIndex{
+			a:       a,
+			b:       b,
+			records: map[int]*histogramRecord{},
+			lineMap: map[int]*histogramRecord{},
+			nextPtr: map[int]int{},
+		}
+
+		lcs := &histogramRegion{}
+		if idx.findLCS(lcs, line1, count1, line2, count2) {
+			myersRange(a, b, line1-1, line1-1+count1, line2-1, line2-1+count2, minimal)
+			return
+		}
+
+		if lcs.begin1 == 0 && lcs.begin2 == 0 {
+			for ; count1 > 0; count1-- {
+				a.setChanged(line1-1, true)
+				line1++
+			}
+			for ; count2 > 0; count2-- {
+				b.setChanged(line2-1, true)
+				line2++
+			}
+			return
+		}
+
+		histogramDiff(a, b, line1, lcs.begin1-line1, line2, lcs.begin2-line2, minimal)
+
+		end1, end2 := line1+count1-1, line2+count2-1
+		count1, line1 = end1-lcs.end1, lcs.end1+1
+		count2, line2 = end2-lcs.end2, lcs.end2+1
+	}
+}
@@ -0,0 +1,250 @@
+package git
+
+// DiffLimits bounds the cost of a diff for rendering, zero means no limit.
+type DiffLimits struct {
+	// MaxFiles is the number of files diffed, the rest are skipped
+	MaxFiles int
+	// MaxLinesPerFile stops hunks of a file after that many lines
+	MaxLinesPerFile int
+	// MaxBytesPerFile leaves files with bigger content on either side
+	// without hunks
+	MaxBytesPerFile int64
+	// MaxTotalBytes is the budget of hunk content of all files, files after
+	// it is exhausted are skipped
+	MaxTotalBytes int64
+	// MaxStatsBytes is the budget of content read to count lines of skipped
+	// files, files after it is exhausted come without stats. Zero means the
+	// same budget as MaxTotalBytes, or DefaultMaxStatsBytes if that is not
+	// set either. Negative value leaves all skipped files without stats.
+	MaxStatsBytes int64
+}
+
+// DefaultMaxStatsBytes is the budget of content read for stats of skipped
+// files when no byte limit is set, so that skipping files stays cheap.
+var DefaultMaxStatsBytes int64 = 10 << 20
+
+// DiffOptions controls bounded diff generation.
+type DiffOptions struct {
+	PatchOptions
+	Limits DiffLimits
+}
+
+// Diff is a diff between two revisions cut according to DiffLimits.
+type Diff struct {
+	Files []*FilePatch
+	// Skipped files were not diffed because of MaxFiles or MaxTotalBytes
+	Skipped []*SkippedFile
+	// TotalBytes is the size of hunk content of all files
+	TotalBytes int64
+}
+
+// IsTruncated reports whether any file was truncated or skipped.
+func (d *Diff) IsTruncated() bool {
+	if len(d.Skipped) > 0 {
+		return true
+	}
+	for _, file := range d.Files {
+		if file.IsTruncated {
+			return true
+		}
+	}
+	return false
+}
+
+// SkippedFile is a summary of a file which was not diffed. Stats is nil if
+// the file is over MaxBytesPerFile or MaxStatsBytes was exhausted.
+type SkippedFile struct {
+	*TreeChange
+	Stats *FileStats
+}
+
+// GetDiff returns diff between given revisions within opts.Limits.
+func (repo *Repository) GetDiff(base, head string, opts DiffOptions) (*Diff, error) {
+	baseTree, err := repo.revisionTree(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headTree, err := repo.revisionTree(head)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := repo.diffTrees(baseTree, headTree, opts.DiffTreeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := &opts.Limits
+	statsBudget := limits.MaxStatsBytes
+	if statsBudget == 0 {
+		statsBudget = limits.MaxTotalBytes
+	}
+	if statsBudget == 0 {
+		statsBudget = DefaultMaxStatsBytes
+	}
+
+	diff := &Diff{Files: []*FilePatch{}, Skipped: []*SkippedFile{}}
+	for _, change := range splitTypeChanges(changes) {
+		tooLarge, err := repo.overSizeLimit(change, limits.MaxBytesPerFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if (limits.MaxFiles > 0 && len(diff.Files) >= limits.MaxFiles) ||
+			(limits.MaxTotalBytes > 0 && diff.TotalBytes >= limits.MaxTotalBytes) {
+			skipped := &SkippedFile{TreeChange: change}
+			if !tooLarge && statsBudget > 0 {
+				// sizes are known without reading content, so the budget
+				// is checked before anything is read
+				size, err := repo.changeSize(change)
+				if err != nil {
+					return nil, err
+				}
+
+				if statsBudget -= size; statsBudget >= 0 {
+					if skipped.Stats, err = repo.fileStats(change); err != nil {
+						return nil, err
+					}
+				}
+			}
+			diff.Skipped = append(diff.Skipped, skipped)
+			continue
+		}
+
+		if tooLarge {
+			diff.Files = append(diff.Files, &FilePatch{TreeChange: change, IsTruncated: true})
+			continue
+		}
+
+		patch, _, _, err := repo.filePatch(change, &opts.LineDiffOptions, limits.MaxLinesPerFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if patch.onlyIgnoredChanges() {
+			continue
+		}
+
+		diff.TotalBytes += patch.truncate(limits.MaxTotalBytes-diff.TotalBytes, limits.MaxTotalBytes > 0)
+		diff.Files = append(diff.Files, patch)
+	}
+
+	return diff, nil
+}
+
+// changeSize returns size of content on both sides of the change.
+func (repo *Repository) changeSize(change *TreeChange) (int64, error) {
+	var total int64
+	if change.OldID == change.NewID {
+		return 0, nil
+	}
+
+	for _, side := range []struct {
+		id   sha1
+		mode EntryMode
+	}{{change.OldID, change.OldMode}, {change.NewID, change.NewMode}} {
+		if side.mode == 0 || side.mode == ENTRY_MODE_COMMIT {
+			continue
+		}
+
+		size, err := repo.blobSize(side.id)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	return total, nil
+}
+
+// overSizeLimit reports whether content of either side is bigger than limit.
+func (repo *Repository) overSizeLimit(change *TreeChange, limit int64) (bool, error) {
+	if limit <= 0 || change.OldID == change.NewID {
+		// pure renames and mode changes have no content to diff
+		return false, nil
+	}
+
+	for _, side := range []struct {
+		id   sha1
+		mode EntryMode
+	}{{change.OldID, change.OldMode}, {change.NewID, change.NewMode}} {
+		if side.mode == 0 || side.mode == ENTRY_MODE_COMMIT {
+			continue
+		}
+
+		size, err := repo.blobSize(side.id)
+		if err != nil {
+			return false, err
+		}
+		if size > limit {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// truncate cuts hunks when content exceeds budget, if it is limited. It
+// returns the size of content left.
+func (p *FilePatch) truncate(budget int64, limitBytes bool) int64 {
+	var size int64
+	for idx, hunk := range p.Hunks {
+		for lineIdx, line := range hunk.Lines {
+			size += int64(len(line.Content)) + 1
+			if limitBytes && size > budget {
+				size -= int64(len(line.Content)) + 1
+				hunk.cut(lineIdx)
+				p.Hunks = p.Hunks[:idx+1]
+				if !hunk.hasChanges() {
+					for _, line := range hunk.Lines {
+						size -= int64(len(line.Content)) + 1
+					}
+					p.Hunks = p.Hunks[:idx]
+				}
+				p.IsTruncated = true
+				return size
+			}
+		}
+	}
+	return size
+}
+
+// cut drops lines of the hunk starting from idx and fixes its ranges. Empty
+// ranges start at the line before them, as git writes them.
+func (h *DiffHunk) cut(idx int) {
+	if h.OldLines > 0 {
+		h.OldStart--
+	}
+	if h.NewLines > 0 {
+		h.NewStart--
+	}
+
+	h.Lines = h.Lines[:idx]
+	h.OldLines, h.NewLines = 0, 0
+	for _, line := range h.Lines {
+		if line.Type != DIFF_LINE_ADD {
+			h.OldLines++
+		}
+		if line.Type != DIFF_LINE_DEL {
+			h.NewLines++
+		}
+	}
+
+	if h.OldLines > 0 {
+		h.OldStart++
+	}
+	if h.NewLines > 0 {
+		h.NewStart++
+	}
+}
+
+// hasChanges reports whether the hunk has any added or deleted lines.
+func (h *DiffHunk) hasChanges() bool {
+	for _, line := range h.Lines {
+		if line.Type == DIFF_LINE_ADD || line.Type == DIFF_LINE_DEL {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildHunksMaxLines(t *testing.T) {
+	opts := &LineDiffOptions{ContextLines: 3}
+	newData := []byte(strings.Repeat("line\n", 10))
+
+	a, b, changes := diffLineFiles(nil, newData, opts)
+	hunks, truncated := buildHunks(a, b, changes, opts.ContextLines, 4)
+	if !truncated {
+		t.Error("expected hunks to be cut")
+	}
+	if len(hunks) != 1 || len(hunks[0].Lines) != 4 {
+		t.Fatalf("got %d hunks, want one of 4 lines", len(hunks))
+	}
+	if header := hunks[0].Header(); header != "@@ -0,0 +1,4 @@" {
+		t.Errorf("got header %q", header)
+	}
+
+	if _, truncated = buildHunks(a, b, changes, opts.ContextLines, 10); truncated {
+		t.Error("hunks at the limit are not cut")
+	}
+}
+
+func TestHunkCut(t *testing.T) {
+	tests := []struct {
+		old, new string
+		keep     int
+		header   string
+	}{
+		// deletion is kept, the addition after it is cut off
+		{"x\n", "y\n", 1, "@@ -1 +0,0 @@"},
+		{"a\nb\nc\n", "a\nB\nc\n", 2, "@@ -1,2 +1 @@"},
+		{"a\nb\nc\n", "a\nB\nc\n", 1, "@@ -1 +1 @@"},
+	}
+
+	for _, test := range tests {
+		hunks := DiffLines([]byte(test.old), []byte(test.new), LineDiffOptions{ContextLines: 3})
+		if len(hunks) != 1 {
+			t.Fatalf("%q -> %q: got %d hunks", test.old, test.new, len(hunks))
+		}
+
+		hunks[0].cut(test.keep)
+		if header := hunks[0].Header(); header != test.header {
+			t.Errorf("%q -> %q cut at %d: got %q, want %q", test.old, test.new, test.keep, header, test.header)
+		}
+	}
+}
+
+func TestTruncateDropsContextOnlyHunk(t *testing.T) {
+	hunks := DiffLines([]byte("a\nb\nc\nd\n"), []byte("a\nb\nc\nD\n"), LineDiffOptions{ContextLines: 3})
+	patch := &FilePatch{Hunks: hunks}
+
+	// the budget ends within the leading context
+	size := patch.truncate(4, true)
+	if !patch.IsTruncated || len(patch.Hunks) != 0 || size != 0 {
+		t.Errorf("got %d hunks of %d bytes, want none", len(patch.Hunks), size)
+	}
+}
+
+func TestGetDiffMaxFilesOnly(t *testing.T) {
+	repo := newTestRepository(t)
+
+	files := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		files[name] = strings.Repeat(name+"\n", 10)
+	}
+	base := testCommitFiles(t, repo, nil, 100)
+	head := testCommitFiles(t, repo, files, 200, base)
+
+	// each skipped file has 20 bytes of content
+	defer func(size int64) { DefaultMaxStatsBytes = size }(DefaultMaxStatsBytes)
+	DefaultMaxStatsBytes = 50
+
+	diff, err := repo.GetDiff(base.String(), head.String(), DiffOptions{Limits: DiffLimits{MaxFiles: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Files) != 1 || len(diff.Skipped) != 3 {
+		t.Fatalf("got %d files and %d skipped, want 1 and 3", len(diff.Files), len(diff.Skipped))
+	}
+
+	for idx, skipped := range diff.Skipped {
+		if hasStats := skipped.Stats != nil; hasStats != (idx < 2) {
+			t.Errorf("skipped file %d: got stats %+v", idx, skipped.Stats)
+		}
+	}
+	if stats := diff.Skipped[0].Stats; stats.Insertions != 10 || stats.Deletions != 0 {
+		t.Errorf("got stats %+v, want 10 insertions", stats)
+	}
+}
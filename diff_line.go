@@ -0,0 +1,525 @@
+package git
+
+import (
+	"bytes"
+)
+
+// Line level diff, modelled after xdiff used by git itself so hunks come
+// out the same way for ordinary changes.
+
+// splitLines splits data into lines keeping their terminators. The last line
+// may lack terminator.
+func splitLines(data []byte) [][]byte {
+	lines := make([][]byte, 0, bytes.Count(data, []byte{'\n'})+1)
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:idx+1])
+		data = data[idx+1:]
+	}
+	return lines
+}
+
+// diffFile is one side of the line diff. Lines are compared by records, equal
+// records mean equal lines. changed has a zero sentinel on both ends, so
+// changed[idx+1] is the flag of line idx.
+type diffFile struct {
+	lines   [][]byte
+	recs    []int
+	changed []bool
+}
+
+func (f *diffFile) isChanged(idx int) bool {
+	return f.changed[idx+1]
+}
+
+func (f *diffFile) setChanged(idx int, value bool) {
+	f.changed[idx+1] = value
+}
+
+type DiffAlgorithm int
+
+const (
+	DIFF_ALGORITHM_MYERS DiffAlgorithm = iota
+	DIFF_ALGORITHM_PATIENCE
+	DIFF_ALGORITHM_HISTOGRAM
+)
+
+// LineDiffOptions controls how lines are matched, same as corresponding
+// `git diff` flags. Zero value is plain Myers diff without context.
+type LineDiffOptions struct {
+	Algorithm DiffAlgorithm
+	// Minimal disables cost heuristics of Myers diff, --minimal
+	Minimal bool
+
+	// IgnoreWhitespace ignores all whitespace, -w
+	IgnoreWhitespace bool
+	// IgnoreWhitespaceAtEOL ignores whitespace at line end, --ignore-space-at-eol
+	IgnoreWhitespaceAtEOL bool
+	// IgnoreWhitespaceChange ignores changes in amount of whitespace, -b
+	IgnoreWhitespaceChange bool
+	// IgnoreBlankLines ignores changes whose lines are all blank
+	IgnoreBlankLines bool
+
+	// IndentHeuristic shifts ambiguous changes to nicer boundaries, as git
+	// does by default
+	IndentHeuristic bool
+
+	ContextLines int
+}
+
+func (opts *LineDiffOptions) ignoresWhitespace() bool {
+	return opts.IgnoreWhitespace || opts.IgnoreWhitespaceAtEOL || opts.IgnoreWhitespaceChange
+}
+
+// isSpace matches C isspace in the C locale, which xdiff relies on.
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\v' || ch == '\f' || ch == '\r'
+}
+
+// normalizeLine returns the line as it should be compared under whitespace
+// options.
+func normalizeLine(line []byte, opts *LineDiffOptions) []byte {
+	switch {
+	case opts.IgnoreWhitespace:
+		result := make([]byte, 0, len(line))
+		for _, ch := range line {
+			if !isSpace(ch) {
+				result = append(result, ch)
+			}
+		}
+		return result
+	case opts.IgnoreWhitespaceChange:
+		result := make([]byte, 0, len(line))
+		for idx := 0; idx < len(line); {
+			if !isSpace(line[idx]) {
+				result = append(result, line[idx])
+				idx++
+				continue
+			}
+			for idx < len(line) && isSpace(line[idx]) {
+				idx++
+			}
+			// trailing whitespace is dropped, other runs count as one space
+			if idx < len(line) {
+				result = append(result, ' ')
+			}
+		}
+		return result
+	case opts.IgnoreWhitespaceAtEOL:
+		end := len(line)
+		for end > 0 && isSpace(line[end-1]) {
+			end--
+		}
+		return line[:end]
+	}
+	return line
+}
+
+// isBlankLine reports whether line is blank for IgnoreBlankLines.
+func isBlankLine(line []byte, opts *LineDiffOptions) bool {
+	if !opts.ignoresWhitespace() {
+		return len(line) <= 1
+	}
+	for _, ch := range line {
+		if !isSpace(ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// newDiffFiles splits both sides into lines and assigns records.
+func newDiffFiles(oldData, newData []byte, opts *LineDiffOptions) (*diffFile, *diffFile) {
+	key := func(line []byte) []byte {
+		return normalizeLine(line, opts)
+	}
+	return newRecordFiles(splitLines(oldData), splitLines(newData), key)
+}
+
+// newRecordFiles assigns records to lines of both sides, lines with equal
+// keys get equal records.
+func newRecordFiles(oldLines, newLines [][]byte, key func([]byte) []byte) (*diffFile, *diffFile) {
+	classes := map[string]int{}
+	build := func(lines [][]byte) *diffFile {
+		file := &diffFile{lines: lines}
+		file.recs = make([]int, len(file.lines))
+		file.changed = make([]bool, len(file.lines)+2)
+		for idx, line := range file.lines {
+			k := string(key(line))
+			class, ok := classes[k]
+			if !ok {
+				class = len(classes)
+				classes[k] = class
+			}
+			file.recs[idx] = class
+		}
+		return file
+	}
+
+	return build(oldLines), build(newLines)
+}
+
+// diffGroup is a run of changed lines in one of the files.
+type diffGroup struct {
+	start, end int
+}
+
+func (f *diffFile) groupInit() diffGroup {
+	g := diffGroup{}
+	for f.isChanged(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (f *diffFile) groupNext(g *diffGroup) bool {
+	if g.end == len(f.recs) {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; f.isChanged(g.end); g.end++ {
+	}
+	return true
+}
+
+func (f *diffFile) groupPrevious(g *diffGroup) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; f.isChanged(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (f *diffFile) groupSlideDown(g *diffGroup) bool {
+	if g.end < len(f.recs) && f.recs[g.start] == f.recs[g.end] {
+		f.setChanged(g.start, false)
+		f.setChanged(g.end, true)
+		g.start++
+		g.end++
+		for f.isChanged(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (f *diffFile) groupSlideUp(g *diffGroup) bool {
+	if g.start > 0 && f.recs[g.start-1] == f.recs[g.end-1] {
+		g.start--
+		g.end--
+		f.setChanged(g.start, true)
+		f.setChanged(g.end, false)
+		for f.isChanged(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// Indent heuristic weights, tuned by git on a large corpus of real changes.
+const (
+	indentMaxIndent  = 200
+	indentMaxBlanks  = 20
+	indentMaxSliding = 100
+	indentWeight     = 60
+
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+)
+
+// lineIndent returns indentation width of the line, -1 for blank lines.
+func lineIndent(line []byte) int {
+	indent := 0
+	for _, ch := range line {
+		if !isSpace(ch) {
+			return indent
+		}
+		if ch == ' ' {
+			indent++
+		} else if ch == '\t' {
+			indent += 8 - indent%8
+		}
+		if indent >= indentMaxIndent {
+			return indentMaxIndent
+		}
+	}
+	return -1
+}
+
+type splitMeasurement struct {
+	endOfFile  bool
+	indent     int
+	preBlank   int
+	preIndent  int
+	postBlank  int
+	postIndent int
+}
+
+func (f *diffFile) measureSplit(split int) splitMeasurement {
+	m := splitMeasurement{indent: -1, preIndent: -1, postIndent: -1}
+	if split >= len(f.lines) {
+		m.endOfFile = true
+	} else {
+		m.indent = lineIndent(f.lines[split])
+	}
+
+	for idx := split - 1; idx >= 0; idx-- {
+		if m.preIndent = lineIndent(f.lines[idx]); m.preIndent != -1 {
+			break
+		}
+		if m.preBlank++; m.preBlank == indentMaxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+
+	for idx := split + 1; idx < len(f.lines); idx++ {
+		if m.postIndent = lineIndent(f.lines[idx]); m.postIndent != -1 {
+			break
+		}
+		if m.postBlank++; m.postBlank == indentMaxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+
+	return m
+}
+
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (s *splitScore) add(m splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight*totalBlank + postBlankWeight*postBlank
+
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	s.effectiveIndent += indent
+
+	pick := func(withBlank, without int) int {
+		if anyBlanks {
+			return withBlank
+		}
+		return without
+	}
+
+	switch {
+	case indent == -1 || m.preIndent == -1 || indent == m.preIndent:
+	case indent > m.preIndent:
+		s.penalty += pick(relativeIndentWithBlankPenalty, relativeIndentPenalty)
+	case m.postIndent != -1 && m.postIndent > indent:
+		s.penalty += pick(relativeOutdentWithBlankPenalty, relativeOutdentPenalty)
+	default:
+		s.penalty += pick(relativeDedentWithBlankPenalty, relativeDedentPenalty)
+	}
+}
+
+func (s splitScore) cmp(other splitScore) int {
+	indents := 0
+	if s.effectiveIndent > other.effectiveIndent {
+		indents = 1
+	} else if s.effectiveIndent < other.effectiveIndent {
+		indents = -1
+	}
+	return indentWeight*indents + s.penalty - other.penalty
+}
+
+// compactChanges slides ambiguous groups of changes the same way xdiff does:
+// aligned with a change in the other file if possible, otherwise to the
+// best scored split when indent heuristic is on, or as far down as possible.
+func compactChanges(f, other *diffFile, indentHeuristic bool) {
+	g := f.groupInit()
+	og := other.groupInit()
+
+	for {
+		if g.end != g.start {
+			var size, earliestEnd, endMatchingOther int
+			for size = -1; size != g.end-g.start; {
+				size = g.end - g.start
+				endMatchingOther = -1
+
+				for f.groupSlideUp(&g) {
+					other.groupPrevious(&og)
+				}
+
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+
+				for f.groupSlideDown(&g) {
+					other.groupNext(&og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+				// no shifting was possible
+			case endMatchingOther != -1:
+				for og.end == og.start {
+					f.groupSlideUp(&g)
+					other.groupPrevious(&og)
+				}
+			case indentHeuristic:
+				shift := earliestEnd
+				if g.end-size-1 > shift {
+					shift = g.end - size - 1
+				}
+				if g.end-indentMaxSliding > shift {
+					shift = g.end - indentMaxSliding
+				}
+
+				bestShift := -1
+				var best splitScore
+				for ; shift <= g.end; shift++ {
+					score := splitScore{}
+					score.add(f.measureSplit(shift))
+					score.add(f.measureSplit(shift - size))
+					if bestShift == -1 || score.cmp(best) <= 0 {
+						best = score
+						bestShift = shift
+					}
+				}
+
+				for g.end > bestShift {
+					f.groupSlideUp(&g)
+					other.groupPrevious(&og)
+				}
+			}
+		}
+
+		if !f.groupNext(&g) {
+			break
+		}
+		other.groupNext(&og)
+	}
+}
+
+// diffChange is a single replacement of old lines by new ones. Ignored
+// changes consist of blank lines only and are shown only next to others.
+type diffChange struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	ignore             bool
+}
+
+// buildScript turns changed flags into list of changes.
+func buildScript(a, b *diffFile, opts *LineDiffOptions) []diffChange {
+	changes := []diffChange{}
+	i, j := 0, 0
+	for i < len(a.recs) || j < len(b.recs) {
+		if a.isChanged(i) || b.isChanged(j) {
+			change := diffChange{oldStart: i, newStart: j}
+			for i < len(a.recs) && a.isChanged(i) {
+				i++
+			}
+			for j < len(b.recs) && b.isChanged(j) {
+				j++
+			}
+			change.oldCount = i - change.oldStart
+			change.newCount = j - change.newStart
+			if opts.IgnoreBlankLines {
+				change.ignore = allBlank(a.lines[change.oldStart:i], opts) &&
+					allBlank(b.lines[change.newStart:j], opts)
+			}
+			changes = append(changes, change)
+			continue
+		}
+		i++
+		j++
+	}
+	return changes
+}
+
+func allBlank(lines [][]byte, opts *LineDiffOptions) bool {
+	for _, line := range lines {
+		if !isBlankLine(line, opts) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffLineFiles runs the whole line diff pipeline.
+func diffLineFiles(oldData, newData []byte, opts *LineDiffOptions) (*diffFile, *diffFile, []diffChange) {
+	a, b := newDiffFiles(oldData, newData, opts)
+	return a, b, diffRecords(a, b, opts)
+}
+
+// diffRecords marks changed lines of files with records already assigned
+// and returns the changes.
+func diffRecords(a, b *diffFile, opts *LineDiffOptions) []diffChange {
+	switch opts.Algorithm {
+	case DIFF_ALGORITHM_PATIENCE:
+		patienceDiff(a, b, 0, len(a.recs), 0, len(b.recs), opts.Minimal)
+	case DIFF_ALGORITHM_HISTOGRAM:
+		histogramDiff(a, b, 1, len(a.recs), 1, len(b.recs), opts.Minimal)
+	default:
+		myersRange(a, b, 0, len(a.recs), 0, len(b.recs), opts.Minimal)
+	}
+	compactChanges(a, b, opts.IndentHeuristic)
+	compactChanges(b, a, opts.IndentHeuristic)
+	return buildScript(a, b, opts)
+}
+
+// DiffLines diffs two contents line by line and returns hunks of changes.
+// Binary data is not treated specially here.
+func DiffLines(oldData, newData []byte, opts LineDiffOptions) []*DiffHunk {
+	a, b, changes := diffLineFiles(oldData, newData, &opts)
+	hunks, _ := buildHunks(a, b, changes, opts.ContextLines, 0)
+	return hunks
+}
+
+// DiffBlobs diffs contents of two blobs, nil blob stands for empty content.
+func DiffBlobs(oldBlob, newBlob *Blob, opts LineDiffOptions) ([]*DiffHunk, error) {
+	var oldData, newData []byte
+	var err error
+	if oldBlob != nil {
+		if oldData, err = oldBlob.repo.readBlob(oldBlob.ID); err != nil {
+			return nil, err
+		}
+	}
+	if newBlob != nil {
+		if newData, err = newBlob.repo.readBlob(newBlob.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return DiffLines(oldData, newData, opts), nil
+}
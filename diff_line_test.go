@@ -0,0 +1,58 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestDiffLinesGolden compares hunks with output of git diff 2.39 for the
+// same pair of files in testdata/diff.
+func TestDiffLinesGolden(t *testing.T) {
+	read := func(name string) []byte {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "diff", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, test := range []struct {
+		file, variant string
+		opts          LineDiffOptions
+	}{
+		{"frob", "myers", LineDiffOptions{Algorithm: DIFF_ALGORITHM_MYERS}},
+		{"frob", "patience", LineDiffOptions{Algorithm: DIFF_ALGORITHM_PATIENCE}},
+		{"frob", "histogram", LineDiffOptions{Algorithm: DIFF_ALGORITHM_HISTOGRAM}},
+		{"letters", "myers", LineDiffOptions{Algorithm: DIFF_ALGORITHM_MYERS}},
+		{"letters", "patience", LineDiffOptions{Algorithm: DIFF_ALGORITHM_PATIENCE}},
+		{"letters", "histogram", LineDiffOptions{Algorithm: DIFF_ALGORITHM_HISTOGRAM}},
+		{"random", "myers", LineDiffOptions{}},
+		{"random", "minimal", LineDiffOptions{Minimal: true}},
+		{"space", "default", LineDiffOptions{}},
+		{"space", "all", LineDiffOptions{IgnoreWhitespace: true}},
+		{"space", "change", LineDiffOptions{IgnoreWhitespaceChange: true}},
+		{"space", "eol", LineDiffOptions{IgnoreWhitespaceAtEOL: true}},
+		{"space", "blank", LineDiffOptions{IgnoreBlankLines: true}},
+		{"space", "all_blank", LineDiffOptions{IgnoreWhitespace: true, IgnoreBlankLines: true}},
+		{"indent", "heuristic", LineDiffOptions{}},
+		{"indent", "default", LineDiffOptions{}},
+	} {
+		name := test.file + "_" + test.variant
+		// git diff uses the indent heuristic unless told otherwise
+		opts := test.opts
+		opts.IndentHeuristic = name != "indent_default"
+		opts.ContextLines = 3
+
+		buf := &bytes.Buffer{}
+		for _, hunk := range DiffLines(read(test.file+".old"), read(test.file+".new"), opts) {
+			if err := writeHunk(buf, hunk); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := read(name + ".diff"); buf.String() != string(want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, buf, want)
+		}
+	}
+}
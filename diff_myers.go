@@ -0,0 +1,381 @@
+package git
+
+// Myers diff as implemented by xdiff: linear space divide and conquer with
+// cost heuristics, so big inputs do not explode unless minimal diff is
+// requested.
+
+const (
+	myersMaxEqLimit   = 1024
+	myersSimscanWin   = 100
+	myersKpdisRun     = 4
+	myersMaxCostMin   = 256
+	myersHeurMinCost  = 256
+	myersSnakeCount   = 20
+	myersHeurK        = 4
+	myersDiscardNone  = 0
+	myersDiscardMatch = 1
+	myersDiscardMulti = 2
+)
+
+// bogoSqrt is xdiff's cheap power of two approximation of square root.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+type myersEnv struct {
+	// records of lines left after cleanup and their original indexes
+	ha1, ha2         []int
+	rindex1, rindex2 []int
+	a, b             *diffFile
+
+	kvdf, kvdb []int
+	off        int
+
+	maxCost int
+}
+
+// myersRange diffs a[aLo:aHi] against b[bLo:bHi] and marks changed lines.
+func myersRange(a, b *diffFile, aLo, aHi, bLo, bHi int, minimal bool) {
+	env := &myersEnv{a: a, b: b}
+
+	// occurrences are counted over the whole range, before trimming
+	count1 := map[int]int{}
+	count2 := map[int]int{}
+	for idx := aLo; idx < aHi; idx++ {
+		count1[a.recs[idx]]++
+	}
+	for idx := bLo; idx < bHi; idx++ {
+		count2[b.recs[idx]]++
+	}
+	limit1, limit2 := bogoSqrt(aHi-aLo), bogoSqrt(bHi-bLo)
+
+	for aLo < aHi && bLo < bHi && a.recs[aLo] == b.recs[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && a.recs[aHi-1] == b.recs[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	env.cleanupRecords(aLo, aHi, bLo, bHi, count1, count2, limit1, limit2)
+
+	n1, n2 := len(env.ha1), len(env.ha2)
+	env.kvdf = make([]int, n1+n2+3)
+	env.kvdb = make([]int, n1+n2+3)
+	env.off = n2 + 1
+
+	env.maxCost = bogoSqrt(n1 + n2 + 3)
+	if env.maxCost < myersMaxCostMin {
+		env.maxCost = myersMaxCostMin
+	}
+	if minimal {
+		env.maxCost = int(^uint(0) >> 1)
+	}
+
+	env.compare(0, n1, 0, n2, minimal)
+}
+
+// cleanupRecords drops lines that have no match on the other side, and
+// lines with too many matches surrounded by such lines. They are marked as
+// changed right away and do not take part in the diff, which keeps it fast.
+func (env *myersEnv) cleanupRecords(aLo, aHi, bLo, bHi int, count1, count2 map[int]int, limit1, limit2 int) {
+	classify := func(file *diffFile, lo, hi int, other map[int]int, limit int) []byte {
+		if limit > myersMaxEqLimit {
+			limit = myersMaxEqLimit
+		}
+
+		dis := make([]byte, hi-lo)
+		for idx := lo; idx < hi; idx++ {
+			switch matches := other[file.recs[idx]]; {
+			case matches == 0:
+				dis[idx-lo] = myersDiscardNone
+			case matches >= limit:
+				dis[idx-lo] = myersDiscardMulti
+			default:
+				dis[idx-lo] = myersDiscardMatch
+			}
+		}
+		return dis
+	}
+
+	keep := func(file *diffFile, lo int, dis []byte) ([]int, []int) {
+		var ha, rindex []int
+		for idx := range dis {
+			if dis[idx] == myersDiscardMatch || (dis[idx] == myersDiscardMulti && !cleanMultimatch(dis, idx)) {
+				ha = append(ha, file.recs[lo+idx])
+				rindex = append(rindex, lo+idx)
+			} else {
+				file.setChanged(lo+idx, true)
+			}
+		}
+		return ha, rindex
+	}
+
+	dis1 := classify(env.a, aLo, aHi, count2, limit1)
+	dis2 := classify(env.b, bLo, bHi, count1, limit2)
+	env.ha1, env.rindex1 = keep(env.a, aLo, dis1)
+	env.ha2, env.rindex2 = keep(env.b, bLo, dis2)
+}
+
+// cleanMultimatch reports whether multimatch line i sits in the middle of a
+// run of unmatched lines and should be discarded.
+func cleanMultimatch(dis []byte, i int) bool {
+	s, e := 0, len(dis)-1
+	if i-s > myersSimscanWin {
+		s = i - myersSimscanWin
+	}
+	if e-i > myersSimscanWin {
+		e = i + myersSimscanWin
+	}
+
+	rdis0, rpdis0 := 0, 1
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == myersDiscardNone {
+			rdis0++
+		} else if dis[i-r] == myersDiscardMulti {
+			rpdis0++
+		} else {
+			break
+		}
+	}
+	if rdis0 == 0 {
+		return false
+	}
+
+	rdis1, rpdis1 := 0, 1
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == myersDiscardNone {
+			rdis1++
+		} else if dis[i+r] == myersDiscardMulti {
+			rpdis1++
+		} else {
+			break
+		}
+	}
+	if rdis1 == 0 {
+		return false
+	}
+
+	rdis1 += rdis0
+	rpdis1 += rpdis0
+	return rpdis1*myersKpdisRun < rpdis1+rdis1
+}
+
+func (env *myersEnv) compare(off1, lim1, off2, lim2 int, minimal bool) {
+	ha1, ha2 := env.ha1, env.ha2
+	for off1 < lim1 && off2 < lim2 && ha1[off1] == ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && ha1[lim1-1] == ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			env.b.setChanged(env.rindex2[off2], true)
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			env.a.setChanged(env.rindex1[off1], true)
+		}
+	default:
+		i1, i2, minLo, minHi := env.split(off1, lim1, off2, lim2, minimal)
+		env.compare(off1, i1, off2, i2, minLo)
+		env.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+const lineMax = int(^uint(0) >> 1)
+
+// split finds a point on the (near) optimal edit path splitting the box in
+// two. It returns the point and whether each half needs minimal diff.
+func (env *myersEnv) split(off1, lim1, off2, lim2 int, minimal bool) (int, int, bool, bool) {
+	ha1, ha2 := env.ha1, env.ha2
+	kvdf := func(d int) *int { return &env.kvdf[env.off+d] }
+	kvdb := func(d int) *int { return &env.kvdb[env.off+d] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > myersSnakeCount {
+				gotSnake = true
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = lineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = lineMax
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > myersSnakeCount {
+				gotSnake = true
+			}
+			*kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return i1, i2, true, true
+			}
+		}
+
+		if minimal {
+			continue
+		}
+
+		// look for a long enough snake on a promising diagonal
+		if gotSnake && ec > myersHeurMinCost {
+			best, s1, s2 := 0, 0, 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+
+				if v > myersHeurK*ec && v > best &&
+					off1+myersSnakeCount <= i1 && i1 < lim1 &&
+					off2+myersSnakeCount <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == myersSnakeCount {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, true, false
+			}
+
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+
+				if v > myersHeurK*ec && v > best &&
+					off1 < i1 && i1 <= lim1-myersSnakeCount &&
+					off2 < i2 && i2 <= lim2-myersSnakeCount {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == myersSnakeCount-1 {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, false, true
+			}
+		}
+
+		// too expensive, take the furthest reaching path so far
+		if ec >= env.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *kvdf(d)
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+
+			bbest, bbest1 := lineMax, lineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *kvdb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
@@ -0,0 +1,582 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// DefaultContextLines is the number of context lines around changes, as in
+// `git diff -U3`.
+var DefaultContextLines = 3
+
+type DiffLineType int
+
+const (
+	DIFF_LINE_PLAIN DiffLineType = iota + 1
+	DIFF_LINE_ADD
+	DIFF_LINE_DEL
+)
+
+// DiffLine is a single line of a hunk. Content does not include the line
+// terminator, NoNewline is set for the last line of a file which has none.
+type DiffLine struct {
+	Type      DiffLineType
+	Content   string
+	NoNewline bool
+	// OldLine and NewLine are 1-based line numbers, 0 when the line does not
+	// exist on that side.
+	OldLine int
+	NewLine int
+	// Segments split changed line into words, filled by DiffHunk.WordDiff
+	Segments []*WordSegment
+}
+
+// DiffHunk is a group of changed lines with surrounding context.
+type DiffHunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Section is the function context git shows after the hunk range.
+	Section string
+	Lines   []*DiffLine
+}
+
+// Header returns hunk header line without line terminator.
+func (h *DiffHunk) Header() string {
+	header := "@@ -" + hunkRange(h.OldStart, h.OldLines) + " +" + hunkRange(h.NewStart, h.NewLines) + " @@"
+	if h.Section != "" {
+		header += " " + h.Section
+	}
+	return header
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// FilePatch is a diff of a single file.
+type FilePatch struct {
+	*TreeChange
+	IsBinary bool
+	Hunks    []*DiffHunk
+	// IsTruncated is set when hunks were cut or left out by DiffLimits
+	IsTruncated bool
+}
+
+// onlyIgnoredChanges reports whether content differs but all differences
+// were ignored by whitespace options.
+func (p *FilePatch) onlyIgnoredChanges() bool {
+	return p.Type == CHANGE_MODIFIED && p.OldMode == p.NewMode && p.OldID != p.NewID &&
+		!p.IsBinary && !p.IsTruncated && len(p.Hunks) == 0
+}
+
+// PatchOptions controls patch generation. LineDiffOptions.ContextLines is
+// taken as is, so set it to DefaultContextLines for git's default.
+type PatchOptions struct {
+	DiffTreeOptions
+	LineDiffOptions
+	// NoBinary writes only a "Binary files differ" line for binary
+	// changes, as git diff does without --binary
+	NoBinary bool
+}
+
+// DefaultPatchOptions returns options matching plain `git diff`.
+func DefaultPatchOptions() PatchOptions {
+	return PatchOptions{
+		DiffTreeOptions: DiffTreeOptions{DetectRenames: true},
+		LineDiffOptions: LineDiffOptions{
+			ContextLines:    DefaultContextLines,
+			IndentHeuristic: true,
+		},
+	}
+}
+
+// indexAbbrev is the length of abbreviated ids in index header lines.
+const indexAbbrev = 7
+
+// binaryCheckSize is how much of the content git inspects for NUL bytes.
+const binaryCheckSize = 8000
+
+func isBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// isFuncLine reports whether line may start a function, using git's default
+// rule: line begins with a letter, underscore or dollar sign.
+func isFuncLine(line []byte) bool {
+	if len(line) == 0 {
+		return false
+	}
+	ch := line[0]
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch == '$'
+}
+
+// funcSection returns the hunk header context for a hunk starting at old
+// line start, the same way xdiff finds it.
+func funcSection(lines [][]byte, start int) string {
+	for idx := start - 1; idx >= 0; idx-- {
+		if isFuncLine(lines[idx]) {
+			line := lines[idx]
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return strings.TrimRight(string(line), " \t\r\n\v\f")
+		}
+	}
+	return ""
+}
+
+// nextHunk returns the changes of the next hunk, skipping ignorable changes
+// too far from the others. It is xdiff's xdl_get_hunk.
+func nextHunk(changes []diffChange, context int) ([]diffChange, []diffChange) {
+	maxCommon := 2 * context
+	maxIgnorable := context
+
+	// remove ignorable changes that are too far before other changes
+	start := 0
+	for idx := 0; idx < len(changes) && changes[idx].ignore; idx++ {
+		if idx+1 == len(changes) || changes[idx+1].oldStart-(changes[idx].oldStart+changes[idx].oldCount) >= maxIgnorable {
+			start = idx + 1
+		}
+	}
+	if changes = changes[start:]; len(changes) == 0 {
+		return nil, nil
+	}
+
+	last, ignored := 0, 0
+	for idx := 1; idx < len(changes); idx++ {
+		prev, change := changes[idx-1], changes[idx]
+		distance := change.oldStart - (prev.oldStart + prev.oldCount)
+		if distance > maxCommon {
+			break
+		}
+
+		switch {
+		case distance < maxIgnorable && (!change.ignore || last == idx-1):
+			last, ignored = idx, 0
+		case distance < maxIgnorable:
+			ignored += change.newCount
+		case last != idx-1 && change.oldStart+ignored-(changes[last].oldStart+changes[last].oldCount) > maxCommon:
+			return changes[:last+1], changes[last+1:]
+		case !change.ignore:
+			last, ignored = idx, 0
+		default:
+			ignored += change.newCount
+		}
+	}
+
+	return changes[:last+1], changes[last+1:]
+}
+
+// buildHunks groups changes into hunks with given number of context lines.
+// Changes separated by no more than twice the context end up in one hunk.
+// Context lines are taken from the new side, as git does. Hunks stop after
+// maxLines lines unless it is zero, the result tells if they were cut.
+func buildHunks(a, b *diffFile, changes []diffChange, context, maxLines int) ([]*DiffHunk, bool) {
+	hunks := []*DiffHunk{}
+	lines := 0
+	for len(changes) > 0 {
+		var group []diffChange
+		if group, changes = nextHunk(changes, context); len(group) == 0 {
+			break
+		}
+
+		first, last := group[0], group[len(group)-1]
+		oldStart, newStart := first.oldStart-context, first.newStart-context
+		if oldStart < 0 {
+			oldStart = 0
+		}
+		if newStart < 0 {
+			newStart = 0
+		}
+
+		oldEnd := last.oldStart + last.oldCount + context
+		if oldEnd > len(a.lines) {
+			oldEnd = len(a.lines)
+		}
+		newEnd := last.newStart + last.newCount + context
+		if newEnd > len(b.lines) {
+			newEnd = len(b.lines)
+		}
+
+		hunk := &DiffHunk{
+			OldStart: oldStart + 1,
+			OldLines: oldEnd - oldStart,
+			NewStart: newStart + 1,
+			NewLines: newEnd - newStart,
+			Section:  funcSection(a.lines, oldStart),
+		}
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+
+		// lines past the limit are not even built
+		full := false
+		add := func(typ DiffLineType, line []byte, oldLine, newLine int) {
+			if maxLines > 0 && lines >= maxLines {
+				full = true
+				return
+			}
+			hunk.Lines = append(hunk.Lines, newDiffLine(typ, line, oldLine, newLine))
+			lines++
+		}
+		plain := func(i, j int) {
+			if i < 0 {
+				// skipped ignorable changes may shift sides apart
+				i = -1
+			}
+			add(DIFF_LINE_PLAIN, b.lines[j], i+1, j+1)
+		}
+
+		// pre-context
+		for j := newStart; j < first.newStart && !full; j++ {
+			plain(first.oldStart-(first.newStart-j), j)
+		}
+
+		i, j := first.oldStart, first.newStart
+		for _, change := range group {
+			for ; i < change.oldStart && j < change.newStart && !full; i, j = i+1, j+1 {
+				plain(i, j)
+			}
+			for i = change.oldStart; i < change.oldStart+change.oldCount && !full; i++ {
+				add(DIFF_LINE_DEL, a.lines[i], i+1, 0)
+			}
+			for j = change.newStart; j < change.newStart+change.newCount && !full; j++ {
+				add(DIFF_LINE_ADD, b.lines[j], 0, j+1)
+			}
+		}
+
+		// post-context
+		for ; j < newEnd && !full; i, j = i+1, j+1 {
+			plain(i, j)
+		}
+
+		if full {
+			hunk.cut(len(hunk.Lines))
+			if hunk.hasChanges() {
+				hunks = append(hunks, hunk)
+			}
+			return hunks, true
+		}
+		hunks = append(hunks, hunk)
+	}
+
+	return hunks, false
+}
+
+func newDiffLine(typ DiffLineType, line []byte, oldLine, newLine int) *DiffLine {
+	content := string(line)
+	noNewline := !strings.HasSuffix(content, "\n")
+	return &DiffLine{
+		Type:      typ,
+		Content:   strings.TrimSuffix(content, "\n"),
+		NoNewline: noNewline,
+		OldLine:   oldLine,
+		NewLine:   newLine,
+	}
+}
+
+// diffContent returns diffable content of the entry: blob data, or the
+// "Subproject commit" line for submodules.
+func (repo *Repository) diffContent(id sha1, mode EntryMode) ([]byte, error) {
+	if id == (sha1{}) || mode == 0 {
+		return nil, nil
+	}
+	if mode == ENTRY_MODE_COMMIT {
+		return []byte("Subproject commit " + id.String() + "\n"), nil
+	}
+	return repo.readBlob(id)
+}
+
+// filePatch computes hunks for the change, at most maxLines lines of them
+// unless it is zero. Old and new content are returned too, binary patches
+// need them.
+func (repo *Repository) filePatch(change *TreeChange, opts *LineDiffOptions, maxLines int) (*FilePatch, []byte, []byte, error) {
+	patch := &FilePatch{TreeChange: change}
+	if change.OldID == change.NewID {
+		// pure rename or mode change
+		return patch, nil, nil, nil
+	}
+
+	oldData, err := repo.diffContent(change.OldID, change.OldMode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	newData, err := repo.diffContent(change.NewID, change.NewMode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if isBinary(oldData) || isBinary(newData) {
+		patch.IsBinary = true
+		return patch, oldData, newData, nil
+	}
+
+	a, b, changes := diffLineFiles(oldData, newData, opts)
+	patch.Hunks, patch.IsTruncated = buildHunks(a, b, changes, opts.ContextLines, maxLines)
+	return patch, oldData, newData, nil
+}
+
+// splitTypeChanges breaks changes of entry type into deletion and addition,
+// as git shows them in patches.
+func splitTypeChanges(changes []*TreeChange) []*TreeChange {
+	result := make([]*TreeChange, 0, len(changes))
+	for _, change := range changes {
+		if change.Type != CHANGE_TYPE_CHANGED {
+			result = append(result, change)
+			continue
+		}
+
+		result = append(result,
+			&TreeChange{Type: CHANGE_DELETED, OldPath: change.OldPath, OldMode: change.OldMode, OldID: change.OldID},
+			&TreeChange{Type: CHANGE_ADDED, NewPath: change.NewPath, NewMode: change.NewMode, NewID: change.NewID})
+	}
+	return result
+}
+
+// resolveRevision resolves revision to commit id.
+func (repo *Repository) resolveRevision(revision string) (sha1, error) {
+	oid, err := rawgit.ResolveName(repo.repo, revision)
+	if err != nil {
+		return sha1{}, err
+	}
+	return sha1(*oid), nil
+}
+
+// revisionTree resolves revision to the root tree of its commit.
+func (repo *Repository) revisionTree(revision string) (sha1, error) {
+	id, err := repo.resolveRevision(revision)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	node, err := repo.loadWalkNode(id)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	return node.tree, nil
+}
+
+// WritePatch writes patch between given revisions to w in the format of
+// `git diff -p --binary`. Files are diffed and written one by one, so the
+// patch is never held in memory as a whole.
+func (repo *Repository) WritePatch(w io.Writer, base, head string, opts PatchOptions) error {
+	baseTree, err := repo.revisionTree(base)
+	if err != nil {
+		return err
+	}
+
+	headTree, err := repo.revisionTree(head)
+	if err != nil {
+		return err
+	}
+
+	return repo.writeTreePatch(w, baseTree, headTree, opts)
+}
+
+func (repo *Repository) writeTreePatch(w io.Writer, oldTree, newTree sha1, opts PatchOptions) error {
+	changes, err := repo.diffTrees(oldTree, newTree, opts.DiffTreeOptions)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, change := range splitTypeChanges(changes) {
+		patch, oldData, newData, err := repo.filePatch(change, &opts.LineDiffOptions, 0)
+		if err != nil {
+			return err
+		}
+
+		if patch.onlyIgnoredChanges() {
+			// git drops files whose changes are all ignored
+			continue
+		}
+
+		if err = writeFilePatch(bw, patch, oldData, newData, !opts.NoBinary); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// quotePath quotes path the way git does for names with special characters.
+func quotePath(name string) string {
+	needQuote := false
+	for idx := 0; idx < len(name); idx++ {
+		if ch := name[idx]; ch < 0x20 || ch >= 0x7f || ch == '"' || ch == '\\' {
+			needQuote = true
+			break
+		}
+	}
+	if !needQuote {
+		return name
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('"')
+	for idx := 0; idx < len(name); idx++ {
+		switch ch := name[idx]; {
+		case ch == '"' || ch == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(ch)
+		case ch == '\a':
+			buf.WriteString(`\a`)
+		case ch == '\b':
+			buf.WriteString(`\b`)
+		case ch == '\t':
+			buf.WriteString(`\t`)
+		case ch == '\n':
+			buf.WriteString(`\n`)
+		case ch == '\v':
+			buf.WriteString(`\v`)
+		case ch == '\f':
+			buf.WriteString(`\f`)
+		case ch == '\r':
+			buf.WriteString(`\r`)
+		case ch < 0x20 || ch >= 0x7f:
+			fmt.Fprintf(buf, `\%03o`, ch)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// writeFileHeader writes `diff --git` header with extended header lines.
+// Full ids are written for binary patches.
+func writeFileHeader(w io.Writer, patch *FilePatch, binary bool) error {
+	oldPath, newPath := patch.OldPath, patch.NewPath
+	if oldPath == "" {
+		oldPath = newPath
+	}
+	if newPath == "" {
+		newPath = oldPath
+	}
+
+	header := &bytes.Buffer{}
+	fmt.Fprintf(header, "diff --git %s %s\n", quotePath("a/"+oldPath), quotePath("b/"+newPath))
+
+	switch {
+	case patch.Type == CHANGE_ADDED:
+		fmt.Fprintf(header, "new file mode %06o\n", patch.NewMode)
+	case patch.Type == CHANGE_DELETED:
+		fmt.Fprintf(header, "deleted file mode %06o\n", patch.OldMode)
+	case patch.OldMode != patch.NewMode:
+		fmt.Fprintf(header, "old mode %06o\nnew mode %06o\n", patch.OldMode, patch.NewMode)
+	}
+
+	switch patch.Type {
+	case CHANGE_RENAMED:
+		fmt.Fprintf(header, "similarity index %d%%\nrename from %s\nrename to %s\n",
+			patch.Similarity, quotePath(oldPath), quotePath(newPath))
+	case CHANGE_COPIED:
+		fmt.Fprintf(header, "similarity index %d%%\ncopy from %s\ncopy to %s\n",
+			patch.Similarity, quotePath(oldPath), quotePath(newPath))
+	}
+
+	if patch.OldID != patch.NewID {
+		// binary patches need full ids to be applicable
+		oldID, newID := patch.OldID.String(), patch.NewID.String()
+		if !patch.IsBinary || !binary {
+			oldID, newID = oldID[:indexAbbrev], newID[:indexAbbrev]
+		}
+		fmt.Fprintf(header, "index %s..%s", oldID, newID)
+		if patch.OldMode == patch.NewMode {
+			fmt.Fprintf(header, " %06o", patch.OldMode)
+		}
+		header.WriteByte('\n')
+	}
+
+	_, err := w.Write(header.Bytes())
+	return err
+}
+
+func writeFilePatch(w io.Writer, patch *FilePatch, oldData, newData []byte, binary bool) error {
+	if err := writeFileHeader(w, patch, binary); err != nil {
+		return err
+	}
+
+	if patch.IsBinary && binary {
+		return writeBinaryPatch(w, oldData, newData)
+	}
+
+	oldName, newName := "/dev/null", "/dev/null"
+	if patch.Type != CHANGE_ADDED {
+		oldName = quotePath("a/" + patch.OldPath)
+	}
+	if patch.Type != CHANGE_DELETED {
+		newName = quotePath("b/" + patch.NewPath)
+	}
+
+	if patch.IsBinary {
+		_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return err
+	}
+	if len(patch.Hunks) == 0 {
+		return nil
+	}
+
+	// names with spaces are followed by tab for GNU patch sake
+	if strings.Contains(oldName, " ") {
+		oldName += "\t"
+	}
+	if strings.Contains(newName, " ") {
+		newName += "\t"
+	}
+
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName); err != nil {
+		return err
+	}
+
+	for _, hunk := range patch.Hunks {
+		if err := writeHunk(w, hunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeHunk(w io.Writer, hunk *DiffHunk) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString(hunk.Header())
+	buf.WriteByte('\n')
+
+	for _, line := range hunk.Lines {
+		switch line.Type {
+		case DIFF_LINE_ADD:
+			buf.WriteByte('+')
+		case DIFF_LINE_DEL:
+			buf.WriteByte('-')
+		default:
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(line.Content)
+		buf.WriteByte('\n')
+		if line.NoNewline {
+			buf.WriteString("\\ No newline at end of file\n")
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// patchFixture returns base and head commits of the history golden patches
+// in testdata were made from with `git diff -p [--binary]`.
+func patchFixture(t *testing.T, repo *Repository) (sha1, sha1) {
+	text := func(format string, n int) string {
+		buf := &bytes.Buffer{}
+		for idx := 1; idx <= n; idx++ {
+			buf.WriteString(fmt.Sprintf(format, idx))
+		}
+		return buf.String()
+	}
+	oldFile, oldLib := text("line %d\n", 10), text("text %d\n", 20)
+
+	commit := func(files map[string]string, exec string, when int64, parents ...sha1) sha1 {
+		editor := repo.newTreeEditor(sha1{})
+		for relpath, content := range files {
+			mode := ENTRY_MODE_BLOB
+			if relpath == exec {
+				mode = ENTRY_MODE_EXEC
+			}
+			if err := editor.setContent(relpath, []byte(content), mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tree, err := editor.write(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+		id, err := writeCommit(repo, tree, parents, sig, nil, "message\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	base := commit(map[string]string{
+		"file.txt":    oldFile,
+		"script.sh":   "echo hi\n",
+		"bin.dat":     "\x00\x01binary\x00old",
+		"lib/old.txt": oldLib,
+	}, "", 100)
+	head := commit(map[string]string{
+		"file.txt":    strings.Replace(oldFile, "line 5\n", "line five\n", 1),
+		"script.sh":   "echo hi\n",
+		"bin.dat":     "\x00\x02changed\x00new data",
+		"lib/new.txt": strings.Replace(oldLib, "text 10\n", "text ten\n", 1),
+		"new.bin":     "\x00\xff\xfe",
+	}, "script.sh", 200, base)
+	return base, head
+}
+
+// inflateBinaryPatches replaces encoded data of binary hunks with its hex
+// dump, as deflate output of zlib and compress/flate differs.
+func inflateBinaryPatches(t *testing.T, data []byte) string {
+	lines := splitLines(data)
+	buf := &bytes.Buffer{}
+	for pos := 0; pos < len(lines); pos++ {
+		buf.Write(lines[pos])
+		if string(lines[pos]) != "GIT binary patch\n" {
+			continue
+		}
+
+		p := &patchParser{lines: lines, pos: pos + 1}
+		file := &patchFile{}
+		if err := p.parseBinary(file); err != nil {
+			t.Fatal(err)
+		}
+		for _, hunk := range file.binary {
+			fmt.Fprintf(buf, "delta=%v %d\n%x\n\n", hunk.isDelta, hunk.size, hunk.data)
+		}
+		pos = p.pos - 1
+	}
+	return buf.String()
+}
+
+func TestWritePatchGolden(t *testing.T) {
+	repo := newTestRepository(t)
+	base, head := patchFixture(t, repo)
+
+	for _, test := range []struct {
+		golden   string
+		noBinary bool
+	}{
+		{"patch_binary.golden", false},
+		{"patch_plain.golden", true},
+	} {
+		want, err := ioutil.ReadFile(filepath.Join("testdata", test.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := DefaultPatchOptions()
+		opts.NoBinary = test.noBinary
+		buf := &bytes.Buffer{}
+		if err = repo.WritePatch(buf, base.String(), head.String(), opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := inflateBinaryPatches(t, buf.Bytes()); got != inflateBinaryPatches(t, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.golden, buf, want)
+		}
+	}
+}
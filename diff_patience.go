@@ -0,0 +1,138 @@
+package git
+
+// Patience diff: anchor the diff on lines which are unique in both sides,
+// recurse between anchors and fall back to Myers when there are none.
+
+type patienceEntry struct {
+	line1, line2 int
+	// line2 is -1 until the line is seen in the second range, unique2 is
+	// cleared when it is seen there again
+	unique2 bool
+	count1  int
+
+	previous, next *patienceEntry
+}
+
+func patienceDiff(a, b *diffFile, line1, count1, line2, count2 int, minimal bool) {
+	switch {
+	case count1 == 0 && count2 == 0:
+		return
+	case count1 == 0:
+		for idx := line2; idx < line2+count2; idx++ {
+			b.setChanged(idx, true)
+		}
+		return
+	case count2 == 0:
+		for idx := line1; idx < line1+count1; idx++ {
+			a.setChanged(idx, true)
+		}
+		return
+	}
+
+	// lines unique in both ranges, in order of the first range
+	entries := map[int]*patienceEntry{}
+	order := []*patienceEntry{}
+	for idx := line1; idx < line1+count1; idx++ {
+		entry, ok := entries[a.recs[idx]]
+		if ok {
+			entry.count1++
+			continue
+		}
+		entry = &patienceEntry{line1: idx, line2: -1, count1: 1}
+		entries[a.recs[idx]] = entry
+		order = append(order, entry)
+	}
+	for idx := line2; idx < line2+count2; idx++ {
+		entry, ok := entries[b.recs[idx]]
+		if !ok {
+			continue
+		}
+		if entry.line2 == -1 {
+			entry.line2 = idx
+			entry.unique2 = true
+		} else {
+			entry.unique2 = false
+		}
+	}
+
+	first := longestCommonSequence(order)
+	if first == nil {
+		myersRange(a, b, line1, line1+count1, line2, line2+count2, minimal)
+		return
+	}
+
+	end1, end2 := line1+count1, line2+count2
+	for {
+		var next1, next2 int
+		if first != nil {
+			next1, next2 = first.line1, first.line2
+			for next1 > line1 && next2 > line2 && a.recs[next1-1] == b.recs[next2-1] {
+				next1--
+				next2--
+			}
+		} else {
+			next1, next2 = end1, end2
+		}
+		for line1 < next1 && line2 < next2 && a.recs[line1] == b.recs[line2] {
+			line1++
+			line2++
+		}
+
+		if next1 > line1 || next2 > line2 {
+			patienceDiff(a, b, line1, next1-line1, line2, next2-line2, minimal)
+		}
+		if first == nil {
+			return
+		}
+
+		for first.next != nil && first.next.line1 == first.line1+1 && first.next.line2 == first.line2+1 {
+			first = first.next
+		}
+
+		line1 = first.line1 + 1
+		line2 = first.line2 + 1
+		first = first.next
+	}
+}
+
+// longestCommonSequence runs patience sorting over unique common lines and
+// returns the first entry of the longest increasing sequence, linked by next.
+func longestCommonSequence(order []*patienceEntry) *patienceEntry {
+	sequence := make([]*patienceEntry, 0, len(order))
+	for _, entry := range order {
+		if entry.count1 != 1 || !entry.unique2 {
+			continue
+		}
+
+		left, right := -1, len(sequence)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > entry.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+
+		if left >= 0 {
+			entry.previous = sequence[left]
+		}
+		if left+1 == len(sequence) {
+			sequence = append(sequence, entry)
+		} else {
+			sequence[left+1] = entry
+		}
+	}
+
+	if len(sequence) == 0 {
+		return nil
+	}
+
+	entry := sequence[len(sequence)-1]
+	entry.next = nil
+	for entry.previous != nil {
+		entry.previous.next = entry
+		entry = entry.previous
+	}
+	return entry
+}
@@ -0,0 +1,278 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Diffstat rendering, as in `git diff --stat --summary`.
+
+// diffStatFile is a single line of diffstat. Binary files count bytes of
+// old and new content instead of lines.
+type diffStatFile struct {
+	name     string
+	added    int
+	deleted  int
+	isBinary bool
+}
+
+// diffStatFiles collects diffstat lines of changes.
+func (repo *Repository) diffStatFiles(changes []*TreeChange) ([]*diffStatFile, error) {
+	files := make([]*diffStatFile, 0, len(changes))
+	for _, change := range changes {
+		stats, err := repo.fileStats(change)
+		if err != nil {
+			return nil, err
+		}
+
+		file := &diffStatFile{
+			name:     quotePath(stats.Path),
+			added:    stats.Insertions,
+			deleted:  stats.Deletions,
+			isBinary: stats.IsBinary,
+		}
+		if stats.OldPath != "" {
+			file.name = renameName(stats.OldPath, stats.Path)
+		}
+
+		if file.isBinary {
+			if file.deleted, err = repo.statSize(change.OldID, change.OldMode); err != nil {
+				return nil, err
+			}
+			if file.added, err = repo.statSize(change.NewID, change.NewMode); err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (repo *Repository) statSize(id sha1, mode EntryMode) (int, error) {
+	if mode == 0 {
+		return 0, nil
+	}
+	size, err := repo.blobSize(id)
+	return int(size), err
+}
+
+// renameName shows old and new names with common leading directories and
+// trailing part factored out, like "dir/{old => new}/file".
+func renameName(a, b string) string {
+	if quotePath(a) != a || quotePath(b) != b {
+		return quotePath(a) + " => " + quotePath(b)
+	}
+
+	prefix := 0
+	for idx := 0; idx < len(a) && idx < len(b) && a[idx] == b[idx]; idx++ {
+		if a[idx] == '/' {
+			prefix = idx + 1
+		}
+	}
+
+	// common suffix starts with slash, it may overlap the slash which
+	// ends the prefix
+	suffix := 0
+	adjust := 0
+	if prefix > 0 {
+		adjust = 1
+	}
+	for ia, ib := len(a), len(b); ia >= prefix-adjust && ib >= prefix-adjust; ia, ib = ia-1, ib-1 {
+		var ca, cb byte
+		if ia < len(a) {
+			ca = a[ia]
+		}
+		if ib < len(b) {
+			cb = b[ib]
+		}
+		if ca != cb {
+			break
+		}
+		if ca == '/' {
+			suffix = len(a) - ia
+		}
+	}
+
+	aMid := len(a) - prefix - suffix
+	bMid := len(b) - prefix - suffix
+	if aMid < 0 {
+		aMid = 0
+	}
+	if bMid < 0 {
+		bMid = 0
+	}
+
+	if prefix+suffix == 0 {
+		return a[prefix:prefix+aMid] + " => " + b[prefix:prefix+bMid]
+	}
+	return a[:prefix] + "{" + a[prefix:prefix+aMid] + " => " + b[prefix:prefix+bMid] + "}" + a[len(a)-suffix:]
+}
+
+func decimalWidth(n int) int {
+	return len(fmt.Sprint(n))
+}
+
+// scaleLinear scales number of changed lines to graph width.
+func scaleLinear(n, width, maxChange int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/maxChange
+}
+
+// writeDiffStat writes diffstat of files fitting into width columns and the
+// totals line.
+func writeDiffStat(buf *bytes.Buffer, files []*diffStatFile, width int) {
+	maxLen, maxChange := 0, 0
+	numberWidth, binWidth := 0, 0
+	for _, file := range files {
+		if len(file.name) > maxLen {
+			maxLen = len(file.name)
+		}
+		if file.isBinary {
+			// "Bin XXX -> YYY bytes"
+			if w := 14 + decimalWidth(file.added) + decimalWidth(file.deleted); w > binWidth {
+				binWidth = w
+			}
+			numberWidth = 3
+			continue
+		}
+		if change := file.added + file.deleted; change > maxChange {
+			maxChange = change
+		}
+	}
+
+	if w := decimalWidth(maxChange); w > numberWidth {
+		numberWidth = w
+	}
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	insertions, deletions := 0, 0
+	for _, file := range files {
+		name, prefix := file.name, ""
+		if len(name) > nameWidth {
+			// keep the end of the name, starting from a directory if possible
+			prefix = "..."
+			keep := nameWidth - 3
+			if keep < 0 {
+				keep = 0
+			}
+			name = name[len(name)-keep:]
+			if idx := strings.IndexByte(name, '/'); idx != -1 {
+				name = name[idx:]
+			}
+		}
+		padding := nameWidth - len(prefix) - len(name)
+		if padding < 0 {
+			padding = 0
+		}
+
+		if file.isBinary {
+			fmt.Fprintf(buf, " %s%s%*s | %*s", prefix, name, padding, "", numberWidth, "Bin")
+			if file.added == 0 && file.deleted == 0 {
+				buf.WriteByte('\n')
+				continue
+			}
+			fmt.Fprintf(buf, " %d -> %d bytes\n", file.deleted, file.added)
+			continue
+		}
+
+		insertions += file.added
+		deletions += file.deleted
+
+		add, del := file.added, file.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+
+		space := ""
+		if file.added+file.deleted > 0 {
+			space = " "
+		}
+		fmt.Fprintf(buf, " %s%s%*s | %*d%s%s%s\n", prefix, name, padding, "", numberWidth, file.added+file.deleted, space,
+			strings.Repeat("+", add), strings.Repeat("-", del))
+	}
+
+	writeStatTotals(buf, len(files), insertions, deletions)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return fmt.Sprintf(one, n)
+	}
+	return fmt.Sprintf(many, n)
+}
+
+func writeStatTotals(buf *bytes.Buffer, files, insertions, deletions int) {
+	if files == 0 {
+		buf.WriteString(" 0 files changed\n")
+		return
+	}
+
+	buf.WriteString(plural(files, " %d file changed", " %d files changed"))
+	if insertions > 0 || deletions == 0 {
+		buf.WriteString(plural(insertions, ", %d insertion(+)", ", %d insertions(+)"))
+	}
+	if deletions > 0 || insertions == 0 {
+		buf.WriteString(plural(deletions, ", %d deletion(-)", ", %d deletions(-)"))
+	}
+	buf.WriteByte('\n')
+}
+
+// writeDiffSummary writes creations, deletions, renames and mode changes.
+func writeDiffSummary(buf *bytes.Buffer, changes []*TreeChange) {
+	for _, change := range changes {
+		switch change.Type {
+		case CHANGE_ADDED:
+			fmt.Fprintf(buf, " create mode %06o %s\n", change.NewMode, quotePath(change.NewPath))
+		case CHANGE_DELETED:
+			fmt.Fprintf(buf, " delete mode %06o %s\n", change.OldMode, quotePath(change.OldPath))
+		case CHANGE_RENAMED, CHANGE_COPIED:
+			verb := "rename"
+			if change.Type == CHANGE_COPIED {
+				verb = "copy"
+			}
+			fmt.Fprintf(buf, " %s %s (%d%%)\n", verb, renameName(change.OldPath, change.NewPath), change.Similarity)
+			if change.OldMode != change.NewMode {
+				fmt.Fprintf(buf, " mode change %06o => %06o\n", change.OldMode, change.NewMode)
+			}
+		default:
+			if change.OldMode != change.NewMode {
+				fmt.Fprintf(buf, " mode change %06o => %06o %s\n", change.OldMode, change.NewMode, quotePath(change.NewPath))
+			}
+		}
+	}
+}
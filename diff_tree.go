@@ -0,0 +1,464 @@
+package git
+
+import (
+	"path"
+	"sort"
+)
+
+// ChangeType is a kind of change found by DiffTrees.
+type ChangeType int
+
+const (
+	CHANGE_ADDED ChangeType = iota + 1
+	CHANGE_DELETED
+	CHANGE_MODIFIED
+	CHANGE_TYPE_CHANGED
+	CHANGE_RENAMED
+	CHANGE_COPIED
+)
+
+// String returns status letter of the change, as `git diff --name-status` shows it.
+func (t ChangeType) String() string {
+	switch t {
+	case CHANGE_ADDED:
+		return "A"
+	case CHANGE_DELETED:
+		return "D"
+	case CHANGE_MODIFIED:
+		return "M"
+	case CHANGE_TYPE_CHANGED:
+		return "T"
+	case CHANGE_RENAMED:
+		return "R"
+	case CHANGE_COPIED:
+		return "C"
+	}
+	return "?"
+}
+
+// TreeChange describes a single changed path between two trees. Old fields
+// are zero for added entries and new fields are zero for deleted ones.
+type TreeChange struct {
+	Type    ChangeType
+	OldPath string
+	NewPath string
+	OldMode EntryMode
+	NewMode EntryMode
+	OldID   sha1
+	NewID   sha1
+
+	// Similarity of renamed or copied file to its source, in percents.
+	Similarity int
+}
+
+// Path returns the path of the change in the new tree, or in the old tree if
+// entry was deleted.
+func (c *TreeChange) Path() string {
+	if c.Type == CHANGE_DELETED {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+// DefaultRenameLimit bounds number of files considered for inexact rename
+// detection, as diff.renameLimit does.
+var DefaultRenameLimit = 1000
+
+type DiffTreeOptions struct {
+	// DetectRenames pairs deleted and added files with similar content.
+	DetectRenames bool
+	// DetectCopies also looks for sources among modified files. It implies
+	// DetectRenames.
+	DetectCopies bool
+	// RenameThreshold is minimal similarity score in percents,
+	// DefaultRenameThreshold if zero.
+	RenameThreshold int
+	// RenameLimit is DefaultRenameLimit if zero.
+	RenameLimit int
+	// Paths limits the diff to given pathspec.
+	Paths []string
+}
+
+// entryKind groups modes which can be changed into each other without
+// changing type of the entry.
+func entryKind(mode EntryMode) EntryMode {
+	if mode == ENTRY_MODE_EXEC {
+		return ENTRY_MODE_BLOB
+	}
+	return mode
+}
+
+// DiffTrees compares two trees and returns changed entries. Nil tree stands
+// for an empty one. Subtrees are only read where their ids differ.
+func (repo *Repository) DiffTrees(oldTree, newTree *Tree, opts DiffTreeOptions) ([]*TreeChange, error) {
+	var oldID, newID sha1
+	if oldTree != nil {
+		oldID = oldTree.ID
+	}
+	if newTree != nil {
+		newID = newTree.ID
+	}
+
+	return repo.diffTrees(oldID, newID, opts)
+}
+
+func (repo *Repository) diffTrees(oldID, newID sha1, opts DiffTreeOptions) ([]*TreeChange, error) {
+	changes := []*TreeChange{}
+	err := repo.diffTreeIDs(oldID, newID, "", pathSpec(opts.Paths), func(relpath string, from, to *pathEntry) error {
+		change := &TreeChange{OldPath: relpath, NewPath: relpath}
+		switch {
+		case from == nil:
+			change.Type = CHANGE_ADDED
+			change.OldPath = ""
+		case to == nil:
+			change.Type = CHANGE_DELETED
+			change.NewPath = ""
+		case entryKind(from.Mode) != entryKind(to.Mode):
+			change.Type = CHANGE_TYPE_CHANGED
+		default:
+			change.Type = CHANGE_MODIFIED
+		}
+
+		if from != nil {
+			change.OldMode, change.OldID = from.Mode, from.ID
+		}
+		if to != nil {
+			change.NewMode, change.NewID = to.Mode, to.ID
+		}
+
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DetectRenames || opts.DetectCopies {
+		return repo.detectRenames(changes, opts)
+	}
+
+	return changes, nil
+}
+
+// renamePair is a candidate source for an added file.
+type renamePair struct {
+	source int
+	target int
+	score  int
+}
+
+// detectRenames turns added files into renames and copies of deleted (and,
+// with copies enabled, modified) files. Like git, exact matches are found
+// first, then files with the same unique basename, then remaining files are
+// paired by similarity score. Without copy detection each source is used
+// once. A deleted source used by more than one target is a rename for the
+// last one and a copy for the others.
+func (repo *Repository) detectRenames(changes []*TreeChange, opts DiffTreeOptions) ([]*TreeChange, error) {
+	threshold := opts.RenameThreshold
+	if threshold <= 0 {
+		threshold = DefaultRenameThreshold
+	}
+	limit := opts.RenameLimit
+	if limit <= 0 {
+		limit = DefaultRenameLimit
+	}
+
+	var sources, targets []int
+	for idx, change := range changes {
+		switch {
+		case change.OldMode == ENTRY_MODE_COMMIT || change.NewMode == ENTRY_MODE_COMMIT:
+			// submodules are never renamed
+		case change.Type == CHANGE_ADDED:
+			targets = append(targets, idx)
+		case change.Type == CHANGE_DELETED:
+			sources = append(sources, idx)
+		case change.Type == CHANGE_MODIFIED && opts.DetectCopies:
+			sources = append(sources, idx)
+		}
+	}
+
+	if len(sources) == 0 || len(targets) == 0 {
+		return changes, nil
+	}
+
+	paired := map[int]*renamePair{}
+	// modified sources count as used by themselves, so deleted ones are
+	// preferred and only targets left get copies
+	used := map[int]int{}
+	for _, source := range sources {
+		if changes[source].Type != CHANGE_DELETED {
+			used[source] = 1
+		}
+	}
+
+	// exact renames
+	byID := map[sha1][]int{}
+	for _, source := range sources {
+		id := changes[source].OldID
+		byID[id] = append(byID[id], source)
+	}
+	for _, target := range targets {
+		best, bestScore := -1, -1
+		for _, source := range byID[changes[target].NewID] {
+			if entryKind(changes[source].OldMode) != entryKind(changes[target].NewMode) {
+				continue
+			}
+			if used[source] > 0 && !opts.DetectCopies {
+				continue
+			}
+
+			// prefer sources which were not used yet and have the same name
+			score := 0
+			if used[source] == 0 {
+				score++
+			}
+			if sameBasename(changes[source].OldPath, changes[target].NewPath) {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = source, score
+			}
+		}
+
+		if best != -1 {
+			paired[target] = &renamePair{source: best, target: target, score: maxRenameScore}
+			used[best]++
+		}
+	}
+
+	var left []int
+	for _, target := range targets {
+		if paired[target] == nil {
+			left = append(left, target)
+		}
+	}
+
+	if len(left) > 0 && !opts.DetectCopies {
+		// files moved to other directory usually keep their name, such
+		// pairs are tried first with a higher threshold
+		pairs, err := repo.basenameRenames(changes, sources, left, used, threshold+(100-threshold)/2)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			paired[pair.target] = pair
+			used[pair.source]++
+		}
+
+		left = left[:0]
+		for _, target := range targets {
+			if paired[target] == nil {
+				left = append(left, target)
+			}
+		}
+	}
+
+	// inexact renames
+	if len(left) > 0 && len(left)*len(sources) <= limit*limit {
+		pairs, err := repo.scoreRenames(changes, sources, left, threshold)
+		if err != nil {
+			return nil, err
+		}
+
+		// sources are used once, then again for copies
+		for _, copies := range []bool{false, opts.DetectCopies} {
+			for _, pair := range pairs {
+				if paired[pair.target] != nil || (!copies && used[pair.source] > 0) {
+					continue
+				}
+
+				paired[pair.target] = pair
+				used[pair.source]++
+			}
+		}
+	}
+
+	// rebuild change list, renames take place of their targets
+	uses := map[int]int{}
+	for source, count := range used {
+		uses[source] = count
+	}
+	result := make([]*TreeChange, 0, len(changes))
+	for idx, change := range changes {
+		pair := paired[idx]
+		if pair == nil {
+			if change.Type != CHANGE_DELETED || used[idx] == 0 {
+				result = append(result, change)
+			}
+			continue
+		}
+
+		source := changes[pair.source]
+		change.OldPath = source.OldPath
+		change.OldMode = source.OldMode
+		change.OldID = source.OldID
+		change.Similarity = pair.score * 100 / maxRenameScore
+
+		uses[pair.source]--
+		if source.Type == CHANGE_DELETED && uses[pair.source] == 0 {
+			change.Type = CHANGE_RENAMED
+		} else {
+			change.Type = CHANGE_COPIED
+		}
+		result = append(result, change)
+	}
+
+	return result, nil
+}
+
+// sameBasename reports whether paths have the same last component.
+func sameBasename(a, b string) bool {
+	return path.Base(a) == path.Base(b)
+}
+
+// basenameRenames pairs sources and targets having a basename unique among
+// both of them, if they are similar enough.
+func (repo *Repository) basenameRenames(changes []*TreeChange, sources, targets []int, used map[int]int, threshold int) ([]*renamePair, error) {
+	uniqueNames := func(indexes []int, name func(*TreeChange) string) map[string]int {
+		names := map[string]int{}
+		for _, idx := range indexes {
+			base := path.Base(name(changes[idx]))
+			if _, ok := names[base]; ok {
+				names[base] = -1
+			} else {
+				names[base] = idx
+			}
+		}
+		return names
+	}
+
+	var free []int
+	for _, source := range sources {
+		if used[source] == 0 {
+			free = append(free, source)
+		}
+	}
+	sourceNames := uniqueNames(free, func(change *TreeChange) string { return change.OldPath })
+	targetNames := uniqueNames(targets, func(change *TreeChange) string { return change.NewPath })
+
+	pairs := []*renamePair{}
+	for _, source := range free {
+		base := path.Base(changes[source].OldPath)
+		target, ok := targetNames[base]
+		if !ok || target == -1 || sourceNames[base] == -1 {
+			continue
+		}
+
+		scored, err := repo.scoreRenames(changes, []int{source}, []int{target}, threshold)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, scored...)
+	}
+	return pairs, nil
+}
+
+// scoreRenames computes similarity of all source and target pairs passing the
+// threshold, best pairs first.
+func (repo *Repository) scoreRenames(changes []*TreeChange, sources, targets []int, threshold int) ([]*renamePair, error) {
+	contents := map[sha1][]byte{}
+	read := func(id sha1) ([]byte, error) {
+		if data, ok := contents[id]; ok {
+			return data, nil
+		}
+
+		data, err := repo.readBlob(id)
+		if err != nil {
+			return nil, err
+		}
+		contents[id] = data
+		return data, nil
+	}
+
+	sizes := map[sha1]int64{}
+	size := func(id sha1) (int64, error) {
+		if size, ok := sizes[id]; ok {
+			return size, nil
+		}
+
+		size, err := repo.blobSize(id)
+		if err != nil {
+			return 0, err
+		}
+		sizes[id] = size
+		return size, nil
+	}
+
+	minScore := threshold * maxRenameScore / 100
+	pairs := []*renamePair{}
+	for _, target := range targets {
+		dstID := changes[target].NewID
+		dstSize, err := size(dstID)
+		if err != nil {
+			return nil, err
+		}
+
+		// as git does, only a few best candidates are kept for each target
+		best := make([]*renamePair, 0, renameCandidates)
+		for _, source := range sources {
+			srcID := changes[source].OldID
+			if entryKind(changes[source].OldMode) != entryKind(changes[target].NewMode) {
+				continue
+			}
+
+			srcSize, err := size(srcID)
+			if err != nil {
+				return nil, err
+			}
+
+			if !sizesCouldMatch(srcSize, dstSize, threshold) {
+				continue
+			}
+
+			src, err := read(srcID)
+			if err != nil {
+				return nil, err
+			}
+
+			dst, err := read(dstID)
+			if err != nil {
+				return nil, err
+			}
+
+			score := similarityScore(src, dst)
+			if score < minScore {
+				continue
+			}
+
+			pair := &renamePair{source: source, target: target, score: score}
+			if len(best) < renameCandidates {
+				best = append(best, pair)
+				continue
+			}
+			worst := 0
+			for idx := range best {
+				if betterPair(changes, best[worst], best[idx]) {
+					worst = idx
+				}
+			}
+			if betterPair(changes, pair, best[worst]) {
+				best[worst] = pair
+			}
+		}
+		pairs = append(pairs, best...)
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return betterPair(changes, pairs[i], pairs[j])
+	})
+
+	return pairs, nil
+}
+
+// renameCandidates bounds number of sources kept for each target
+const renameCandidates = 4
+
+// betterPair orders pairs by score, then prefers sources with the same
+// basename as the target.
+func betterPair(changes []*TreeChange, a, b *renamePair) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return sameBasename(changes[a.source].OldPath, changes[a.target].NewPath) &&
+		!sameBasename(changes[b.source].OldPath, changes[b.target].NewPath)
+}
@@ -0,0 +1,184 @@
+package git
+
+import (
+	"regexp"
+	"strings"
+)
+
+type WordSegmentType int
+
+const (
+	WORD_SEGMENT_EQUAL WordSegmentType = iota + 1
+	WORD_SEGMENT_INSERT
+	WORD_SEGMENT_DELETE
+)
+
+// WordSegment is a piece of line text which is either common to both sides,
+// or only present in the new or old one.
+type WordSegment struct {
+	Type WordSegmentType
+	Text string
+}
+
+// WordDiffOptions controls tokenization of word diff.
+type WordDiffOptions struct {
+	// WordRegex matches a single token, as --word-diff-regex. Text between
+	// matches forms tokens too, so nothing is lost. By default tokens are
+	// runs of non-whitespace and runs of whitespace, as in git.
+	WordRegex *regexp.Regexp
+}
+
+var defaultWordRegex = regexp.MustCompile(`[^ \t\n\v\f\r]+`)
+
+// splitWords splits text into tokens, line terminators are always tokens of
+// their own.
+func splitWords(text string, re *regexp.Regexp) [][]byte {
+	if re == nil {
+		re = defaultWordRegex
+	}
+
+	tokens := [][]byte{}
+	gap := func(text string) {
+		for len(text) > 0 {
+			idx := strings.IndexByte(text, '\n')
+			if idx == -1 {
+				tokens = append(tokens, []byte(text))
+				return
+			}
+			if idx > 0 {
+				tokens = append(tokens, []byte(text[:idx]))
+			}
+			tokens = append(tokens, []byte{'\n'})
+			text = text[idx+1:]
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		last := 0
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			gap(line[last:loc[0]])
+			gap(line[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		gap(line[last:])
+	}
+	return tokens
+}
+
+// DiffWords diffs two texts token by token. Segments come in text order,
+// equal and deleted ones make the old text, equal and inserted ones the new.
+func DiffWords(oldText, newText string, opts WordDiffOptions) []*WordSegment {
+	identity := func(token []byte) []byte {
+		return token
+	}
+	a, b := newRecordFiles(splitWords(oldText, opts.WordRegex), splitWords(newText, opts.WordRegex), identity)
+	myersRange(a, b, 0, len(a.recs), 0, len(b.recs), false)
+	compactChanges(a, b, false)
+	compactChanges(b, a, false)
+
+	segments := []*WordSegment{}
+	add := func(typ WordSegmentType, token []byte) {
+		if last := len(segments) - 1; last >= 0 && segments[last].Type == typ {
+			segments[last].Text += string(token)
+			return
+		}
+		segments = append(segments, &WordSegment{Type: typ, Text: string(token)})
+	}
+
+	i, j := 0, 0
+	for i < len(a.lines) || j < len(b.lines) {
+		switch {
+		case i < len(a.lines) && a.isChanged(i):
+			add(WORD_SEGMENT_DELETE, a.lines[i])
+			i++
+		case j < len(b.lines) && b.isChanged(j):
+			add(WORD_SEGMENT_INSERT, b.lines[j])
+			j++
+		default:
+			add(WORD_SEGMENT_EQUAL, b.lines[j])
+			i++
+			j++
+		}
+	}
+
+	return segments
+}
+
+// WordDiff fills Segments of removed lines followed by added ones, each such
+// block is diffed word by word as a whole. Lines which have no counterpart
+// keep nil Segments.
+func (h *DiffHunk) WordDiff(opts WordDiffOptions) {
+	for idx := 0; idx < len(h.Lines); {
+		start := idx
+		for idx < len(h.Lines) && h.Lines[idx].Type == DIFF_LINE_DEL {
+			idx++
+		}
+		middle := idx
+		for idx < len(h.Lines) && h.Lines[idx].Type == DIFF_LINE_ADD {
+			idx++
+		}
+
+		if start == middle || middle == idx {
+			if idx == start {
+				idx++
+			}
+			continue
+		}
+
+		wordDiffBlock(h.Lines[start:middle], h.Lines[middle:idx], opts)
+	}
+}
+
+func joinLines(lines []*DiffLine) string {
+	contents := make([]string, len(lines))
+	for idx, line := range lines {
+		contents[idx] = line.Content
+	}
+	return strings.Join(contents, "\n")
+}
+
+// wordDiffBlock diffs removed lines against added ones and splits resulting
+// segments back into lines.
+func wordDiffBlock(removed, added []*DiffLine, opts WordDiffOptions) {
+	for _, line := range removed {
+		line.Segments = []*WordSegment{}
+	}
+	for _, line := range added {
+		line.Segments = []*WordSegment{}
+	}
+
+	oldLine, newLine := 0, 0
+	appendTo := func(lines []*DiffLine, current *int, typ WordSegmentType, text string) {
+		for {
+			idx := strings.IndexByte(text, '\n')
+			piece := text
+			if idx != -1 {
+				piece = text[:idx]
+			}
+			if piece != "" {
+				line := lines[*current]
+				line.Segments = append(line.Segments, &WordSegment{Type: typ, Text: piece})
+			}
+			if idx == -1 {
+				return
+			}
+			*current++
+			text = text[idx+1:]
+		}
+	}
+
+	for _, segment := range DiffWords(joinLines(removed), joinLines(added), opts) {
+		switch segment.Type {
+		case WORD_SEGMENT_EQUAL:
+			appendTo(removed, &oldLine, segment.Type, segment.Text)
+			appendTo(added, &newLine, segment.Type, segment.Text)
+		case WORD_SEGMENT_DELETE:
+			appendTo(removed, &oldLine, segment.Type, segment.Text)
+		case WORD_SEGMENT_INSERT:
+			appendTo(added, &newLine, segment.Type, segment.Text)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+// formatSegments renders segments as git diff --word-diff=plain does.
+func formatSegments(segments []*WordSegment) string {
+	buf := &bytes.Buffer{}
+	for _, segment := range segments {
+		switch segment.Type {
+		case WORD_SEGMENT_DELETE:
+			buf.WriteString("[-" + segment.Text + "-]")
+		case WORD_SEGMENT_INSERT:
+			buf.WriteString("{+" + segment.Text + "+}")
+		default:
+			buf.WriteString(segment.Text)
+		}
+	}
+	return buf.String()
+}
+
+func TestDiffWords(t *testing.T) {
+	for _, test := range []struct {
+		oldText, newText string
+		regex            string
+		want             string
+	}{
+		{"the quick brown fox", "the slow brown fox", "", "the [-quick-]{+slow+} brown fox"},
+		{"the fox", "the brown fox", "", "the {+brown +}fox"},
+		{"a b c", "a c", "", "a [-b -]c"},
+		{"one two\nthree", "one 2\nthree", "", "one [-two-]{+2+}\nthree"},
+		{"same", "same", "", "same"},
+		{"", "new", "", "{+new+}"},
+		// whitespace runs are tokens, changing them alone is a change
+		{"a b", "a  b", "", "a[- -]{+  +}b"},
+		{"a\tb ", "a\tb", "", "a\tb[- -]"},
+		// default tokens span punctuation
+		{"foo(bar)", "foo(baz)", "", "[-foo(bar)-]{+foo(baz)+}"},
+		{"foo(bar)", "foo(baz)", `[a-z]+|[^a-z]`, "foo([-bar-]{+baz+})"},
+		// text between matches forms tokens, empty matches are skipped
+		{"a1b", "a2b", `[0-9]*`, "a[-1-]{+2+}b"},
+		{"x = 10;", "x = 12;", `[0-9]`, "x = 1[-0-]{+2+};"},
+	} {
+		opts := WordDiffOptions{}
+		if test.regex != "" {
+			opts.WordRegex = regexp.MustCompile(test.regex)
+		}
+		if got := formatSegments(DiffWords(test.oldText, test.newText, opts)); got != test.want {
+			t.Errorf("DiffWords(%q, %q, %q) = %q, want %q", test.oldText, test.newText, test.regex, got, test.want)
+		}
+	}
+}
+
+func TestHunkWordDiff(t *testing.T) {
+	hunk := DiffLines([]byte("keep\nfoo bar\nbaz\nkeep\nold\n"), []byte("keep\nfoo qux\nbaz 2\nkeep\nnew line\nadded\n"), LineDiffOptions{ContextLines: 1})[0]
+	hunk.WordDiff(WordDiffOptions{})
+
+	got := []string{}
+	for _, line := range hunk.Lines {
+		if line.Segments == nil {
+			got = append(got, "nil")
+			continue
+		}
+		got = append(got, formatSegments(line.Segments))
+	}
+	want := []string{"nil", "foo [-bar-]", "baz", "foo {+qux+}", "baz{+ 2+}", "nil", "[-old-]", "{+new line+}", "{+added+}"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("line %d: got %q, want %q", idx, got[idx], want[idx])
+		}
+	}
+}
@@ -2,6 +2,7 @@ package git
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -44,3 +45,72 @@ func IsErrUnsupportedVersion(err error) bool {
 func (err ErrUnsupportedVersion) Error() string {
 	return fmt.Sprintf("Operation requires higher version [required: %s]", err.Required)
 }
+
+type ErrInvalidCursor struct {
+	Cursor string
+}
+
+func IsErrInvalidCursor(err error) bool {
+	_, ok := err.(ErrInvalidCursor)
+	return ok
+}
+
+func (err ErrInvalidCursor) Error() string {
+	return fmt.Sprintf("invalid pagination cursor [cursor: %s]", err.Cursor)
+}
+
+type ErrPatchConflict struct {
+	Conflicts []*PatchConflict
+}
+
+func IsErrPatchConflict(err error) bool {
+	_, ok := err.(ErrPatchConflict)
+	return ok
+}
+
+func (err ErrPatchConflict) Error() string {
+	first := err.Conflicts[0]
+	return fmt.Sprintf("patch does not apply [path: %s, hunk: %d, reason: %s, conflicts: %d]", first.Path, first.Hunk, first.Reason, len(err.Conflicts))
+}
+
+type ErrMergeConflict struct {
+	Conflicts []*MergeConflict
+}
+
+func IsErrMergeConflict(err error) bool {
+	_, ok := err.(ErrMergeConflict)
+	return ok
+}
+
+func (err ErrMergeConflict) Error() string {
+	first := err.Conflicts[0]
+	return fmt.Sprintf("merge conflict [path: %s, type: %s, conflicts: %d]", first.Path, first.Type, len(err.Conflicts))
+}
+
+type ErrRebaseConflict struct {
+	CommitID  string
+	Conflicts []*MergeConflict
+}
+
+func IsErrRebaseConflict(err error) bool {
+	_, ok := err.(ErrRebaseConflict)
+	return ok
+}
+
+func (err ErrRebaseConflict) Error() string {
+	first := err.Conflicts[0]
+	return fmt.Sprintf("rebase conflict [commit: %s, path: %s, type: %s, conflicts: %d]", err.CommitID, first.Path, first.Type, len(err.Conflicts))
+}
+
+type ErrNoMergeBase struct {
+	Revisions []string
+}
+
+func IsErrNoMergeBase(err error) bool {
+	_, ok := err.(ErrNoMergeBase)
+	return ok
+}
+
+func (err ErrNoMergeBase) Error() string {
+	return fmt.Sprintf("no merge base found [revisions: %s]", strings.Join(err.Revisions, ", "))
+}
@@ -0,0 +1,449 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatPatchOptions controls patch series export.
+type FormatPatchOptions struct {
+	PatchOptions
+	// SubjectPrefix replaces "PATCH" in subjects
+	SubjectPrefix string
+	// Numbered adds "n/m" to the subject of a single patch too
+	Numbered bool
+	// Signature is written after each patch, empty means no signature
+	Signature string
+}
+
+// DefaultFormatPatchOptions returns options producing the same output as
+// `git format-patch` does by default.
+func DefaultFormatPatchOptions() FormatPatchOptions {
+	return FormatPatchOptions{
+		PatchOptions:  DefaultPatchOptions(),
+		SubjectPrefix: "PATCH",
+	}
+}
+
+const (
+	// mailWrap is the width of wrapped headers and of diffstat
+	mailWrap = 78
+	statWrap = 72
+	// patchNameMax bounds length of patch file names
+	patchNameMax = 64
+)
+
+// FormatPatch writes commits reachable from head but not from base as mbox
+// stream, one message per commit, the same way `git format-patch --stdout`
+// does. Merge and empty commits are skipped.
+func (repo *Repository) FormatPatch(w io.Writer, base, head string, opts FormatPatchOptions) error {
+	commits, err := repo.patchSeries(base, head)
+	if err != nil {
+		return err
+	}
+
+	for idx, commit := range commits {
+		if idx > 0 {
+			if _, err = io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err = repo.writePatchMail(w, commit, idx+1, len(commits), &opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FormatPatchFiles writes each commit of the series as a separate file in
+// dir, named like "0001-subject.patch", and returns paths of the files.
+// Merge and empty commits are skipped.
+func (repo *Repository) FormatPatchFiles(dir, base, head string, opts FormatPatchOptions) ([]string, error) {
+	commits, err := repo.patchSeries(base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(commits))
+	for idx, commit := range commits {
+		buf := &bytes.Buffer{}
+		if err = repo.writePatchMail(buf, commit, idx+1, len(commits), &opts); err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(dir, formatPatchName(idx+1, commit.Summary()))
+		if err = ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// patchSeries returns non-merge commits of base..head, oldest first. Commits
+// without changes are left out.
+func (repo *Repository) patchSeries(base, head string) ([]*Commit, error) {
+	baseID, err := repo.resolveRevision(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headID, err := repo.resolveRevision(head)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := repo.seriesNodes(baseID, headID)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]*Commit, 0, len(nodes))
+	for _, node := range nodes {
+		commit, err := node.commit(repo)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+// seriesNodes walks non-merge commits of base..head and returns them oldest
+// first. Commits without changes are left out.
+func (repo *Repository) seriesNodes(baseID, headID sha1) ([]*walkNode, error) {
+	walk := newRevWalk(repo)
+	if err := walk.hide(baseID); err != nil {
+		return nil, err
+	}
+	if err := walk.push(headID); err != nil {
+		return nil, err
+	}
+
+	nodes := []*walkNode{}
+	for {
+		node, err := walk.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(node.parents) > 1 {
+			continue
+		}
+
+		parentTree, treeID, err := repo.commitTrees(node.id)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree == treeID {
+			// empty commits would make empty messages
+			continue
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes, nil
+}
+
+// formatPatchName returns "0001-subject.patch" file name for the patch.
+func formatPatchName(nr int, subject string) string {
+	name := fmt.Sprintf("%04d-%s", nr, sanitizeSubject(subject))
+	if max := patchNameMax - len(".patch") - 1; len(name) > max {
+		name = name[:max]
+	}
+	return name + ".patch"
+}
+
+func isTitleChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '.' || ch == '_'
+}
+
+// sanitizeSubject turns subject into a file name part: runs of other
+// characters become single dashes.
+func sanitizeSubject(subject string) string {
+	buf := &bytes.Buffer{}
+	space := 2
+	for idx := 0; idx < len(subject); idx++ {
+		ch := subject[idx]
+		if !isTitleChar(ch) {
+			space |= 1
+			continue
+		}
+
+		if space == 1 {
+			buf.WriteByte('-')
+		}
+		space = 0
+		buf.WriteByte(ch)
+		if ch == '.' {
+			for idx+1 < len(subject) && subject[idx+1] == '.' {
+				idx++
+			}
+		}
+	}
+	return strings.TrimRight(buf.String(), ".-")
+}
+
+func (repo *Repository) writePatchMail(w io.Writer, commit *Commit, nr, total int, opts *FormatPatchOptions) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From %s Mon Sep 17 00:00:00 2001\n", commit.ID)
+	writeFromHeader(buf, commit.Author)
+	fmt.Fprintf(buf, "Date: %s\n", commit.Author.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+
+	buf.WriteString("Subject: [" + opts.SubjectPrefix)
+	if total > 1 || opts.Numbered {
+		fmt.Fprintf(buf, " %0*d/%d", decimalWidth(total), nr, total)
+	}
+	buf.WriteString("] ")
+
+	subject, body := splitMessage(commit.CommitMessage)
+	if needsEncoding(subject) {
+		writeEncodedWord(buf, subject, false)
+	} else {
+		wrapText(buf, subject, -lastLineLength(buf), 1, mailWrap)
+	}
+	buf.WriteByte('\n')
+
+	if hasNonASCII(commit.CommitMessage) {
+		buf.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(body)
+	buf.WriteString("---\n")
+
+	parentTree, treeID, err := repo.commitTrees(commit.ID)
+	if err != nil {
+		return err
+	}
+
+	changes, err := repo.diffTrees(parentTree, treeID, opts.DiffTreeOptions)
+	if err != nil {
+		return err
+	}
+
+	files, err := repo.diffStatFiles(changes)
+	if err != nil {
+		return err
+	}
+	writeDiffStat(buf, files, statWrap)
+	writeDiffSummary(buf, changes)
+	buf.WriteByte('\n')
+
+	if _, err = w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err = repo.writeTreePatch(w, parentTree, treeID, opts.PatchOptions); err != nil {
+		return err
+	}
+
+	if opts.Signature != "" {
+		_, err = fmt.Fprintf(w, "-- \n%s\n\n", opts.Signature)
+	}
+	return err
+}
+
+// commitTrees returns trees of the first parent and of the commit itself.
+func (repo *Repository) commitTrees(id sha1) (sha1, sha1, error) {
+	node, err := repo.loadWalkNode(id)
+	if err != nil {
+		return sha1{}, sha1{}, err
+	}
+
+	var parentTree sha1
+	if len(node.parents) > 0 {
+		parent, err := repo.loadWalkNode(node.parents[0])
+		if err != nil {
+			return sha1{}, sha1{}, err
+		}
+		parentTree = parent.tree
+	}
+
+	return parentTree, node.tree, nil
+}
+
+// splitMessage returns subject line and the rest of commit message, which
+// is empty or ends with newline.
+func splitMessage(message string) (string, string) {
+	message = strings.TrimLeft(message, "\n")
+	subject := strings.TrimRight(strings.SplitN(message, "\n", 2)[0], " \t")
+
+	body := ""
+	if idx := strings.IndexByte(message, '\n'); idx != -1 {
+		body = strings.TrimLeft(message[idx+1:], "\n")
+		body = strings.TrimRight(body, " \t\n")
+	}
+	if body != "" {
+		body += "\n"
+	}
+	return subject, body
+}
+
+func hasNonASCII(text string) bool {
+	for idx := 0; idx < len(text); idx++ {
+		if text[idx] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// needsEncoding reports whether header value must be RFC 2047 encoded.
+func needsEncoding(text string) bool {
+	return hasNonASCII(text) || strings.Contains(text, "\n") || strings.Contains(text, "=?")
+}
+
+func lastLineLength(buf *bytes.Buffer) int {
+	data := buf.Bytes()
+	return len(data) - bytes.LastIndexByte(data, '\n') - 1
+}
+
+// writeFromHeader writes "From:" header, encoding or quoting the name if
+// needed.
+func writeFromHeader(buf *bytes.Buffer, author *Signature) {
+	buf.WriteString("From: ")
+	maxLength := mailWrap
+	switch {
+	case needsEncoding(author.Name):
+		writeEncodedWord(buf, author.Name, true)
+		maxLength = 76
+	case strings.ContainsAny(author.Name, `()<>@,;:\".[]`):
+		quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(author.Name) + `"`
+		wrapText(buf, quoted, -len("From: "), 1, maxLength)
+	default:
+		wrapText(buf, author.Name, -len("From: "), 1, maxLength)
+	}
+
+	if maxLength < lastLineLength(buf)+len(" <")+len(author.Email)+len(">") {
+		buf.WriteByte('\n')
+	}
+	fmt.Fprintf(buf, " <%s>\n", author.Email)
+}
+
+// isEncodedSpecial reports whether byte must be escaped in RFC 2047 encoded
+// word. Addresses allow fewer characters unescaped.
+func isEncodedSpecial(ch byte, address bool) bool {
+	if ch >= 0x80 || ch == ' ' || ch == '=' || ch == '?' || ch == '_' {
+		return true
+	}
+	if !address {
+		return false
+	}
+	return !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		ch == '!' || ch == '*' || ch == '+' || ch == '-' || ch == '/')
+}
+
+// writeEncodedWord writes text as RFC 2047 "Q" encoded words, folded so no
+// line exceeds 76 characters. Multibyte characters are never split.
+func writeEncodedWord(buf *bytes.Buffer, text string, address bool) {
+	const maxEncoded = 76
+	lineLen := lastLineLength(buf) + len("=?UTF-8?q?")
+	buf.WriteString("=?UTF-8?q?")
+
+	for len(text) > 0 {
+		_, size := utf8.DecodeRuneInString(text)
+		char := text[:size]
+		text = text[size:]
+
+		special := size > 1 || isEncodedSpecial(char[0], address)
+		encodedLen := 1
+		if special {
+			encodedLen = 3 * size
+		}
+
+		if lineLen+encodedLen+2 > maxEncoded {
+			buf.WriteString("?=\n =?UTF-8?q?")
+			lineLen = len("=?UTF-8?q?") + 1
+		}
+
+		if special {
+			for idx := 0; idx < size; idx++ {
+				fmt.Fprintf(buf, "=%02X", char[idx])
+			}
+		} else {
+			buf.WriteString(char)
+		}
+		lineLen += encodedLen
+	}
+	buf.WriteString("?=")
+}
+
+// wrapText writes text wrapped at spaces to width columns. Negative indent1
+// is the number of columns already taken on the first line, continuation
+// lines are indented by indent2.
+func wrapText(buf *bytes.Buffer, text string, indent1, indent2, width int) {
+	indent := indent1
+	w := indent
+	space := -1
+	if indent < 0 {
+		w = -indent
+		space = 0
+	}
+	bol := 0
+
+	for pos := 0; ; {
+		var ch byte
+		if pos < len(text) {
+			ch = text[pos]
+		}
+
+		if ch != 0 && !isSpace(ch) {
+			_, size := utf8.DecodeRuneInString(text[pos:])
+			w++
+			pos += size
+			continue
+		}
+
+		if w <= width || space == -1 {
+			start := bol
+			if ch == 0 && pos == start {
+				return
+			}
+			if space != -1 {
+				start = space
+			} else {
+				buf.WriteString(strings.Repeat(" ", indent))
+			}
+			buf.WriteString(text[start:pos])
+			if ch == 0 {
+				return
+			}
+
+			space = pos
+			if ch == '\t' {
+				w |= 0x07
+			}
+			w++
+			pos++
+			continue
+		}
+
+		// the word does not fit, move it to a new line
+		buf.WriteByte('\n')
+		bol = space
+		if bol < len(text) && isSpace(text[bol]) {
+			bol++
+		}
+		pos = bol
+		space = -1
+		indent = indent2
+		w = indent
+	}
+}
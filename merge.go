@@ -0,0 +1,789 @@
+package git
+
+import (
+	"fmt"
+)
+
+// Three-way merge of trees done on objects only, modelled after the ort
+// strategy of git. Changes of theirs since the base are applied on top of
+// ours, following renames detected on both sides, and files changed on both
+// sides get their content merged. Directory renames are not detected.
+
+// MergeOptions controls three-way merges.
+type MergeOptions struct {
+	// DetectRenames follows files renamed on either side
+	DetectRenames bool
+	// RenameThreshold is minimal similarity score in percents,
+	// DefaultRenameThreshold if zero
+	RenameThreshold int
+	// Algorithm is the line diff used for content merges
+	Algorithm     DiffAlgorithm
+	ConflictStyle ConflictStyle
+	// Favor resolves conflicting hunks in favor of a side
+	Favor MergeFavor
+	// OurLabel, TheirLabel and BaseLabel name the sides in conflict markers
+	OurLabel   string
+	TheirLabel string
+	BaseLabel  string
+}
+
+// DefaultMergeOptions returns options merging the same way `git merge`
+// does by default.
+func DefaultMergeOptions() MergeOptions {
+	return MergeOptions{
+		DetectRenames: true,
+		Algorithm:     DIFF_ALGORITHM_HISTOGRAM,
+		OurLabel:      "ours",
+		TheirLabel:    "theirs",
+		BaseLabel:     "base",
+	}
+}
+
+// MergeConflictType is a kind of conflict found by a merge.
+type MergeConflictType int
+
+const (
+	MERGE_CONFLICT_CONTENT MergeConflictType = iota + 1
+	MERGE_CONFLICT_MODIFY_DELETE
+	MERGE_CONFLICT_RENAME_DELETE
+	MERGE_CONFLICT_RENAME_RENAME
+	MERGE_CONFLICT_ADD_ADD
+	MERGE_CONFLICT_DISTINCT_TYPES
+	MERGE_CONFLICT_DIRECTORY_FILE
+	MERGE_CONFLICT_SUBMODULE
+)
+
+// String returns name of the conflict, as `git merge` reports it.
+func (t MergeConflictType) String() string {
+	switch t {
+	case MERGE_CONFLICT_CONTENT:
+		return "content"
+	case MERGE_CONFLICT_MODIFY_DELETE:
+		return "modify/delete"
+	case MERGE_CONFLICT_RENAME_DELETE:
+		return "rename/delete"
+	case MERGE_CONFLICT_RENAME_RENAME:
+		return "rename/rename"
+	case MERGE_CONFLICT_ADD_ADD:
+		return "add/add"
+	case MERGE_CONFLICT_DISTINCT_TYPES:
+		return "distinct types"
+	case MERGE_CONFLICT_DIRECTORY_FILE:
+		return "file/directory"
+	case MERGE_CONFLICT_SUBMODULE:
+		return "submodule"
+	}
+	return "unknown"
+}
+
+// MergeConflict describes a path which could not be merged cleanly.
+type MergeConflict struct {
+	Type MergeConflictType
+	// Path is where the conflicting file is left in the merged tree
+	Path string
+	// BasePath, OurPath and TheirPath are paths of the file on each side,
+	// empty if the side has no such file
+	BasePath  string
+	OurPath   string
+	TheirPath string
+}
+
+// treeMerger applies changes of theirs to ours.
+type treeMerger struct {
+	repo *Repository
+	opts *MergeOptions
+	// depth is non-zero when merge bases are merged into a virtual one
+	depth  int
+	editor *treeEditor
+	// ourChanges are changes of ours keyed by path in the base
+	ourChanges map[string]*TreeChange
+	// contents of merged files which are not written yet
+	contents  map[sha1][]byte
+	conflicts []*MergeConflict
+}
+
+// mergeTrees merges trees and returns the merger holding the result, which
+// is not written yet. Conflicting files are left in the result with markers
+// and listed in conflicts. Zero id stands for an empty tree.
+func (repo *Repository) mergeTrees(base, ours, theirs sha1, opts *MergeOptions, depth int) (*treeMerger, error) {
+	m := &treeMerger{
+		repo:       repo,
+		opts:       opts,
+		depth:      depth,
+		ourChanges: map[string]*TreeChange{},
+		contents:   map[sha1][]byte{},
+	}
+
+	switch {
+	case ours == theirs || base == theirs:
+		m.editor = repo.newTreeEditor(ours)
+		return m, nil
+	case base == ours:
+		m.editor = repo.newTreeEditor(theirs)
+		return m, nil
+	}
+
+	ourChanges, err := repo.diffTrees(base, ours, DiffTreeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	theirChanges, err := repo.diffTrees(base, theirs, DiffTreeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DetectRenames {
+		renamedOurs, err := repo.mergeRenames(ourChanges, theirChanges, opts)
+		if err != nil {
+			return nil, err
+		}
+		if theirChanges, err = repo.mergeRenames(theirChanges, ourChanges, opts); err != nil {
+			return nil, err
+		}
+		ourChanges = renamedOurs
+	}
+
+	for _, change := range ourChanges {
+		if change.Type != CHANGE_ADDED {
+			m.ourChanges[change.OldPath] = change
+		}
+	}
+
+	m.editor = repo.newTreeEditor(ours)
+	for _, change := range theirChanges {
+		switch change.Type {
+		case CHANGE_ADDED:
+			err = m.add(change.NewPath, newEntry(change))
+		case CHANGE_DELETED:
+			err = m.deleted(change)
+		case CHANGE_RENAMED:
+			err = m.renamed(change)
+		default:
+			err = m.modified(change)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// mergeRenames detects renames among changes of a side. As git does, only
+// files deleted by the side which the other side changed or deleted too
+// are taken as sources of inexact renames, other renames do not affect the
+// result.
+func (repo *Repository) mergeRenames(changes, other []*TreeChange, opts *MergeOptions) ([]*TreeChange, error) {
+	touched := map[string]bool{}
+	for _, change := range other {
+		if change.Type != CHANGE_ADDED {
+			touched[change.OldPath] = true
+		}
+	}
+	added := map[sha1]bool{}
+	for _, change := range changes {
+		if change.Type == CHANGE_ADDED {
+			added[change.NewID] = true
+		}
+	}
+
+	candidates := make([]*TreeChange, 0, len(changes))
+	for _, change := range changes {
+		if change.Type != CHANGE_DELETED || touched[change.OldPath] || added[change.OldID] {
+			candidates = append(candidates, change)
+		}
+	}
+
+	renamed, err := repo.detectRenames(candidates, DiffTreeOptions{DetectRenames: true, RenameThreshold: opts.RenameThreshold})
+	if err != nil {
+		return nil, err
+	}
+
+	// keep order of changes, deletions used as rename sources are dropped
+	kept := make(map[*TreeChange]bool, len(renamed))
+	for _, change := range renamed {
+		kept[change] = true
+	}
+	result := make([]*TreeChange, 0, len(changes))
+	for _, change := range changes {
+		if kept[change] || change.Type == CHANGE_DELETED && !touched[change.OldPath] && !added[change.OldID] {
+			result = append(result, change)
+		}
+	}
+	return result, nil
+}
+
+func oldEntry(change *TreeChange) *pathEntry {
+	return &pathEntry{ID: change.OldID, Mode: change.OldMode}
+}
+
+func newEntry(change *TreeChange) *pathEntry {
+	return &pathEntry{ID: change.NewID, Mode: change.NewMode}
+}
+
+func (m *treeMerger) conflict(typ MergeConflictType, path, basePath, ourPath, theirPath string) {
+	m.conflicts = append(m.conflicts, &MergeConflict{
+		Type:      typ,
+		Path:      path,
+		BasePath:  basePath,
+		OurPath:   ourPath,
+		TheirPath: theirPath,
+	})
+}
+
+// modified handles a file changed by theirs in place.
+func (m *treeMerger) modified(change *TreeChange) error {
+	relpath := change.OldPath
+	our := m.ourChanges[relpath]
+	switch {
+	case our == nil:
+		_, err := m.place(relpath, newEntry(change))
+		return err
+
+	case our.Type == CHANGE_DELETED:
+		// the modified version is kept for resolution
+		placed, err := m.place(relpath, m.survivor(change, newEntry(change)))
+		if err != nil {
+			return err
+		}
+		m.conflict(MERGE_CONFLICT_MODIFY_DELETE, placed, relpath, "", relpath)
+		return nil
+	}
+
+	return m.mergeFile(our.NewPath, oldEntry(change), newEntry(our), newEntry(change),
+		[3]string{relpath, our.NewPath, relpath}, 0)
+}
+
+// deleted handles a file removed by theirs.
+func (m *treeMerger) deleted(change *TreeChange) error {
+	relpath := change.OldPath
+	our := m.ourChanges[relpath]
+	switch {
+	case our == nil:
+		return m.editor.remove(relpath)
+	case our.Type == CHANGE_DELETED:
+		return nil
+	case our.Type == CHANGE_RENAMED:
+		m.conflict(MERGE_CONFLICT_RENAME_DELETE, our.NewPath, relpath, our.NewPath, "")
+	default:
+		m.conflict(MERGE_CONFLICT_MODIFY_DELETE, relpath, relpath, relpath, "")
+	}
+	if m.depth > 0 {
+		return m.setBlob(our.NewPath, oldEntry(change))
+	}
+	return nil
+}
+
+// survivor returns version of a file deleted on one side kept for
+// resolution. Merges of merge bases keep the base version.
+func (m *treeMerger) survivor(change *TreeChange, kept *pathEntry) *pathEntry {
+	if m.depth > 0 {
+		return oldEntry(change)
+	}
+	return kept
+}
+
+// renamed handles a file moved by theirs.
+func (m *treeMerger) renamed(change *TreeChange) error {
+	oldPath, newPath := change.OldPath, change.NewPath
+	our := m.ourChanges[oldPath]
+	switch {
+	case our == nil:
+		if err := m.editor.remove(oldPath); err != nil {
+			return err
+		}
+		return m.add(newPath, newEntry(change))
+
+	case our.Type == CHANGE_DELETED:
+		placed, err := m.place(newPath, m.survivor(change, newEntry(change)))
+		if err != nil {
+			return err
+		}
+		m.conflict(MERGE_CONFLICT_RENAME_DELETE, placed, oldPath, "", newPath)
+		return nil
+
+	case our.Type == CHANGE_RENAMED && our.NewPath != newPath:
+		return m.renamedTwice(oldEntry(change), our, change)
+	}
+
+	paths := [3]string{oldPath, our.NewPath, newPath}
+	if our.Type == CHANGE_RENAMED {
+		return m.mergeFile(newPath, oldEntry(change), newEntry(our), newEntry(change), paths, 0)
+	}
+
+	if err := m.editor.remove(oldPath); err != nil {
+		return err
+	}
+	existing, err := m.editor.get(newPath)
+	if err != nil {
+		return err
+	}
+	if existing == nil || existing.isDir() {
+		return m.placeMerged(newPath, oldEntry(change), newEntry(our), newEntry(change), paths)
+	}
+
+	// ours added a file where theirs renamed one, the renamed file is
+	// merged first and the result is merged with the added one, which may
+	// nest conflict markers
+	result, err := m.mergeEntries(oldEntry(change), newEntry(our), newEntry(change), paths, 1)
+	if err != nil {
+		return err
+	}
+	if result.conflict != 0 {
+		m.conflict(result.conflict, newPath, oldPath, oldPath, newPath)
+	}
+	if result.data != nil {
+		m.contents[result.ID] = result.data
+	}
+	return m.mergeFile(newPath, nil, &existing.pathEntry, &result.pathEntry, [3]string{newPath, newPath, newPath}, 0)
+}
+
+// renamedTwice handles a file moved to different paths by both sides. As
+// git does, content of both sides is merged and left at both paths.
+func (m *treeMerger) renamedTwice(base *pathEntry, our, their *TreeChange) error {
+	paths := [3]string{their.OldPath, our.NewPath, their.NewPath}
+	result, err := m.mergeEntries(base, newEntry(our), newEntry(their), paths, 1)
+	if err != nil {
+		return err
+	}
+
+	if err = m.setMerged(our.NewPath, result); err != nil {
+		return err
+	}
+	if err = m.editor.remove(their.OldPath); err != nil {
+		return err
+	}
+
+	placed, err := m.place(their.NewPath, &result.pathEntry)
+	if err != nil {
+		return err
+	}
+	m.conflict(MERGE_CONFLICT_RENAME_RENAME, placed, their.OldPath, our.NewPath, their.NewPath)
+	return nil
+}
+
+// add puts a file new to theirs at relpath, merging it with a file ours
+// have there.
+func (m *treeMerger) add(relpath string, entry *pathEntry) error {
+	existing, err := m.editor.get(relpath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case existing == nil || existing.isDir():
+		_, err = m.place(relpath, entry)
+		return err
+	case sameEntry(&existing.pathEntry, entry):
+		return nil
+	}
+
+	return m.mergeFile(relpath, nil, &existing.pathEntry, entry, [3]string{relpath, relpath, relpath}, 0)
+}
+
+// place puts a file of theirs at relpath. If a directory of ours is there,
+// the file goes to "path~theirs" instead, and files of ours standing in way
+// of its directories are moved to "path~ours". It returns the final path.
+func (m *treeMerger) place(relpath string, entry *pathEntry) (string, error) {
+	dirs, _ := splitPath(relpath)
+	for idx := range dirs {
+		prefix := joinPath(dirs[:idx+1])
+		existing, err := m.editor.get(prefix)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			break
+		}
+		if existing.isDir() {
+			continue
+		}
+
+		aside, err := m.asidePath(prefix, m.opts.OurLabel)
+		if err != nil {
+			return "", err
+		}
+		if err = m.editor.remove(prefix); err != nil {
+			return "", err
+		}
+		if err = m.setEntry(aside, existing); err != nil {
+			return "", err
+		}
+		m.conflict(MERGE_CONFLICT_DIRECTORY_FILE, aside, "", prefix, "")
+		break
+	}
+
+	existing, err := m.editor.get(relpath)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil && existing.isDir() {
+		aside, err := m.asidePath(relpath, m.opts.TheirLabel)
+		if err != nil {
+			return "", err
+		}
+		m.conflict(MERGE_CONFLICT_DIRECTORY_FILE, aside, "", "", relpath)
+		relpath = aside
+	}
+
+	return relpath, m.setBlob(relpath, entry)
+}
+
+func joinPath(names []string) string {
+	relpath := names[0]
+	for _, name := range names[1:] {
+		relpath += "/" + name
+	}
+	return relpath
+}
+
+// asidePath returns unused path for a file moved out of the way.
+func (m *treeMerger) asidePath(relpath, label string) (string, error) {
+	aside := relpath + "~" + label
+	for idx := 0; ; idx++ {
+		existing, err := m.editor.get(aside)
+		if err != nil || existing == nil {
+			return aside, err
+		}
+		aside = fmt.Sprintf("%s~%s_%d", relpath, label, idx)
+	}
+}
+
+func (m *treeMerger) setEntry(relpath string, entry *editEntry) error {
+	if entry.data != nil {
+		return m.editor.setContent(relpath, entry.data, entry.Mode)
+	}
+	return m.editor.set(relpath, entry.ID, entry.Mode)
+}
+
+// mergedFile is the result of merging a single file.
+type mergedFile struct {
+	pathEntry
+	// data is merged content, nil if the result is an existing object
+	data     []byte
+	conflict MergeConflictType
+}
+
+// mergeFile merges versions of a file and stores the result at relpath,
+// base is nil for files added on both sides. Paths are those of base, ours
+// and theirs, they name the sides in conflict markers if they differ.
+func (m *treeMerger) mergeFile(relpath string, base, ours, theirs *pathEntry, paths [3]string, extraMarker int) error {
+	result, err := m.mergeEntries(base, ours, theirs, paths, extraMarker)
+	if err != nil {
+		return err
+	}
+
+	if err = m.setMerged(relpath, result); err != nil {
+		return err
+	}
+	return m.recordMerged(relpath, base, theirs, result, paths)
+}
+
+// placeMerged merges versions of a file of ours moved by theirs to relpath,
+// where ours may have a directory.
+func (m *treeMerger) placeMerged(relpath string, base, ours, theirs *pathEntry, paths [3]string) error {
+	result, err := m.mergeEntries(base, ours, theirs, paths, 0)
+	if err != nil {
+		return err
+	}
+
+	if result.data != nil {
+		m.contents[result.ID] = result.data
+	}
+	if relpath, err = m.place(relpath, &result.pathEntry); err != nil {
+		return err
+	}
+	return m.recordMerged(relpath, base, theirs, result, paths)
+}
+
+// recordMerged records conflict of a file merged to relpath.
+func (m *treeMerger) recordMerged(relpath string, base, theirs *pathEntry, result *mergedFile, paths [3]string) error {
+	switch {
+	case result.conflict == 0:
+		return nil
+	case result.conflict == MERGE_CONFLICT_DISTINCT_TYPES:
+		// both versions are kept, theirs aside
+		aside, err := m.asidePath(relpath, m.opts.TheirLabel)
+		if err != nil {
+			return err
+		}
+		if err = m.editor.set(aside, theirs.ID, theirs.Mode); err != nil {
+			return err
+		}
+	case result.conflict == MERGE_CONFLICT_CONTENT && base == nil:
+		result.conflict = MERGE_CONFLICT_ADD_ADD
+	}
+
+	basePath := paths[0]
+	if base == nil {
+		basePath = ""
+	}
+	m.conflict(result.conflict, relpath, basePath, paths[1], paths[2])
+	return nil
+}
+
+func (m *treeMerger) setMerged(relpath string, result *mergedFile) error {
+	if result.data != nil {
+		m.contents[result.ID] = result.data
+	}
+	return m.setBlob(relpath, &result.pathEntry)
+}
+
+// setBlob puts a file at relpath, content merged but not written is written
+// with the tree.
+func (m *treeMerger) setBlob(relpath string, entry *pathEntry) error {
+	if data, ok := m.contents[entry.ID]; ok {
+		return m.editor.setContent(relpath, data, entry.Mode)
+	}
+	return m.editor.set(relpath, entry.ID, entry.Mode)
+}
+
+// content reads blob content, including content merged but not written.
+func (m *treeMerger) content(entry *pathEntry) ([]byte, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	if data, ok := m.contents[entry.ID]; ok {
+		return data, nil
+	}
+	return m.repo.readBlob(entry.ID)
+}
+
+// mergeEntries computes merged version of a file.
+func (m *treeMerger) mergeEntries(base, ours, theirs *pathEntry, paths [3]string, extraMarker int) (*mergedFile, error) {
+	switch {
+	case sameEntry(ours, theirs) || sameEntry(base, theirs):
+		return &mergedFile{pathEntry: *ours}, nil
+	case sameEntry(base, ours):
+		return &mergedFile{pathEntry: *theirs}, nil
+	}
+
+	// versions which cannot be merged keep ours, or the base in merges of
+	// merge bases
+	unmerged := &mergedFile{pathEntry: *ours, conflict: MERGE_CONFLICT_CONTENT}
+	if m.depth > 0 && base != nil {
+		unmerged.pathEntry = *base
+	}
+
+	if entryKind(ours.Mode) != entryKind(theirs.Mode) {
+		unmerged.pathEntry = *ours
+		unmerged.conflict = MERGE_CONFLICT_DISTINCT_TYPES
+		return unmerged, nil
+	}
+
+	mode := ours.Mode
+	if base != nil && ours.Mode == base.Mode {
+		mode = theirs.Mode
+	}
+	modeConflict := ours.Mode != theirs.Mode && (base == nil || ours.Mode != base.Mode && theirs.Mode != base.Mode)
+
+	result := &mergedFile{pathEntry: pathEntry{ID: ours.ID, Mode: mode}}
+	if modeConflict {
+		result.conflict = MERGE_CONFLICT_CONTENT
+	}
+	if ours.ID == theirs.ID {
+		return result, nil
+	}
+	if base != nil && base.ID == ours.ID {
+		result.ID = theirs.ID
+		return result, nil
+	}
+	if base != nil && base.ID == theirs.ID {
+		return result, nil
+	}
+
+	switch ours.Mode {
+	case ENTRY_MODE_COMMIT:
+		unmerged.conflict = MERGE_CONFLICT_SUBMODULE
+		return unmerged, nil
+	case ENTRY_MODE_SYMLINK:
+		return unmerged, nil
+	}
+
+	baseData, err := m.content(base)
+	if err != nil {
+		return nil, err
+	}
+	ourData, err := m.content(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirData, err := m.content(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBinary(baseData) || isBinary(ourData) || isBinary(theirData) {
+		unmerged.Mode = mode
+		return unmerged, nil
+	}
+
+	data, conflicts := mergeContent(baseData, ourData, theirData, m.fileOptions(paths, extraMarker))
+	result.ID = hashObject(OBJECT_BLOB, data)
+	result.data = data
+	if conflicts > 0 {
+		result.conflict = MERGE_CONFLICT_CONTENT
+	}
+	return result, nil
+}
+
+// fileOptions returns options of a content merge, sides are labelled with
+// paths too if the file was renamed.
+func (m *treeMerger) fileOptions(paths [3]string, extraMarker int) *mergeFileOptions {
+	opts := &mergeFileOptions{
+		lineOpts:   LineDiffOptions{Algorithm: m.opts.Algorithm},
+		style:      m.opts.ConflictStyle,
+		favor:      m.opts.Favor,
+		markerSize: defaultMarkerSize + extraMarker + 2*m.depth,
+		baseLabel:  m.opts.BaseLabel,
+		ourLabel:   m.opts.OurLabel,
+		theirLabel: m.opts.TheirLabel,
+	}
+
+	if paths[0] != paths[1] || paths[0] != paths[2] {
+		opts.baseLabel += ":" + paths[0]
+		opts.ourLabel += ":" + paths[1]
+		opts.theirLabel += ":" + paths[2]
+	}
+	return opts
+}
+
+// virtualBase returns tree of merge bases. Several of them are merged into
+// a virtual one oldest first, the way the recursive strategy does, the
+// result is kept in memory.
+func (repo *Repository) virtualBase(bases []sha1, opts *MergeOptions, depth int) (sha1, error) {
+	merged := []sha1{bases[len(bases)-1]}
+	node, err := repo.loadWalkNode(merged[0])
+	if err != nil {
+		return sha1{}, err
+	}
+	tree := node.tree
+
+	for idx := len(bases) - 2; idx >= 0; idx-- {
+		next, err := repo.loadWalkNode(bases[idx])
+		if err != nil {
+			return sha1{}, err
+		}
+
+		innerBases, err := repo.mergeBases(next.id, merged)
+		if err != nil {
+			return sha1{}, err
+		}
+
+		inner := *opts
+		inner.Favor = MERGE_FAVOR_NONE
+		inner.OurLabel = "Temporary merge branch 1"
+		inner.TheirLabel = "Temporary merge branch 2"
+		inner.BaseLabel = "merged common ancestors"
+
+		var innerBase sha1
+		if len(innerBases) == 1 {
+			inner.BaseLabel = innerBases[0].String()
+		}
+		if len(innerBases) > 0 {
+			if innerBase, err = repo.virtualBase(innerBases, opts, depth+1); err != nil {
+				return sha1{}, err
+			}
+		}
+
+		m, err := repo.mergeTrees(innerBase, tree, next.tree, &inner, depth+1)
+		if err != nil {
+			return sha1{}, err
+		}
+		if tree, err = m.editor.write(repo.overlay); err != nil {
+			return sha1{}, err
+		}
+		merged = append(merged, next.id)
+	}
+
+	return tree, nil
+}
+
+// mergeCommits merges commit theirs into ours.
+func (repo *Repository) mergeCommits(ours, theirs sha1, opts *MergeOptions) (*treeMerger, error) {
+	bases, err := repo.mergeBases(ours, []sha1{theirs})
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, ErrNoMergeBase{Revisions: []string{ours.String(), theirs.String()}}
+	}
+	return repo.mergeOnBases(bases, ours, theirs, opts)
+}
+
+// mergeOnBases merges commit theirs into ours having merge bases found
+// already. The returned merger reads through an in-memory overlay, where
+// virtual merge bases are kept.
+func (repo *Repository) mergeOnBases(bases []sha1, ours, theirs sha1, opts *MergeOptions) (*treeMerger, error) {
+	view := repo.withOverlay(newMemoryObjects())
+	base, err := view.virtualBase(bases, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ourNode, err := view.loadWalkNode(ours)
+	if err != nil {
+		return nil, err
+	}
+
+	theirNode, err := view.loadWalkNode(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return view.mergeTrees(base, ourNode.tree, theirNode.tree, opts, 0)
+}
+
+// MergeTrees merges changes made from base to theirs into ours and returns
+// id of the merged tree. Nil tree stands for an empty one. If the merge has
+// conflicts, nothing is written and ErrMergeConflict lists them.
+func (repo *Repository) MergeTrees(base, ours, theirs *Tree, opts MergeOptions) (sha1, error) {
+	var ids [3]sha1
+	for idx, tree := range []*Tree{base, ours, theirs} {
+		if tree != nil {
+			ids[idx] = tree.ID
+		}
+	}
+
+	m, err := repo.withOverlay(newMemoryObjects()).mergeTrees(ids[0], ids[1], ids[2], &opts, 0)
+	if err != nil {
+		return sha1{}, err
+	}
+	if len(m.conflicts) > 0 {
+		return sha1{}, ErrMergeConflict{Conflicts: m.conflicts}
+	}
+
+	return m.editor.write(repo)
+}
+
+// MergeCommits merges revision theirs into ours and writes a merge commit
+// with both of them as parents. Several merge bases are merged into a
+// virtual one first. Nil committer means the author. If the merge has
+// conflicts, nothing is written and ErrMergeConflict lists them.
+func (repo *Repository) MergeCommits(ours, theirs, message string, author, committer *Signature, opts MergeOptions) (sha1, error) {
+	ourID, err := repo.resolveRevision(ours)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	theirID, err := repo.resolveRevision(theirs)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	m, err := repo.mergeCommits(ourID, theirID, &opts)
+	if err != nil {
+		return sha1{}, err
+	}
+	if len(m.conflicts) > 0 {
+		return sha1{}, ErrMergeConflict{Conflicts: m.conflicts}
+	}
+
+	tree, err := m.editor.write(repo)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	return writeCommit(repo, tree, []sha1{ourID, theirID}, author, committer, message)
+}
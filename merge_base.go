@@ -0,0 +1,367 @@
+package git
+
+import (
+	"container/heap"
+)
+
+// Native merge base computation, a port of paint_down_to_common of git:
+// commits reachable from both sides are painted with both colors, the
+// first ones found are merge base candidates, and their ancestors are
+// marked stale so the walk stops once only stale commits are left.
+
+const (
+	paintParent1 = 1 << iota
+	paintParent2
+	paintStale
+	paintResult
+)
+
+type paintNode struct {
+	*walkNode
+	flags int
+}
+
+type paintItem struct {
+	node *paintNode
+	seq  int
+}
+
+// paintQueue orders commits by generation number, then by commit date,
+// newest first. Commits without generation number go first, as git treats
+// them as having infinite generation. Equal commits keep insertion order.
+type paintQueue []paintItem
+
+func (q paintQueue) Len() int { return len(q) }
+func (q paintQueue) Less(i, j int) bool {
+	gi, gj := paintGeneration(q[i].node.walkNode), paintGeneration(q[j].node.walkNode)
+	switch {
+	case gi != gj:
+		return gi > gj
+	case q[i].node.when != q[j].node.when:
+		return q[i].node.when > q[j].node.when
+	}
+	return q[i].seq < q[j].seq
+}
+func (q paintQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *paintQueue) Push(x interface{}) { *q = append(*q, x.(paintItem)) }
+func (q *paintQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func paintGeneration(node *walkNode) uint64 {
+	if node.generation == 0 {
+		return 1<<32 - 1
+	}
+	return uint64(node.generation)
+}
+
+func (q paintQueue) hasNonStale() bool {
+	for _, item := range q {
+		if item.node.flags&paintStale == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type painter struct {
+	repo  *Repository
+	nodes map[sha1]*paintNode
+}
+
+func newPainter(repo *Repository) *painter {
+	return &painter{repo: repo, nodes: map[sha1]*paintNode{}}
+}
+
+func (p *painter) node(id sha1) (*paintNode, error) {
+	if node, ok := p.nodes[id]; ok {
+		return node, nil
+	}
+
+	walkNode, err := p.repo.loadWalkNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &paintNode{walkNode: walkNode}
+	p.nodes[id] = node
+	return node, nil
+}
+
+// paintDownToCommon returns common ancestors of one and twos which are not
+// reachable from each other through painted commits. Commits with
+// generation below minGeneration are not walked.
+func (p *painter) paintDownToCommon(one sha1, twos []sha1, minGeneration uint64) ([]*paintNode, error) {
+	queue := paintQueue{}
+	seq := 0
+	push := func(node *paintNode) {
+		heap.Push(&queue, paintItem{node: node, seq: seq})
+		seq++
+	}
+
+	start, err := p.node(one)
+	if err != nil {
+		return nil, err
+	}
+	start.flags |= paintParent1
+	push(start)
+
+	for _, id := range twos {
+		node, err := p.node(id)
+		if err != nil {
+			return nil, err
+		}
+		node.flags |= paintParent2
+		push(node)
+	}
+
+	result := []*paintNode{}
+	for queue.hasNonStale() {
+		node := heap.Pop(&queue).(paintItem).node
+		if paintGeneration(node.walkNode) < minGeneration {
+			break
+		}
+
+		flags := node.flags & (paintParent1 | paintParent2 | paintStale)
+		if flags == paintParent1|paintParent2 {
+			if node.flags&paintResult == 0 {
+				node.flags |= paintResult
+				result = append(result, node)
+			}
+			// parents of a merge base are not interesting
+			flags |= paintStale
+		}
+
+		for _, id := range node.parents {
+			parent, err := p.node(id)
+			if err != nil {
+				return nil, err
+			}
+			if parent.flags&flags == flags {
+				continue
+			}
+			parent.flags |= flags
+			push(parent)
+		}
+	}
+
+	return result, nil
+}
+
+// mergeBases returns best common ancestors of one and all of twos, newest
+// first.
+func (repo *Repository) mergeBases(one sha1, twos []sha1) ([]sha1, error) {
+	for _, two := range twos {
+		if one == two {
+			return []sha1{one}, nil
+		}
+	}
+
+	p := newPainter(repo)
+	found, err := p.paintDownToCommon(one, twos, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*paintNode{}
+	for _, node := range found {
+		if node.flags&paintStale == 0 {
+			candidates = append(candidates, node)
+		}
+	}
+	sortPaintByDate(candidates)
+
+	if len(candidates) < 2 {
+		return paintIDs(candidates), nil
+	}
+	return repo.removeRedundant(paintIDs(candidates))
+}
+
+// sortPaintByDate orders nodes newest first, keeping order of equal dates.
+func sortPaintByDate(nodes []*paintNode) {
+	for i := 1; i < len(nodes); i++ {
+		for j := i; j > 0 && nodes[j].when > nodes[j-1].when; j-- {
+			nodes[j], nodes[j-1] = nodes[j-1], nodes[j]
+		}
+	}
+}
+
+func paintIDs(nodes []*paintNode) []sha1 {
+	ids := make([]sha1, len(nodes))
+	for idx, node := range nodes {
+		ids[idx] = node.id
+	}
+	return ids
+}
+
+// removeRedundant drops duplicates and commits which are ancestors of other
+// commits of the list, order of the rest is kept.
+func (repo *Repository) removeRedundant(ids []sha1) ([]sha1, error) {
+	seen := map[sha1]bool{}
+	unique := make([]sha1, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	redundant := make([]bool, len(unique))
+	for i := range unique {
+		for j := range unique {
+			if i == j || redundant[j] {
+				continue
+			}
+
+			ancestor, err := repo.isAncestor(unique[i], unique[j])
+			if err != nil {
+				return nil, err
+			}
+			if ancestor {
+				redundant[i] = true
+				break
+			}
+		}
+	}
+
+	result := make([]sha1, 0, len(unique))
+	for idx, id := range unique {
+		if !redundant[idx] {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// isAncestor reports whether a is reachable from b. Commits with generation
+// numbers lower than the one of a are not walked.
+func (repo *Repository) isAncestor(a, b sha1) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+
+	p := newPainter(repo)
+	start, err := p.node(a)
+	if err != nil {
+		return false, err
+	}
+
+	target, err := p.node(b)
+	if err != nil {
+		return false, err
+	}
+
+	minGeneration := paintGeneration(start.walkNode)
+	if minGeneration > paintGeneration(target.walkNode) {
+		return false, nil
+	}
+	if start.generation == 0 {
+		minGeneration = 0
+	}
+
+	if _, err = p.paintDownToCommon(a, []sha1{b}, minGeneration); err != nil {
+		return false, err
+	}
+	return start.flags&paintParent2 != 0, nil
+}
+
+// octopusMergeBases returns merge bases of all commits, as
+// `git merge-base --octopus --all` does.
+func (repo *Repository) octopusMergeBases(ids []sha1) ([]sha1, error) {
+	if len(ids) == 0 {
+		return []sha1{}, nil
+	}
+
+	result := []sha1{ids[0]}
+	for _, id := range ids[1:] {
+		next := []sha1{}
+		for _, base := range result {
+			bases, err := repo.mergeBases(id, []sha1{base})
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, bases...)
+		}
+		result = next
+	}
+
+	return repo.removeRedundant(result)
+}
+
+// GetMergeBase checks and returns merge base of two branches. If there are
+// several best common ancestors, the newest one is returned.
+func (repo *Repository) GetMergeBase(base, head string) (string, error) {
+	bases, err := repo.MergeBases(base, head)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) == 0 {
+		return "", ErrNoMergeBase{Revisions: []string{base, head}}
+	}
+	return bases[0], nil
+}
+
+// MergeBases returns all best common ancestors of two revisions, newest
+// first. There are several of them in criss-cross histories.
+func (repo *Repository) MergeBases(base, head string) ([]string, error) {
+	baseID, err := repo.resolveRevision(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headID, err := repo.resolveRevision(head)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := repo.mergeBases(baseID, []sha1{headID})
+	if err != nil {
+		return nil, err
+	}
+	return idStrings(bases), nil
+}
+
+// MergeBaseOctopus returns best common ancestors of all revisions, as needed
+// for an octopus merge of them.
+func (repo *Repository) MergeBaseOctopus(revisions ...string) ([]string, error) {
+	ids := make([]sha1, len(revisions))
+	for idx, revision := range revisions {
+		var err error
+		if ids[idx], err = repo.resolveRevision(revision); err != nil {
+			return nil, err
+		}
+	}
+
+	bases, err := repo.octopusMergeBases(ids)
+	if err != nil {
+		return nil, err
+	}
+	return idStrings(bases), nil
+}
+
+// IsAncestor reports whether revision a is reachable from revision b, so b
+// can be fast-forwarded to from a. A commit is an ancestor of itself.
+func (repo *Repository) IsAncestor(a, b string) (bool, error) {
+	aID, err := repo.resolveRevision(a)
+	if err != nil {
+		return false, err
+	}
+
+	bID, err := repo.resolveRevision(b)
+	if err != nil {
+		return false, err
+	}
+
+	return repo.isAncestor(aID, bID)
+}
+
+func idStrings(ids []sha1) []string {
+	result := make([]string, len(ids))
+	for idx, id := range ids {
+		result[idx] = id.String()
+	}
+	return result
+}
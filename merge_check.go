@@ -0,0 +1,123 @@
+package git
+
+import (
+	"path"
+)
+
+// MergeStatus tells how head can be merged into base.
+type MergeStatus int
+
+const (
+	// MERGE_STATUS_UP_TO_DATE means head is merged into base already
+	MERGE_STATUS_UP_TO_DATE MergeStatus = iota + 1
+	// MERGE_STATUS_FAST_FORWARD means base is an ancestor of head
+	MERGE_STATUS_FAST_FORWARD
+	// MERGE_STATUS_CLEAN means a merge commit can be made without conflicts
+	MERGE_STATUS_CLEAN
+	// MERGE_STATUS_CONFLICT means the merge has conflicts
+	MERGE_STATUS_CONFLICT
+)
+
+// MergeCheck is the result of a mergeability check.
+type MergeCheck struct {
+	Status MergeStatus
+	// Conflicts are found conflicts if the status is MERGE_STATUS_CONFLICT
+	Conflicts []*MergeConflict
+}
+
+// CheckMergeable tells whether head can be merged into base cleanly with
+// default merge options. Cheap checks go first: ancestry, then whether the
+// sides changed disjoint paths since their merge base, and only then the
+// merge itself is done in memory. Nothing is written to the repository.
+func (repo *Repository) CheckMergeable(base, head string) (*MergeCheck, error) {
+	baseID, err := repo.resolveRevision(base)
+	if err != nil {
+		return nil, err
+	}
+
+	headID, err := repo.resolveRevision(head)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := repo.mergeBases(baseID, []sha1{headID})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(bases) == 0:
+		return nil, ErrNoMergeBase{Revisions: []string{base, head}}
+	case bases[0] == headID:
+		return &MergeCheck{Status: MERGE_STATUS_UP_TO_DATE}, nil
+	case bases[0] == baseID:
+		return &MergeCheck{Status: MERGE_STATUS_FAST_FORWARD}, nil
+	}
+
+	if len(bases) == 1 {
+		disjoint, err := repo.disjointChanges(bases[0], baseID, headID)
+		if err != nil {
+			return nil, err
+		}
+		if disjoint {
+			return &MergeCheck{Status: MERGE_STATUS_CLEAN}, nil
+		}
+	}
+
+	opts := DefaultMergeOptions()
+	m, err := repo.mergeOnBases(bases, baseID, headID, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.conflicts) > 0 {
+		return &MergeCheck{Status: MERGE_STATUS_CONFLICT, Conflicts: m.conflicts}, nil
+	}
+	return &MergeCheck{Status: MERGE_STATUS_CLEAN}, nil
+}
+
+// disjointChanges tells whether ours and theirs changed different paths
+// since the base, where a path does not lie in a directory of the other
+// side either. Such changes always merge cleanly.
+func (repo *Repository) disjointChanges(base, ours, theirs sha1) (bool, error) {
+	trees := [3]sha1{}
+	for idx, id := range []sha1{base, ours, theirs} {
+		node, err := repo.loadWalkNode(id)
+		if err != nil {
+			return false, err
+		}
+		trees[idx] = node.tree
+	}
+
+	ourChanges, err := repo.diffTrees(trees[0], trees[1], DiffTreeOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	theirChanges, err := repo.diffTrees(trees[0], trees[2], DiffTreeOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	files := make(map[string]bool, len(ourChanges))
+	dirs := map[string]bool{}
+	for _, change := range ourChanges {
+		relpath := change.Path()
+		files[relpath] = true
+		for dir := path.Dir(relpath); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	for _, change := range theirChanges {
+		relpath := change.Path()
+		if files[relpath] || dirs[relpath] {
+			return false, nil
+		}
+		for dir := path.Dir(relpath); dir != "."; dir = path.Dir(dir) {
+			if files[dir] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// objectFiles lists files of the objects directory.
+func objectFiles(t *testing.T, repo *Repository) []string {
+	files := []string{}
+	err := filepath.Walk(repo.objectsDir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCheckMergeable(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testCommitFiles(t, repo, map[string]string{"a": "1\n2\n3\n4\n5\n", "dir/b": "b\n"}, 100)
+	ours := testCommitFiles(t, repo, map[string]string{"a": "one\n2\n3\n4\n5\n", "dir/b": "b\n"}, 200, base)
+	// same file as ours, different lines
+	clean := testCommitFiles(t, repo, map[string]string{"a": "1\n2\n3\n4\nfive\n", "dir/b": "b\n"}, 300, base)
+	conflict := testCommitFiles(t, repo, map[string]string{"a": "uno\n2\n3\n4\n5\n", "dir/b": "b\n"}, 400, base)
+	// different files than ours
+	disjoint := testCommitFiles(t, repo, map[string]string{"a": "1\n2\n3\n4\n5\n", "dir/b": "bee\n", "c": "c\n"}, 500, base)
+	// file against directory of the same name
+	fileDir := testCommitFiles(t, repo, map[string]string{"a": "1\n2\n3\n4\n5\n", "dir": "file\n"}, 600, base)
+	dirFile := testCommitFiles(t, repo, map[string]string{"a": "1\n2\n3\n4\n5\n", "dir/b": "b\n", "dir/c": "c\n"}, 700, base)
+	merged := testCommitFiles(t, repo, map[string]string{"a": "one\n2\n3\n4\nfive\n", "dir/b": "b\n"}, 800, ours, clean)
+
+	for _, test := range []struct {
+		name         string
+		base, head   sha1
+		status       MergeStatus
+		conflictType MergeConflictType
+	}{
+		{"up to date", merged, clean, MERGE_STATUS_UP_TO_DATE, 0},
+		{"fast forward", ours, merged, MERGE_STATUS_FAST_FORWARD, 0},
+		{"clean", ours, clean, MERGE_STATUS_CLEAN, 0},
+		{"conflict", ours, conflict, MERGE_STATUS_CONFLICT, MERGE_CONFLICT_CONTENT},
+		{"disjoint", ours, disjoint, MERGE_STATUS_CLEAN, 0},
+		{"file and directory", fileDir, dirFile, MERGE_STATUS_CONFLICT, MERGE_CONFLICT_DIRECTORY_FILE},
+	} {
+		before := objectFiles(t, repo)
+
+		check, err := repo.CheckMergeable(test.base.String(), test.head.String())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if check.Status != test.status {
+			t.Errorf("%s: got status %v, want %v", test.name, check.Status, test.status)
+		}
+		if test.conflictType != 0 && (len(check.Conflicts) != 1 || check.Conflicts[0].Type != test.conflictType) {
+			t.Errorf("%s: got %d conflicts, want one %v", test.name, len(check.Conflicts), test.conflictType)
+		}
+
+		if after := objectFiles(t, repo); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: objects changed from %v to %v", test.name, before, after)
+		}
+	}
+
+	// the cheap check must not take a file and a directory for disjoint
+	for _, test := range []struct {
+		ours, theirs sha1
+		want         bool
+	}{
+		{ours, disjoint, true},
+		{ours, clean, false},
+		{fileDir, dirFile, false},
+		{dirFile, fileDir, false},
+	} {
+		got, err := repo.disjointChanges(base, test.ours, test.theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("disjointChanges(%v, %v) = %v, want %v", test.ours, test.theirs, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package git
+
+import (
+	"bytes"
+)
+
+// Three-way content merge, a port of xdl_merge of git at the level used by
+// `git merge`: changes of both sides are diffed against the base, changes
+// touching the same lines are conflicts, and conflicts are then narrowed
+// down to lines which really differ between the sides.
+
+// ConflictStyle selects how conflicting hunks are written.
+type ConflictStyle int
+
+const (
+	// CONFLICT_STYLE_MERGE shows both sides of a conflict
+	CONFLICT_STYLE_MERGE ConflictStyle = iota
+	// CONFLICT_STYLE_DIFF3 shows the base version between the sides too
+	CONFLICT_STYLE_DIFF3
+)
+
+// MergeFavor resolves conflicting hunks without conflict markers.
+type MergeFavor int
+
+const (
+	MERGE_FAVOR_NONE MergeFavor = iota
+	MERGE_FAVOR_OURS
+	MERGE_FAVOR_THEIRS
+	// MERGE_FAVOR_UNION takes lines of both sides, ours first
+	MERGE_FAVOR_UNION
+)
+
+const defaultMarkerSize = 7
+
+// mergeChunk modes, ours and theirs can be combined
+const (
+	chunkConflict = 0
+	chunkOurs     = 1
+	chunkTheirs   = 2
+	// chunkSame is a change made the same way on both sides
+	chunkSame = 4
+)
+
+// mergeChunk is a region changed on one or both sides. Indexes are line
+// numbers in base (0), ours (1) and theirs (2).
+type mergeChunk struct {
+	mode     int
+	i0, chg0 int
+	i1, chg1 int
+	i2, chg2 int
+}
+
+// mergeFileOptions are parameters of a single content merge.
+type mergeFileOptions struct {
+	lineOpts   LineDiffOptions
+	style      ConflictStyle
+	favor      MergeFavor
+	markerSize int
+	ourLabel   string
+	theirLabel string
+	baseLabel  string
+}
+
+// contentMerger keeps lines of all three versions.
+type contentMerger struct {
+	base, ours, theirs [][]byte
+	opts               *mergeFileOptions
+}
+
+// mergeContent merges changes of ours and theirs made to base and returns
+// the result and number of conflicting hunks written with markers.
+func mergeContent(base, ours, theirs []byte, opts *mergeFileOptions) ([]byte, int) {
+	m := &contentMerger{
+		base:   splitLines(base),
+		ours:   splitLines(ours),
+		theirs: splitLines(theirs),
+		opts:   opts,
+	}
+
+	ourChanges := m.diff(m.base, m.ours)
+	if len(ourChanges) == 0 {
+		return theirs, 0
+	}
+	theirChanges := m.diff(m.base, m.theirs)
+	if len(theirChanges) == 0 {
+		return ours, 0
+	}
+
+	chunks := m.combine(ourChanges, theirChanges)
+	if opts.style != CONFLICT_STYLE_DIFF3 {
+		// the base is not shown, so conflicts can be narrowed down to lines
+		// differing between the sides
+		chunks = m.refineConflicts(chunks)
+		chunks = simplifyNonConflicts(chunks)
+	}
+
+	return m.write(chunks)
+}
+
+func (m *contentMerger) key(line []byte) []byte {
+	return normalizeLine(line, &m.opts.lineOpts)
+}
+
+func (m *contentMerger) diff(a, b [][]byte) []diffChange {
+	fa, fb := newRecordFiles(a, b, m.key)
+	return diffRecords(fa, fb, &m.opts.lineOpts)
+}
+
+// appendChunk adds a chunk, merging it into the last one if they overlap.
+func appendChunk(chunks []*mergeChunk, mode, i0, chg0, i1, chg1, i2, chg2 int) []*mergeChunk {
+	if len(chunks) > 0 {
+		last := chunks[len(chunks)-1]
+		if i1 <= last.i1+last.chg1 || i2 <= last.i2+last.chg2 {
+			if mode != last.mode {
+				last.mode = chunkConflict
+			}
+			last.chg0 = i0 + chg0 - last.i0
+			last.chg1 = i1 + chg1 - last.i1
+			last.chg2 = i2 + chg2 - last.i2
+			return chunks
+		}
+	}
+	return append(chunks, &mergeChunk{mode: mode, i0: i0, chg0: chg0, i1: i1, chg1: chg1, i2: i2, chg2: chg2})
+}
+
+// combine walks changes of both sides in base order and turns them into
+// chunks, overlapping changes become conflicts unless they are identical.
+func (m *contentMerger) combine(ourChanges, theirChanges []diffChange) []*mergeChunk {
+	chunks := []*mergeChunk{}
+	x1, x2 := 0, 0
+	for x1 < len(ourChanges) && x2 < len(theirChanges) {
+		c1, c2 := &ourChanges[x1], &theirChanges[x2]
+		if c1.oldStart+c1.oldCount < c2.oldStart {
+			chunks = appendChunk(chunks, chunkOurs, c1.oldStart, c1.oldCount, c1.newStart, c1.newCount,
+				c2.newStart-c2.oldStart+c1.oldStart, c1.oldCount)
+			x1++
+			continue
+		}
+		if c2.oldStart+c2.oldCount < c1.oldStart {
+			chunks = appendChunk(chunks, chunkTheirs, c2.oldStart, c2.oldCount,
+				c1.newStart-c1.oldStart+c2.oldStart, c2.oldCount, c2.newStart, c2.newCount)
+			x2++
+			continue
+		}
+
+		if c1.oldStart != c2.oldStart || c1.oldCount != c2.oldCount || c1.newCount != c2.newCount ||
+			!m.sameLines(m.ours[c1.newStart:c1.newStart+c1.newCount], m.theirs[c2.newStart:c2.newStart+c2.newCount]) {
+			off := c1.oldStart - c2.oldStart
+			ffo := off + c1.oldCount - c2.oldCount
+
+			i0, i1, i2 := c1.oldStart, c1.newStart, c2.newStart
+			if off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := c1.oldStart + c1.oldCount - i0
+			chg1 := c1.newStart + c1.newCount - i1
+			chg2 := c2.newStart + c2.newCount - i2
+			if ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			chunks = appendChunk(chunks, chunkConflict, i0, chg0, i1, chg1, i2, chg2)
+		}
+
+		end1 := c1.oldStart + c1.oldCount
+		end2 := c2.oldStart + c2.oldCount
+		if end1 >= end2 {
+			x2++
+		}
+		if end2 >= end1 {
+			x1++
+		}
+	}
+
+	for ; x1 < len(ourChanges); x1++ {
+		c1 := &ourChanges[x1]
+		chunks = appendChunk(chunks, chunkOurs, c1.oldStart, c1.oldCount, c1.newStart, c1.newCount,
+			c1.oldStart+len(m.theirs)-len(m.base), c1.oldCount)
+	}
+	for ; x2 < len(theirChanges); x2++ {
+		c2 := &theirChanges[x2]
+		chunks = appendChunk(chunks, chunkTheirs, c2.oldStart, c2.oldCount,
+			c2.oldStart+len(m.ours)-len(m.base), c2.oldCount, c2.newStart, c2.newCount)
+	}
+
+	return chunks
+}
+
+func (m *contentMerger) sameLines(a, b [][]byte) bool {
+	for idx := range a {
+		if !bytes.Equal(m.key(a[idx]), m.key(b[idx])) {
+			return false
+		}
+	}
+	return true
+}
+
+// refineConflicts diffs the sides of each conflict against each other and
+// splits it into the parts which really differ.
+func (m *contentMerger) refineConflicts(chunks []*mergeChunk) []*mergeChunk {
+	result := make([]*mergeChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		// there is nothing to refine when one side is empty
+		if chunk.mode != chunkConflict || chunk.chg1 == 0 || chunk.chg2 == 0 {
+			result = append(result, chunk)
+			continue
+		}
+
+		changes := m.diff(m.ours[chunk.i1:chunk.i1+chunk.chg1], m.theirs[chunk.i2:chunk.i2+chunk.chg2])
+		if len(changes) == 0 {
+			chunk.mode = chunkSame
+			result = append(result, chunk)
+			continue
+		}
+
+		for _, change := range changes {
+			result = append(result, &mergeChunk{
+				mode: chunkConflict,
+				i0:   chunk.i0,
+				chg0: chunk.chg0,
+				i1:   chunk.i1 + change.oldStart,
+				chg1: change.oldCount,
+				i2:   chunk.i2 + change.newStart,
+				chg2: change.newCount,
+			})
+		}
+	}
+	return result
+}
+
+// simplifyNonConflicts joins conflicts separated by three lines or less,
+// they are easier to resolve as a single one.
+func simplifyNonConflicts(chunks []*mergeChunk) []*mergeChunk {
+	if len(chunks) == 0 {
+		return chunks
+	}
+
+	result := []*mergeChunk{chunks[0]}
+	for _, next := range chunks[1:] {
+		last := result[len(result)-1]
+		if last.mode != chunkConflict || next.mode != chunkConflict || next.i1-(last.i1+last.chg1) > 3 {
+			result = append(result, next)
+			continue
+		}
+		last.chg1 = next.i1 + next.chg1 - last.i1
+		last.chg2 = next.i2 + next.chg2 - last.i2
+	}
+	return result
+}
+
+// write produces merged content from ours with chunks applied.
+func (m *contentMerger) write(chunks []*mergeChunk) ([]byte, int) {
+	buf := &bytes.Buffer{}
+	conflicts := 0
+	i := 0
+	for _, chunk := range chunks {
+		if chunk.mode == chunkConflict && m.opts.favor != MERGE_FAVOR_NONE {
+			chunk.mode = int(m.opts.favor)
+		}
+
+		switch {
+		case chunk.mode == chunkConflict:
+			conflicts++
+			m.writeConflict(buf, i, chunk)
+		case chunk.mode&(chunkOurs|chunkTheirs) != 0:
+			copyLines(buf, m.ours[i:chunk.i1], false, false)
+			if chunk.mode&chunkOurs != 0 {
+				copyLines(buf, m.ours[chunk.i1:chunk.i1+chunk.chg1], m.needsCR(chunk), chunk.mode&chunkTheirs != 0)
+			}
+			if chunk.mode&chunkTheirs != 0 {
+				copyLines(buf, m.theirs[chunk.i2:chunk.i2+chunk.chg2], false, false)
+			}
+		default:
+			// identical changes are copied from ours
+			continue
+		}
+		i = chunk.i1 + chunk.chg1
+	}
+	copyLines(buf, m.ours[i:], false, false)
+
+	return buf.Bytes(), conflicts
+}
+
+func (m *contentMerger) writeConflict(buf *bytes.Buffer, i int, chunk *mergeChunk) {
+	needsCR := m.needsCR(chunk)
+	size := m.opts.markerSize
+	if size <= 0 {
+		size = defaultMarkerSize
+	}
+
+	copyLines(buf, m.ours[i:chunk.i1], false, false)
+
+	writeMarker(buf, '<', size, m.opts.ourLabel, needsCR)
+	copyLines(buf, m.ours[chunk.i1:chunk.i1+chunk.chg1], needsCR, true)
+
+	if m.opts.style == CONFLICT_STYLE_DIFF3 {
+		writeMarker(buf, '|', size, m.opts.baseLabel, needsCR)
+		copyLines(buf, m.base[chunk.i0:chunk.i0+chunk.chg0], needsCR, true)
+	}
+
+	writeMarker(buf, '=', size, "", needsCR)
+	copyLines(buf, m.theirs[chunk.i2:chunk.i2+chunk.chg2], needsCR, true)
+	writeMarker(buf, '>', size, m.opts.theirLabel, needsCR)
+}
+
+func writeMarker(buf *bytes.Buffer, ch byte, size int, label string, needsCR bool) {
+	buf.Write(bytes.Repeat([]byte{ch}, size))
+	if label != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(label)
+	}
+	if needsCR {
+		buf.WriteByte('\r')
+	}
+	buf.WriteByte('\n')
+}
+
+// copyLines writes lines, terminating the last one when addNL is set.
+func copyLines(buf *bytes.Buffer, lines [][]byte, needsCR, addNL bool) {
+	if len(lines) == 0 {
+		return
+	}
+
+	for _, line := range lines {
+		buf.Write(line)
+	}
+	if last := lines[len(lines)-1]; addNL && (len(last) == 0 || last[len(last)-1] != '\n') {
+		if needsCR {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// needsCR tells whether lines added around the chunk should end with CRLF,
+// matching the lines preceding it on both sides.
+func (m *contentMerger) needsCR(chunk *mergeChunk) bool {
+	prev := func(idx int) int {
+		if idx > 0 {
+			return idx - 1
+		}
+		return 0
+	}
+
+	needs := eolCRLF(m.ours, prev(chunk.i1))
+	if needs != 0 {
+		needs = eolCRLF(m.theirs, prev(chunk.i2))
+	}
+	if needs != 0 {
+		needs = eolCRLF(m.base, 0)
+	}
+	return needs > 0
+}
+
+// eolCRLF returns 1 if line ends with CRLF, 0 if it ends with LF only and
+// -1 if it cannot be told.
+func eolCRLF(lines [][]byte, idx int) int {
+	crlf := func(line []byte) int {
+		if len(line) > 1 && line[len(line)-2] == '\r' {
+			return 1
+		}
+		return 0
+	}
+
+	if idx < len(lines)-1 {
+		// all lines before the last one end with LF
+		return crlf(lines[idx])
+	}
+	if len(lines) == 0 {
+		return -1
+	}
+	if line := lines[idx]; len(line) > 0 && line[len(line)-1] == '\n' {
+		return crlf(line)
+	}
+	if idx == 0 {
+		return -1
+	}
+	return crlf(lines[idx-1])
+}
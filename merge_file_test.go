@@ -0,0 +1,44 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestMergeContent(t *testing.T) {
+	tests := []struct {
+		base, ours, theirs string
+		style              ConflictStyle
+		favor              MergeFavor
+		want               string
+		conflicts          int
+	}{
+		{"a\nb\nc\n", "A\nb\nc\n", "a\nb\nC\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_NONE, "A\nb\nC\n", 0},
+		{"a\nb\n", "a\nB\n", "a\nB\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_NONE, "a\nB\n", 0},
+		{"a\n", "b\n", "c\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_NONE,
+			"<<<<<<< ours\nb\n=======\nc\n>>>>>>> theirs\n", 1},
+		{"a\n", "b\n", "c\n", CONFLICT_STYLE_DIFF3, MERGE_FAVOR_NONE,
+			"<<<<<<< ours\nb\n||||||| base\na\n=======\nc\n>>>>>>> theirs\n", 1},
+		{"a\n", "b\n", "c\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_OURS, "b\n", 0},
+		{"a\n", "b\n", "c\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_THEIRS, "c\n", 0},
+		{"a\n", "b\n", "c\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_UNION, "b\nc\n", 0},
+		// conflicts are narrowed down to lines which differ between sides
+		{"x\na\ny\n", "x\nb\nz\n", "x\nc\nz\n", CONFLICT_STYLE_MERGE, MERGE_FAVOR_NONE,
+			"x\n<<<<<<< ours\nb\n=======\nc\n>>>>>>> theirs\nz\n", 1},
+	}
+
+	for idx, test := range tests {
+		opts := &mergeFileOptions{
+			style:      test.style,
+			favor:      test.favor,
+			markerSize: defaultMarkerSize,
+			ourLabel:   "ours",
+			theirLabel: "theirs",
+			baseLabel:  "base",
+		}
+
+		got, conflicts := mergeContent([]byte(test.base), []byte(test.ours), []byte(test.theirs), opts)
+		if string(got) != test.want || conflicts != test.conflicts {
+			t.Errorf("test %d: got %q with %d conflicts, want %q with %d", idx, got, conflicts, test.want, test.conflicts)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package git
+
+// Merge strategies of pull requests other than a merge commit. Like the
+// merge itself they work on objects only and write nothing until all of the
+// work is done.
+
+// SquashMerge merges head into base and writes the result as a single commit
+// on top of base, the same way `git merge --squash` followed by a commit
+// does. The author commits it too. If the merge has conflicts, nothing is
+// written and ErrMergeConflict lists them.
+func (repo *Repository) SquashMerge(base, head, message string, author *Signature, opts MergeOptions) (sha1, error) {
+	baseID, err := repo.resolveRevision(base)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	headID, err := repo.resolveRevision(head)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	m, err := repo.mergeCommits(baseID, headID, &opts)
+	if err != nil {
+		return sha1{}, err
+	}
+	if len(m.conflicts) > 0 {
+		return sha1{}, ErrMergeConflict{Conflicts: m.conflicts}
+	}
+
+	tree, err := m.editor.write(repo)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	return writeCommit(repo, tree, []sha1{baseID}, author, nil, message)
+}
+
+// RebaseOnto replays commits of branchTip missing in upstream on top of it
+// and returns the new tip, the same way `git rebase` does: merge commits are
+// dropped, commits left without changes are skipped and commits already
+// following upstream are kept as they are. Replayed commits keep their
+// author and message and get the new committer. If a commit does not apply,
+// nothing is written and ErrRebaseConflict tells which one.
+func (repo *Repository) RebaseOnto(upstream, branchTip string, committer *Signature, opts MergeOptions) (sha1, error) {
+	upstreamID, err := repo.resolveRevision(upstream)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	tipID, err := repo.resolveRevision(branchTip)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	nodes, err := repo.seriesNodes(upstreamID, tipID)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	// replayed commits are kept in memory until the last one applies
+	pending := newMemoryObjects()
+	view := repo.withOverlay(pending)
+
+	headNode, err := view.loadWalkNode(upstreamID)
+	if err != nil {
+		return sha1{}, err
+	}
+	head, tree := upstreamID, headNode.tree
+
+	for _, node := range nodes {
+		var parentTree sha1
+		if len(node.parents) > 0 {
+			if node.parents[0] == head {
+				head, tree = node.id, node.tree
+				continue
+			}
+
+			parent, err := view.loadWalkNode(node.parents[0])
+			if err != nil {
+				return sha1{}, err
+			}
+			parentTree = parent.tree
+		}
+
+		m, err := view.mergeTrees(parentTree, tree, node.tree, &opts, 0)
+		if err != nil {
+			return sha1{}, err
+		}
+		if len(m.conflicts) > 0 {
+			return sha1{}, ErrRebaseConflict{CommitID: node.id.String(), Conflicts: m.conflicts}
+		}
+
+		merged, err := m.editor.write(pending)
+		if err != nil {
+			return sha1{}, err
+		}
+		if merged == tree {
+			// changes of the commit are in upstream already
+			continue
+		}
+
+		raw, err := node.rawCommit(view)
+		if err != nil {
+			return sha1{}, err
+		}
+
+		head, err = writeCommit(pending, merged, []sha1{head}, raw2signature(raw.Author), committer, raw.Message)
+		if err != nil {
+			return sha1{}, err
+		}
+		tree = merged
+	}
+
+	if err = pending.writeTo(repo); err != nil {
+		return sha1{}, err
+	}
+	return head, nil
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRebaseOnto(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "b\n"}, 100)
+	upstream := testCommitFiles(t, repo, map[string]string{"a": "upstream\n", "b": "b\n"}, 200, base)
+	first := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "first\n"}, 300, base)
+	second := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "first\n", "c": "c\n"}, 400, first)
+
+	committer := &Signature{Name: "C O Mitter", Email: "committer@example.com", When: time.Unix(500, 0).UTC()}
+	tip, err := repo.RebaseOnto(upstream.String(), second.String(), committer, DefaultMergeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := repo.GetCommit(tip.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Author.Email != "author@example.com" || commit.Committer.Email != committer.Email {
+		t.Errorf("got author %s and committer %s", commit.Author.Email, commit.Committer.Email)
+	}
+	if got := treeNames(t, repo, commit.Tree.ID); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("got tree entries %v", got)
+	}
+	if got := readFile(t, repo, tip, "a"); got != "upstream\n" {
+		t.Errorf("got a %q, want upstream content", got)
+	}
+
+	// replayed commits sit on upstream in order
+	parent, err := commit.Parent(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grandparent, err := parent.ParentID(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.ID == first || grandparent != upstream || parent.CommitMessage != "message\n" {
+		t.Errorf("got parent %s on %s, want %s replayed on %s", parent.ID, grandparent, first, upstream)
+	}
+}
+
+func TestRebaseOntoConflict(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "b\n"}, 100)
+	upstream := testCommitFiles(t, repo, map[string]string{"a": "upstream\n", "b": "b\n"}, 200, base)
+	// the first commit applies, the second one does not
+	first := testCommitFiles(t, repo, map[string]string{"a": "a\n", "b": "first\n"}, 300, base)
+	second := testCommitFiles(t, repo, map[string]string{"a": "second\n", "b": "first\n"}, 400, first)
+
+	before := objectFiles(t, repo)
+	committer := &Signature{Name: "C O Mitter", Email: "committer@example.com", When: time.Unix(500, 0).UTC()}
+	_, err := repo.RebaseOnto(upstream.String(), second.String(), committer, DefaultMergeOptions())
+	if !IsErrRebaseConflict(err) {
+		t.Fatalf("got error %v, want rebase conflict", err)
+	}
+
+	conflict := err.(ErrRebaseConflict)
+	if conflict.CommitID != second.String() || len(conflict.Conflicts) != 1 || conflict.Conflicts[0].Path != "a" {
+		t.Errorf("got conflict %v", err)
+	}
+	if after := objectFiles(t, repo); !reflect.DeepEqual(after, before) {
+		t.Errorf("objects changed from %v to %v", before, after)
+	}
+}
@@ -0,0 +1,70 @@
+package git
+
+import (
+	"testing"
+)
+
+// testTree stores a tree of files with given content.
+func testTree(t *testing.T, repo *Repository, files map[string]string) *Tree {
+	editor := repo.newTreeEditor(sha1{})
+	for relpath, content := range files {
+		if err := editor.setContent(relpath, []byte(content), ENTRY_MODE_BLOB); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id, err := editor.write(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTree(repo, id)
+}
+
+func TestMergeTrees(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testTree(t, repo, map[string]string{"a": "1\n2\n3\n", "dir/b": "b\n"})
+	ours := testTree(t, repo, map[string]string{"a": "one\n2\n3\n", "dir/b": "b\n", "c": "c\n"})
+	theirs := testTree(t, repo, map[string]string{"a": "1\n2\nthree\n"})
+
+	id, err := repo.MergeTrees(base, ours, theirs, DefaultMergeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := treeNames(t, repo, id); len(names) != 2 || names[0] != "a" || names[1] != "c" {
+		t.Fatalf("got root entries %v, want a and c", names)
+	}
+
+	entry, err := repo.lookupPath(id, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := repo.readBlob(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\n2\nthree\n" {
+		t.Errorf("got merged content %q", data)
+	}
+}
+
+func TestMergeTreesConflicts(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testTree(t, repo, map[string]string{"a": "a\n", "b": "b\n"})
+	ours := testTree(t, repo, map[string]string{"a": "ours\n"})
+	theirs := testTree(t, repo, map[string]string{"a": "theirs\n", "b": "changed\n"})
+
+	_, err := repo.MergeTrees(base, ours, theirs, DefaultMergeOptions())
+	if !IsErrMergeConflict(err) {
+		t.Fatalf("got error %v, want merge conflict", err)
+	}
+
+	types := map[string]MergeConflictType{}
+	for _, conflict := range err.(ErrMergeConflict).Conflicts {
+		types[conflict.Path] = conflict.Type
+	}
+	if len(types) != 2 || types["a"] != MERGE_CONFLICT_CONTENT || types["b"] != MERGE_CONFLICT_MODIFY_DELETE {
+		t.Errorf("got conflicts %v", types)
+	}
+}
@@ -0,0 +1,466 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ApplyOptions controls how hunks are matched against the tree content.
+type ApplyOptions struct {
+	// Fuzz is the number of context lines which may be ignored at each end
+	// of a hunk when it does not apply as is
+	Fuzz int
+	// IgnoreWhitespace compares context lines ignoring changes in amount of
+	// whitespace, content of the tree is kept for them
+	IgnoreWhitespace bool
+}
+
+// PatchConflict describes a file or a hunk which could not be applied.
+type PatchConflict struct {
+	Path string
+	// Hunk is 1-based index of the hunk in file section, zero means the
+	// file as a whole
+	Hunk   int
+	Reason string
+}
+
+// appliedFile is the state of a path after a file section was applied.
+type appliedFile struct {
+	mode EntryMode
+	id   sha1
+	// data is new content, nil means content of existing object id
+	data    []byte
+	removed bool
+	// renamed marks sources of renames, they still can be read by later
+	// sections the same as in the base tree
+	renamed bool
+}
+
+type patchApplier struct {
+	repo    *Repository
+	editor  *treeEditor
+	opts    *ApplyOptions
+	results map[string]*appliedFile
+	// paths in order of the first change, for deterministic tree edits
+	paths     []string
+	conflicts []*PatchConflict
+}
+
+// ApplyPatch applies unified or git diff to the tree without a working
+// copy and returns id of the resulting tree. Nil baseTree stands for an
+// empty tree. If any file or hunk does not apply, nothing is written and
+// ErrPatchConflict lists all failures.
+func (repo *Repository) ApplyPatch(baseTree *Tree, patch io.Reader, opts ApplyOptions) (sha1, error) {
+	data, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	files, err := parsePatch(data)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	var treeID sha1
+	if baseTree != nil {
+		treeID = baseTree.ID
+	}
+
+	a := &patchApplier{
+		repo:    repo,
+		editor:  repo.newTreeEditor(treeID),
+		opts:    &opts,
+		results: map[string]*appliedFile{},
+	}
+	for _, file := range files {
+		if err = a.applyFile(file); err != nil {
+			return sha1{}, err
+		}
+	}
+
+	if len(a.conflicts) > 0 {
+		return sha1{}, ErrPatchConflict{Conflicts: a.conflicts}
+	}
+
+	if err = a.updateTree(); err != nil {
+		return sha1{}, err
+	}
+	return a.editor.write(repo)
+}
+
+func (a *patchApplier) conflict(path string, hunk int, reason string) {
+	a.conflicts = append(a.conflicts, &PatchConflict{Path: path, Hunk: hunk, Reason: reason})
+}
+
+func (a *patchApplier) setResult(path string, result *appliedFile) {
+	if _, ok := a.results[path]; !ok {
+		a.paths = append(a.paths, path)
+	}
+	a.results[path] = result
+}
+
+// source returns current state of path, nil if there is no file.
+func (a *patchApplier) source(path string) (*appliedFile, error) {
+	if result, ok := a.results[path]; ok && !result.renamed {
+		if result.removed {
+			return nil, nil
+		}
+		return result, nil
+	}
+	return a.baseSource(path)
+}
+
+// baseSource returns state of path in the base tree.
+func (a *patchApplier) baseSource(path string) (*appliedFile, error) {
+	entry, err := a.editor.get(path)
+	if err != nil || entry == nil || entry.isDir() {
+		return nil, err
+	}
+	return &appliedFile{mode: entry.Mode, id: entry.ID}, nil
+}
+
+func (a *patchApplier) content(file *appliedFile) ([]byte, error) {
+	if file.data != nil {
+		return file.data, nil
+	}
+	return a.repo.diffContent(file.id, file.mode)
+}
+
+func (a *patchApplier) applyFile(file *patchFile) error {
+	path := file.newPath
+	if file.isDelete {
+		path = file.oldPath
+	}
+
+	for _, name := range []string{file.oldPath, file.newPath} {
+		if name == "" {
+			continue
+		}
+		if err := checkTreePath(name); err != nil {
+			a.conflict(name, 0, err.Error())
+			return nil
+		}
+	}
+
+	var src *appliedFile
+	if !file.isNew {
+		var err error
+		if file.isRename || file.isCopy {
+			// like git, renames and copies do not depend on order of
+			// sections and always take the base content
+			src, err = a.baseSource(file.oldPath)
+		} else {
+			src, err = a.source(file.oldPath)
+		}
+		if err != nil {
+			return err
+		}
+		if src == nil {
+			a.conflict(file.oldPath, 0, "file does not exist")
+			return nil
+		}
+	}
+
+	if file.isNew || ((file.isRename || file.isCopy) && file.newPath != file.oldPath) {
+		existing, err := a.source(file.newPath)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			a.conflict(file.newPath, 0, "file already exists")
+			return nil
+		}
+	}
+
+	result := &appliedFile{mode: file.newMode}
+	if src != nil {
+		result.id = src.id
+		result.data = src.data
+		if result.mode == 0 {
+			result.mode = src.mode
+		}
+	}
+	if result.mode == 0 {
+		result.mode = ENTRY_MODE_BLOB
+	}
+	if file.isNew {
+		// new files without hunks are empty
+		result.data = []byte{}
+	}
+
+	if len(file.hunks) > 0 || len(file.binary) > 0 || file.binaryNoData {
+		data, ok, err := a.applyContent(path, file, src)
+		if err != nil || !ok {
+			return err
+		}
+		if file.isDelete {
+			if len(data) > 0 {
+				a.conflict(path, 0, "deleted file still has content")
+			}
+		} else if err = setResultContent(result, data); err != nil {
+			a.conflict(path, 0, err.Error())
+			return nil
+		}
+	}
+
+	switch {
+	case file.isDelete:
+		a.setResult(file.oldPath, &appliedFile{removed: true})
+		return nil
+	case file.isRename && file.newPath != file.oldPath:
+		a.setResult(file.oldPath, &appliedFile{removed: true, renamed: true})
+	}
+
+	a.setResult(file.newPath, result)
+	return nil
+}
+
+// setResultContent stores new content, submodules take the commit id of
+// "Subproject commit" line.
+func setResultContent(result *appliedFile, data []byte) error {
+	if result.mode != ENTRY_MODE_COMMIT {
+		result.data = data
+		return nil
+	}
+
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "Subproject commit ") {
+		return fmt.Errorf("invalid submodule content")
+	}
+
+	id, err := NewIDFromString(strings.TrimPrefix(line, "Subproject commit "))
+	if err != nil {
+		return err
+	}
+	result.id, result.data = id, nil
+	return nil
+}
+
+// applyContent returns new content of the file, ok is false when a conflict
+// was recorded.
+func (a *patchApplier) applyContent(path string, file *patchFile, src *appliedFile) ([]byte, bool, error) {
+	var data []byte
+	if src != nil {
+		var err error
+		if data, err = a.content(src); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if file.binaryNoData {
+		a.conflict(path, 0, "binary patch has no data")
+		return nil, false, nil
+	}
+
+	if len(file.binary) > 0 {
+		return a.applyBinary(path, file, data)
+	}
+
+	image := splitLines(data)
+	failed := false
+	for idx, hunk := range file.hunks {
+		var ok bool
+		if image, ok = applyHunk(image, hunk, a.opts); !ok {
+			a.conflict(path, idx+1, "hunk does not apply")
+			failed = true
+		}
+	}
+	if failed {
+		return nil, false, nil
+	}
+
+	return bytes.Join(image, nil), true, nil
+}
+
+func (a *patchApplier) applyBinary(path string, file *patchFile, data []byte) ([]byte, bool, error) {
+	// ids are checked as prefixes, the index line may be abbreviated
+	if file.oldIndex != "" && !file.isNew && !strings.HasPrefix(hashObject(OBJECT_BLOB, data).String(), file.oldIndex) {
+		a.conflict(path, 0, "content does not match patch index")
+		return nil, false, nil
+	}
+
+	hunk := file.binary[0]
+	result := hunk.data
+	if hunk.isDelta {
+		var err error
+		if result, err = applyDelta(data, hunk.data); err != nil {
+			a.conflict(path, 0, err.Error())
+			return nil, false, nil
+		}
+	}
+
+	if file.newIndex != "" && !file.isDelete && !strings.HasPrefix(hashObject(OBJECT_BLOB, result).String(), file.newIndex) {
+		a.conflict(path, 0, "result does not match patch index")
+		return nil, false, nil
+	}
+	return result, true, nil
+}
+
+// updateTree puts results into the tree. Removals go first, so new files
+// can take place of removed directories and the other way around.
+func (a *patchApplier) updateTree() error {
+	for _, path := range a.paths {
+		if a.results[path].removed {
+			if err := a.editor.remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, path := range a.paths {
+		result := a.results[path]
+		var err error
+		switch {
+		case result.removed:
+		case result.data != nil:
+			err = a.editor.setContent(path, result.data, result.mode)
+		default:
+			err = a.editor.set(path, result.id, result.mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyHunk finds the place of the hunk in the image and replaces it, the
+// same way `git apply` does: the expected position is tried first, then
+// positions around it. Hunks starting at the first line or having no
+// trailing context are anchored to the beginning or end of the file.
+func applyHunk(image [][]byte, hunk *patchHunk, opts *ApplyOptions) ([][]byte, bool) {
+	lines := hunk.lines
+	leading, trailing := 0, 0
+	for leading < len(lines) && lines[leading].op == ' ' {
+		leading++
+	}
+	for trailing < len(lines)-leading && lines[len(lines)-1-trailing].op == ' ' {
+		trailing++
+	}
+
+	pos := hunk.newStart - 1
+	if hunk.newLines == 0 {
+		// range of an empty side refers to the line before
+		pos = hunk.newStart
+	}
+
+	hasContext := leading > 0 || trailing > 0
+	matchBeginning := hunk.oldStart == 0 || (hunk.oldStart == 1 && hasContext)
+	matchEnd := hasContext && trailing == 0
+
+	minLeading, minTrailing := leading-opts.Fuzz, trailing-opts.Fuzz
+	for {
+		if at := findHunk(image, lines, pos, matchBeginning, matchEnd, opts); at >= 0 {
+			return replaceHunk(image, lines, at), true
+		}
+
+		if leading <= minLeading && trailing <= minTrailing {
+			return image, false
+		}
+		if matchBeginning || matchEnd {
+			matchBeginning, matchEnd = false, false
+			continue
+		}
+
+		// drop context of the longer end, or of both if they are equal
+		dropLeading := leading > minLeading && (leading >= trailing || trailing <= minTrailing)
+		dropTrailing := trailing > minTrailing && (trailing >= leading || leading <= minLeading)
+		if dropLeading {
+			lines = lines[1:]
+			leading--
+			pos++
+		}
+		if dropTrailing {
+			lines = lines[:len(lines)-1]
+			trailing--
+		}
+	}
+}
+
+// findHunk returns position in the image where the old side of hunk lines
+// matches, or -1.
+func findHunk(image [][]byte, lines []patchLine, pos int, matchBeginning, matchEnd bool, opts *ApplyOptions) int {
+	pre := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		if line.op != '+' {
+			pre = append(pre, line.text)
+		}
+	}
+
+	last := len(image) - len(pre)
+	if last < 0 {
+		return -1
+	}
+
+	switch {
+	case matchBeginning:
+		pos = 0
+	case matchEnd:
+		pos = last
+	}
+	if pos > last {
+		pos = last
+	}
+	if pos < 0 {
+		pos = 0
+	}
+
+	matches := func(at int) bool {
+		if (matchBeginning && at != 0) || (matchEnd && at != last) {
+			return false
+		}
+		for idx, line := range pre {
+			if !linesEqual(image[at+idx], line, opts) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for dist := 0; pos-dist >= 0 || pos+dist <= last; dist++ {
+		if pos+dist <= last && matches(pos+dist) {
+			return pos + dist
+		}
+		if dist > 0 && pos-dist >= 0 && matches(pos-dist) {
+			return pos - dist
+		}
+	}
+	return -1
+}
+
+var applyWhitespaceOptions = &LineDiffOptions{IgnoreWhitespaceChange: true}
+
+func linesEqual(a, b []byte, opts *ApplyOptions) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if !opts.IgnoreWhitespace {
+		return false
+	}
+	return bytes.Equal(normalizeLine(a, applyWhitespaceOptions), normalizeLine(b, applyWhitespaceOptions))
+}
+
+// replaceHunk replaces old side of hunk lines at the position with the new
+// side. Context lines are taken from the image.
+func replaceHunk(image [][]byte, lines []patchLine, at int) [][]byte {
+	result := make([][]byte, 0, len(image)+len(lines))
+	result = append(result, image[:at]...)
+
+	idx := at
+	for _, line := range lines {
+		switch line.op {
+		case ' ':
+			result = append(result, image[idx])
+			idx++
+		case '-':
+			idx++
+		case '+':
+			result = append(result, line.text)
+		}
+	}
+
+	return append(result, image[idx:]...)
+}
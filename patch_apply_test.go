@@ -0,0 +1,119 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testPatch = `diff --git a/a b/a
+--- a/a
++++ b/a
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+diff --git a/new b/new
+new file mode 100644
+--- /dev/null
++++ b/new
+@@ -0,0 +1 @@
++new
+`
+
+func TestApplyPatch(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// the hunk is found two lines below where the patch places it
+	base := testTree(t, repo, map[string]string{"a": "0\n0\n1\n2\n3\n4\n5\n"})
+	id, err := repo.ApplyPatch(base, strings.NewReader(testPatch), ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for relpath, want := range map[string]string{"a": "0\n0\n1\n2\nthree\n4\n5\n", "new": "new\n"} {
+		entry, err := repo.lookupPath(id, relpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			t.Fatalf("%s is missing", relpath)
+		}
+		data, err := repo.readBlob(entry.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", relpath, data, want)
+		}
+	}
+}
+
+func TestApplyPatchConflict(t *testing.T) {
+	repo := newTestRepository(t)
+
+	base := testTree(t, repo, map[string]string{"a": "1\n2\nTHREE\n4\n5\n"})
+	_, err := repo.ApplyPatch(base, strings.NewReader(testPatch), ApplyOptions{})
+	if !IsErrPatchConflict(err) {
+		t.Fatalf("got error %v, want patch conflict", err)
+	}
+
+	conflict := err.(ErrPatchConflict).Conflicts[0]
+	if conflict.Path != "a" || conflict.Hunk != 1 {
+		t.Errorf("got conflict %+v", conflict)
+	}
+}
+
+func TestBase85RoundTrip(t *testing.T) {
+	for size := 0; size <= 9; size++ {
+		data := bytes.Repeat([]byte{0xff, 0x00, 0x7f}, 3)[:size]
+		decoded, err := decode85(encode85(data), size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("size %d: got %x, want %x", size, decoded, data)
+		}
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	src := make([]byte, 4096)
+	for idx := range src {
+		src[idx] = byte(idx * 7 % 251)
+	}
+	dst := append(append([]byte("prefix"), src[:1000]...), src[2000:]...)
+
+	delta := makeDelta(src, dst, len(dst))
+	if delta == nil {
+		t.Fatal("delta is larger than the target")
+	}
+
+	got, err := applyDelta(src, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dst) {
+		t.Error("delta does not restore the target")
+	}
+}
+
+func TestApplyPatchInvalidPaths(t *testing.T) {
+	repo := newTestRepository(t)
+	base := testTree(t, repo, map[string]string{"a": "a\n"})
+
+	for _, name := range []string{"a/../x", "foo//bar", ".git/config", "dir/.GIT/hooks/post-checkout", "./x"} {
+		patch := "diff --git a/" + name + " b/" + name + "\nnew file mode 100644\n--- /dev/null\n+++ b/" + name + "\n@@ -0,0 +1 @@\n+x\n"
+		_, err := repo.ApplyPatch(base, strings.NewReader(patch), ApplyOptions{})
+		if !IsErrPatchConflict(err) {
+			t.Errorf("%s: got error %v, want patch conflict", name, err)
+		}
+	}
+
+	// the old name of a rename is checked too
+	patch := "diff --git a/../a b/b\nsimilarity index 100%\nrename from ../a\nrename to b\n"
+	if _, err := repo.ApplyPatch(base, strings.NewReader(patch), ApplyOptions{}); !IsErrPatchConflict(err) {
+		t.Errorf("rename from ../a: got error %v, want patch conflict", err)
+	}
+}
@@ -0,0 +1,512 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parser of unified diffs, with git extended headers and binary patches.
+// Anything outside file sections, like commit messages of format-patch
+// output, is skipped.
+
+// patchFile is a single file section of a patch. Paths are empty for
+// /dev/null.
+type patchFile struct {
+	oldPath, newPath string
+	oldMode, newMode EntryMode
+	isNew, isDelete  bool
+	isRename, isCopy bool
+	// ids from the index line, possibly abbreviated
+	oldIndex, newIndex string
+
+	hunks []*patchHunk
+	// binary holds forward and optional reverse hunk of binary patch
+	binary []*binaryHunk
+	// binaryNoData is set for "Binary files differ" sections, which can
+	// not be applied
+	binaryNoData bool
+}
+
+type patchHunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+	lines              []patchLine
+}
+
+// patchLine is a hunk line, op is one of ' ', '-', '+'. Text includes line
+// terminator unless the line is marked with "\ No newline at end of file".
+type patchLine struct {
+	op   byte
+	text []byte
+}
+
+type binaryHunk struct {
+	isDelta bool
+	size    int
+	data    []byte
+}
+
+type patchParser struct {
+	lines [][]byte
+	pos   int
+}
+
+func (p *patchParser) peek() (string, bool) {
+	if p.pos >= len(p.lines) {
+		return "", false
+	}
+	return string(p.lines[p.pos]), true
+}
+
+func (p *patchParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("patch line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// parsePatch splits patch into file sections.
+func parsePatch(data []byte) ([]*patchFile, error) {
+	p := &patchParser{lines: splitLines(data)}
+	files := []*patchFile{}
+	for {
+		line, ok := p.peek()
+		if !ok {
+			return files, nil
+		}
+
+		var file *patchFile
+		var err error
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			file, err = p.parseGitFile()
+		case strings.HasPrefix(line, "--- ") && p.pos+1 < len(p.lines) && bytes.HasPrefix(p.lines[p.pos+1], []byte("+++ ")):
+			file, err = p.parseUnifiedFile()
+		default:
+			p.pos++
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+}
+
+func trimEOL(line string) string {
+	return strings.TrimRight(line, "\r\n")
+}
+
+// parseMode parses octal mode of extended header line.
+func parseMode(value string) (EntryMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return EntryMode(mode), nil
+}
+
+func (p *patchParser) parseGitFile() (*patchFile, error) {
+	file := &patchFile{}
+	header := trimEOL(strings.TrimPrefix(string(p.lines[p.pos]), "diff --git "))
+	file.oldPath, file.newPath = gitHeaderNames(header)
+	p.pos++
+
+	for {
+		line, ok := p.peek()
+		if !ok {
+			return file, nil
+		}
+		line = trimEOL(line)
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			file.oldMode, err = parseMode(line[len("old mode "):])
+		case strings.HasPrefix(line, "new mode "):
+			file.newMode, err = parseMode(line[len("new mode "):])
+		case strings.HasPrefix(line, "deleted file mode "):
+			file.isDelete = true
+			file.oldMode, err = parseMode(line[len("deleted file mode "):])
+		case strings.HasPrefix(line, "new file mode "):
+			file.isNew = true
+			file.newMode, err = parseMode(line[len("new file mode "):])
+		case strings.HasPrefix(line, "rename from "):
+			file.isRename = true
+			file.oldPath = unquotePath(line[len("rename from "):])
+		case strings.HasPrefix(line, "rename to "):
+			file.isRename = true
+			file.newPath = unquotePath(line[len("rename to "):])
+		case strings.HasPrefix(line, "copy from "):
+			file.isCopy = true
+			file.oldPath = unquotePath(line[len("copy from "):])
+		case strings.HasPrefix(line, "copy to "):
+			file.isCopy = true
+			file.newPath = unquotePath(line[len("copy to "):])
+		case strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "index "):
+			err = file.parseIndex(line[len("index "):])
+		default:
+			if err = p.parseBody(file); err != nil {
+				return nil, err
+			}
+			if file.isNew {
+				file.oldPath = ""
+			}
+			if file.isDelete {
+				file.newPath = ""
+			}
+			return file, nil
+		}
+
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.pos++
+	}
+}
+
+// parseIndex parses "<old>..<new> [mode]".
+func (file *patchFile) parseIndex(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid index line")
+	}
+
+	ids := strings.SplitN(fields[0], "..", 2)
+	if len(ids) != 2 {
+		return fmt.Errorf("invalid index line")
+	}
+	file.oldIndex, file.newIndex = ids[0], ids[1]
+
+	if len(fields) > 1 {
+		mode, err := parseMode(fields[1])
+		if err != nil {
+			return err
+		}
+		if file.oldMode == 0 {
+			file.oldMode = mode
+		}
+		if file.newMode == 0 {
+			file.newMode = mode
+		}
+	}
+	return nil
+}
+
+func (p *patchParser) parseUnifiedFile() (*patchFile, error) {
+	file := &patchFile{}
+	if err := p.parseBody(file); err != nil {
+		return nil, err
+	}
+	file.isNew = file.oldPath == ""
+	file.isDelete = file.newPath == ""
+	return file, nil
+}
+
+// parseBody parses ---/+++ lines and hunks, or binary patch.
+func (p *patchParser) parseBody(file *patchFile) error {
+	line, _ := p.peek()
+	if strings.HasPrefix(line, "--- ") && p.pos+1 < len(p.lines) && bytes.HasPrefix(p.lines[p.pos+1], []byte("+++ ")) {
+		oldName := patchFileName(trimEOL(line[len("--- "):]))
+		newName := patchFileName(trimEOL(string(p.lines[p.pos+1][len("+++ "):])))
+		p.pos += 2
+
+		if oldName != "" || file.oldPath == "" {
+			file.oldPath = oldName
+		}
+		if newName != "" || file.newPath == "" {
+			file.newPath = newName
+		}
+	}
+
+	for {
+		line, ok := p.peek()
+		switch {
+		case !ok:
+			return nil
+		case strings.HasPrefix(line, "@@ -"):
+			hunk, err := p.parseHunk()
+			if err != nil {
+				return err
+			}
+			file.hunks = append(file.hunks, hunk)
+		case trimEOL(line) == "GIT binary patch":
+			p.pos++
+			return p.parseBinary(file)
+		case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(trimEOL(line), " differ"):
+			p.pos++
+			file.binaryNoData = true
+			return nil
+		default:
+			return nil
+		}
+	}
+}
+
+// parseRange parses "start[,count]" of hunk header.
+func parseRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, ",", 2)
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := 1
+	if len(parts) == 2 {
+		if count, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
+
+func (p *patchParser) parseHunk() (*patchHunk, error) {
+	fields := strings.Fields(string(p.lines[p.pos]))
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[2], "+") {
+		return nil, p.errorf("invalid hunk header")
+	}
+
+	hunk := &patchHunk{}
+	var err error
+	if hunk.oldStart, hunk.oldLines, err = parseRange(fields[1][1:]); err != nil {
+		return nil, p.errorf("invalid hunk header: %v", err)
+	}
+	if hunk.newStart, hunk.newLines, err = parseRange(fields[2][1:]); err != nil {
+		return nil, p.errorf("invalid hunk header: %v", err)
+	}
+	p.pos++
+
+	oldLeft, newLeft := hunk.oldLines, hunk.newLines
+	for oldLeft > 0 || newLeft > 0 {
+		line, ok := p.peek()
+		if !ok {
+			return nil, p.errorf("truncated hunk")
+		}
+
+		op := byte(' ')
+		text := p.lines[p.pos][1:]
+		switch {
+		case line == "\n" || line == "\r\n":
+			// context line with its space eaten on the way
+			text = p.lines[p.pos]
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			op = line[0]
+		default:
+			return nil, p.errorf("unexpected line in hunk")
+		}
+
+		if op != '+' {
+			oldLeft--
+		}
+		if op != '-' {
+			newLeft--
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, p.errorf("hunk is longer than its header says")
+		}
+
+		hunk.lines = append(hunk.lines, patchLine{op: op, text: text})
+		p.pos++
+		p.skipNoNewline(hunk)
+	}
+
+	return hunk, nil
+}
+
+// skipNoNewline handles "\ No newline at end of file" after a hunk line.
+func (p *patchParser) skipNoNewline(hunk *patchHunk) {
+	if p.pos < len(p.lines) && bytes.HasPrefix(p.lines[p.pos], []byte("\\ ")) {
+		last := &hunk.lines[len(hunk.lines)-1]
+		last.text = bytes.TrimSuffix(last.text, []byte{'\n'})
+		last.text = bytes.TrimSuffix(last.text, []byte{'\r'})
+		p.pos++
+	}
+}
+
+func (p *patchParser) parseBinary(file *patchFile) error {
+	for len(file.binary) < 2 {
+		line, ok := p.peek()
+		if !ok {
+			break
+		}
+		line = trimEOL(line)
+
+		hunk := &binaryHunk{}
+		var sizeText string
+		switch {
+		case strings.HasPrefix(line, "literal "):
+			sizeText = line[len("literal "):]
+		case strings.HasPrefix(line, "delta "):
+			hunk.isDelta = true
+			sizeText = line[len("delta "):]
+		default:
+			if len(file.binary) == 0 {
+				return p.errorf("invalid binary patch")
+			}
+			return nil
+		}
+
+		var err error
+		if hunk.size, err = strconv.Atoi(sizeText); err != nil {
+			return p.errorf("invalid binary patch size")
+		}
+		p.pos++
+
+		encoded := []byte{}
+		for {
+			line, ok := p.peek()
+			line = trimEOL(line)
+			if !ok || line == "" {
+				p.pos++
+				break
+			}
+
+			var n int
+			switch ch := line[0]; {
+			case ch >= 'A' && ch <= 'Z':
+				n = int(ch-'A') + 1
+			case ch >= 'a' && ch <= 'z':
+				n = int(ch-'a') + 27
+			default:
+				return p.errorf("invalid binary patch line")
+			}
+
+			decoded, err := decode85([]byte(line[1:]), n)
+			if err != nil {
+				return p.errorf("%v", err)
+			}
+			encoded = append(encoded, decoded...)
+			p.pos++
+		}
+
+		if hunk.data, err = inflate(encoded); err != nil {
+			return p.errorf("corrupt binary patch: %v", err)
+		}
+		if !hunk.isDelta && len(hunk.data) != hunk.size {
+			return p.errorf("binary patch size mismatch")
+		}
+		file.binary = append(file.binary, hunk)
+	}
+	return nil
+}
+
+// patchFileName parses name of ---/+++ line, stripping the leading
+// directory like `patch -p1`. Empty name stands for /dev/null.
+func patchFileName(value string) string {
+	var name string
+	if strings.HasPrefix(value, `"`) {
+		name = unquotePath(value)
+	} else {
+		// timestamps are separated by tab
+		if idx := strings.IndexByte(value, '\t'); idx != -1 {
+			value = value[:idx]
+		}
+		name = strings.TrimRight(value, " ")
+	}
+
+	if name == "/dev/null" {
+		return ""
+	}
+	return stripComponent(name)
+}
+
+func stripComponent(name string) string {
+	if idx := strings.IndexByte(name, '/'); idx != -1 {
+		return name[idx+1:]
+	}
+	return name
+}
+
+// gitHeaderNames extracts both names of "diff --git" line.
+func gitHeaderNames(header string) (string, string) {
+	if strings.HasPrefix(header, `"`) {
+		end := quotedEnd(header)
+		oldName := unquotePath(header[:end])
+		newName := strings.TrimSpace(header[end:])
+		if strings.HasPrefix(newName, `"`) {
+			newName = unquotePath(newName)
+		}
+		return stripComponent(oldName), stripComponent(newName)
+	}
+
+	if idx := strings.Index(header, ` "`); idx != -1 {
+		return stripComponent(header[:idx]), stripComponent(unquotePath(header[idx+1:]))
+	}
+
+	// names are the same unless it is a rename, which has its own lines
+	if n := (len(header) - 1) / 2; len(header)%2 == 1 && header[n] == ' ' &&
+		stripComponent(header[:n]) == stripComponent(header[n+1:]) {
+		return stripComponent(header[:n]), stripComponent(header[n+1:])
+	}
+
+	if idx := strings.Index(header, " b/"); idx != -1 {
+		return stripComponent(header[:idx]), stripComponent(header[idx+1:])
+	}
+	fields := strings.SplitN(header, " ", 2)
+	if len(fields) < 2 {
+		return stripComponent(header), stripComponent(header)
+	}
+	return stripComponent(fields[0]), stripComponent(fields[1])
+}
+
+// quotedEnd returns index after closing quote of quoted string at the start.
+func quotedEnd(value string) int {
+	for idx := 1; idx < len(value); idx++ {
+		switch value[idx] {
+		case '\\':
+			idx++
+		case '"':
+			return idx + 1
+		}
+	}
+	return len(value)
+}
+
+// unquotePath reverts quotePath, names without quotes are returned as is.
+func unquotePath(value string) string {
+	if !strings.HasPrefix(value, `"`) {
+		return value
+	}
+	value = value[1:quotedEnd(value)]
+	value = strings.TrimSuffix(value, `"`)
+
+	buf := &bytes.Buffer{}
+	for idx := 0; idx < len(value); idx++ {
+		ch := value[idx]
+		if ch != '\\' || idx+1 == len(value) {
+			buf.WriteByte(ch)
+			continue
+		}
+
+		idx++
+		switch ch = value[idx]; ch {
+		case 'a':
+			buf.WriteByte('\a')
+		case 'b':
+			buf.WriteByte('\b')
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'v':
+			buf.WriteByte('\v')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'r':
+			buf.WriteByte('\r')
+		case '0', '1', '2', '3':
+			if idx+2 < len(value) {
+				if code, err := strconv.ParseUint(value[idx:idx+3], 8, 8); err == nil {
+					buf.WriteByte(byte(code))
+					idx += 2
+					continue
+				}
+			}
+			buf.WriteByte(ch)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pathSpec filters paths the way git pathspecs do. A plain entry matches the
+// path itself and everything below it. An entry with wildcards (*, ? or [)
+// is matched against the whole path as a fnmatch pattern, where * also
+// matches slashes. Empty pathspec matches everything.
+type pathSpec []string
+
+func hasWildcard(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// globCacheSize is the number of compiled patterns kept around. Patterns
+// come from requests, so the cache is bounded.
+const globCacheSize = 256
+
+var globCache = newLRUCache(globCacheSize)
+
+// compileGlob translates fnmatch pattern into regular expression.
+func compileGlob(pattern string) *regexp.Regexp {
+	if re, ok := globCache.Get(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	expr := &strings.Builder{}
+	expr.WriteString("^")
+	for idx := 0; idx < len(pattern); idx++ {
+		switch ch := pattern[idx]; ch {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[idx+1:], ']')
+			if end == -1 {
+				expr.WriteString(`\[`)
+				continue
+			}
+
+			class := pattern[idx+1 : idx+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			idx += end + 1
+		case '\\':
+			if idx+1 < len(pattern) {
+				idx++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[idx : idx+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		// broken class, match pattern literally
+		re = regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+	}
+
+	globCache.Set(pattern, re)
+	return re
+}
+
+// matches reports whether relpath is selected by the pathspec.
+func (ps pathSpec) matches(relpath string) bool {
+	if len(ps) == 0 {
+		return true
+	}
+
+	for _, spec := range ps {
+		spec = strings.Trim(spec, "/")
+		if spec == "" || relpath == spec || strings.HasPrefix(relpath, spec+"/") {
+			return true
+		}
+
+		if hasWildcard(spec) && compileGlob(spec).MatchString(relpath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayMatchUnder reports whether any path inside directory dir can be
+// selected, so walkers can skip whole subtrees.
+func (ps pathSpec) mayMatchUnder(dir string) bool {
+	if len(ps) == 0 {
+		return true
+	}
+
+	dir += "/"
+	for _, spec := range ps {
+		spec = strings.Trim(spec, "/")
+		if hasWildcard(spec) {
+			// only the part before the first wildcard is fixed
+			spec = spec[:strings.IndexAny(spec, "*?[")]
+		} else {
+			spec += "/"
+		}
+
+		if strings.HasPrefix(dir, spec) || strings.HasPrefix(spec, dir) {
+			return true
+		}
+	}
+
+	return false
+}
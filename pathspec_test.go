@@ -0,0 +1,44 @@
+package git
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPathSpecMatches(t *testing.T) {
+	tests := []struct {
+		spec    pathSpec
+		path    string
+		matches bool
+	}{
+		{nil, "any/path", true},
+		{pathSpec{"dir"}, "dir/file", true},
+		{pathSpec{"dir/"}, "dir", true},
+		{pathSpec{"dir"}, "dirt", false},
+		{pathSpec{"*.go"}, "sub/main.go", true},
+		{pathSpec{"src/?.c"}, "src/a.c", true},
+		{pathSpec{"src/?.c"}, "src/ab.c", false},
+		{pathSpec{"[!a]*"}, "abc", false},
+		{pathSpec{"[!a]*"}, "bcd", true},
+		{pathSpec{`\*`}, "*", true},
+		{pathSpec{"[z-a]"}, "[z-a]", true},
+	}
+
+	for _, test := range tests {
+		if got := test.spec.matches(test.path); got != test.matches {
+			t.Errorf("%q matching %q: got %v", test.spec, test.path, got)
+		}
+	}
+}
+
+func TestGlobCacheBounded(t *testing.T) {
+	for idx := 0; idx < 2*globCacheSize; idx++ {
+		compileGlob(fmt.Sprintf("*.%d", idx))
+	}
+	if n := globCache.order.Len(); n > globCacheSize {
+		t.Errorf("got %d cached patterns, want at most %d", n, globCacheSize)
+	}
+	if !compileGlob("*.1").MatchString("x.1") {
+		t.Error("evicted pattern is not compiled again")
+	}
+}
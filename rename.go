@@ -0,0 +1,85 @@
+package git
+
+import (
+	"hash/fnv"
+)
+
+// DefaultRenameThreshold is the similarity score (in percents) a pair of
+// files must reach to be considered a rename, same as git's default -M50%.
+var DefaultRenameThreshold = 50
+
+// similarityChunkSize limits chunk length for data without newlines.
+const similarityChunkSize = 64
+
+// maxRenameScore is the similarity score of identical files. Scores are
+// kept this precise so that close candidates are ordered the same way as
+// in git.
+const maxRenameScore = 60000
+
+// similarity estimates how much of src survived in dst, in percents.
+func similarity(src, dst []byte) int {
+	return similarityScore(src, dst) * 100 / maxRenameScore
+}
+
+// similarityScore estimates how much of src survived in dst, in
+// maxRenameScore units. Like git's diffcore-delta it splits both sides into
+// line chunks, hashes them and counts bytes present on both sides.
+func similarityScore(src, dst []byte) int {
+	maxSize := len(src)
+	if len(dst) > maxSize {
+		maxSize = len(dst)
+	}
+	if maxSize == 0 {
+		return maxRenameScore
+	}
+
+	srcChunks := hashChunks(src)
+	dstChunks := hashChunks(dst)
+
+	var common int
+	for hash, srcCount := range srcChunks {
+		dstCount := dstChunks[hash]
+		if dstCount < srcCount {
+			common += dstCount
+		} else {
+			common += srcCount
+		}
+	}
+
+	return int(int64(common) * maxRenameScore / int64(maxSize))
+}
+
+// sizesCouldMatch rejects pairs which can not reach the threshold because of
+// size difference alone.
+func sizesCouldMatch(srcSize, dstSize int64, threshold int) bool {
+	minSize, maxSize := srcSize, dstSize
+	if minSize > maxSize {
+		minSize, maxSize = maxSize, minSize
+	}
+	if maxSize == 0 {
+		return true
+	}
+
+	return minSize*100/maxSize >= int64(threshold)
+}
+
+// hashChunks maps chunk hashes to total number of bytes in those chunks.
+func hashChunks(data []byte) map[uint32]int {
+	chunks := map[uint32]int{}
+	for len(data) > 0 {
+		n := 0
+		for n < len(data) && n < similarityChunkSize {
+			n++
+			if data[n-1] == '\n' {
+				break
+			}
+		}
+
+		h := fnv.New32a()
+		h.Write(data[:n])
+		chunks[h.Sum32()] += n
+		data = data[n:]
+	}
+
+	return chunks
+}
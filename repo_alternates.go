@@ -0,0 +1,362 @@
+package git
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mechmind/git-go/git"
+)
+
+// Object alternates: a repository borrows objects missing in it from object
+// directories listed in objects/info/alternates, one per line, and in
+// GIT_ALTERNATE_OBJECT_DIRECTORIES. Forks use them to share objects with the
+// repository they were forked from instead of copying them.
+
+const (
+	alternatesEnv = "GIT_ALTERNATE_OBJECT_DIRECTORIES"
+	// maxAlternateDepth limits chains of alternates the same way git does
+	maxAlternateDepth = 5
+)
+
+func (repo *Repository) alternatesPath() string {
+	return filepath.Join(repo.objectsDir(), "info", "alternates")
+}
+
+// alternateDirs returns object directories the repository borrows from.
+// The environment only applies to the repository opened directly.
+func (repo *Repository) alternateDirs(depth int) ([]string, error) {
+	data, err := ioutil.ReadFile(repo.alternatesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '"' {
+			if unquoted, err := strconv.Unquote(line); err == nil {
+				line = unquoted
+			}
+		}
+
+		// relative paths are relative to the objects directory
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(repo.objectsDir(), line)
+		}
+		dirs = append(dirs, filepath.Clean(line))
+	}
+
+	if depth == 0 {
+		for _, dir := range filepath.SplitList(os.Getenv(alternatesEnv)) {
+			if dir == "" {
+				continue
+			}
+			if dir, err = filepath.Abs(dir); err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// openAlternates opens repositories of alternate object directories. As git
+// only warns about broken alternates, they are skipped.
+func (repo *Repository) openAlternates(depth int) ([]*Repository, error) {
+	if depth >= maxAlternateDepth {
+		log("ignoring alternates of %s: nesting is too deep", repo.Path)
+		return nil, nil
+	}
+
+	dirs, err := repo.alternateDirs(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{filepath.Clean(repo.objectsDir()): true}
+	alternates := []*Repository{}
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		if !isDir(dir) {
+			log("ignoring alternate object directory %s of %s", dir, repo.Path)
+			continue
+		}
+
+		other, err := openObjectDir(dir, depth+1)
+		if err != nil {
+			log("ignoring alternate object directory %s of %s: %v", dir, repo.Path, err)
+			continue
+		}
+		alternates = append(alternates, other)
+	}
+	return alternates, nil
+}
+
+// objectDirLinks are directories with an "objects" link to object
+// directories of other names, git-go opens object directories as part of a
+// repository only. They are made once per directory and kept until the
+// process exits.
+var objectDirLinks = struct {
+	sync.Mutex
+	root  string
+	paths map[string]string
+}{paths: map[string]string{}}
+
+// openObjectDir opens an alternate object directory. Like git, it accepts
+// any directory, not only objects directories of other repositories.
+func openObjectDir(dir string, depth int) (*Repository, error) {
+	if filepath.Base(dir) == "objects" {
+		return openRepository(filepath.Dir(dir), dir, depth)
+	}
+
+	path, err := objectDirLink(dir)
+	if err != nil {
+		return nil, err
+	}
+	return openRepository(path, dir, depth)
+}
+
+// objectDirLink returns directory with an "objects" link to dir.
+func objectDirLink(dir string) (string, error) {
+	objectDirLinks.Lock()
+	defer objectDirLinks.Unlock()
+
+	if path, ok := objectDirLinks.paths[dir]; ok {
+		return path, nil
+	}
+
+	if objectDirLinks.root == "" {
+		root, err := ioutil.TempDir("", "git-module-alternates")
+		if err != nil {
+			return "", err
+		}
+		objectDirLinks.root = root
+	}
+
+	path := filepath.Join(objectDirLinks.root, strconv.Itoa(len(objectDirLinks.paths)))
+	if err := os.Mkdir(path, 0700); err != nil {
+		return "", err
+	}
+	if err := os.Symlink(dir, filepath.Join(path, "objects")); err != nil {
+		return "", err
+	}
+	objectDirLinks.paths[dir] = path
+	return path, nil
+}
+
+// ForkRepository creates a bare repository at dst which borrows objects of
+// the repository at src through alternates instead of copying them, the
+// same way `git clone --bare --shared` does. Branches, tags and HEAD are
+// copied.
+func ForkRepository(src, dst string) error {
+	source, err := OpenRepository(src)
+	if err != nil {
+		return err
+	}
+
+	if entries, err := ioutil.ReadDir(dst); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path %s already exists and is not an empty directory", dst)
+	}
+
+	if err = InitRepository(dst, true); err != nil {
+		return err
+	}
+
+	objectsDir, err := filepath.Abs(source.objectsDir())
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dst, "objects", "info", "alternates"), []byte(objectsDir+"\n"), 0644); err != nil {
+		return err
+	}
+
+	fork, err := OpenRepository(dst)
+	if err != nil {
+		return err
+	}
+
+	branches, err := source.GetBranches()
+	if err != nil {
+		return err
+	}
+	for _, branch := range branches {
+		oid, err := source.repo.ResolveBranch(branch)
+		if err != nil {
+			return err
+		}
+		if err = fork.repo.WriteRef(BRANCH_PREFIX+branch, oid.String()); err != nil {
+			return err
+		}
+	}
+
+	tags, err := source.GetTags()
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		oid, err := source.repo.ResolveRef(TAG_PREFIX + tag)
+		if err != nil {
+			return err
+		}
+		if err = fork.repo.WriteRef(TAG_PREFIX+tag, oid.String()); err != nil {
+			return err
+		}
+	}
+
+	head, err := source.repo.ReadRef("HEAD")
+	if err != nil {
+		return err
+	}
+	return fork.repo.WriteRef("HEAD", head)
+}
+
+// Dissociate copies objects borrowed through alternates into the repository
+// and stops borrowing them, so the repository survives removal of the ones
+// it borrowed from. Loose objects and packs are hard linked where possible.
+func (repo *Repository) Dissociate() error {
+	objectsDir := repo.objectsDir()
+	for _, dir := range repo.alternateObjectDirs() {
+		if err := copyObjectFiles(dir, objectsDir); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(repo.alternatesPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// copied packs are only seen once the repository is reopened
+	raw, err := git.OpenRepository(repo.Path)
+	if err != nil {
+		return err
+	}
+	repo.repo = raw
+	repo.alternates = nil
+	return nil
+}
+
+// alternateObjectDirs returns object directories of all alternates,
+// including alternates of alternates.
+func (repo *Repository) alternateObjectDirs() []string {
+	seen := map[string]bool{filepath.Clean(repo.objectsDir()): true}
+	dirs := []string{}
+
+	var collect func(alternates []*Repository)
+	collect = func(alternates []*Repository) {
+		for _, other := range alternates {
+			dir := filepath.Clean(other.objectsDir())
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			dirs = append(dirs, dir)
+			collect(other.alternates)
+		}
+	}
+	collect(repo.alternates)
+
+	return dirs
+}
+
+// copyObjectFiles puts loose objects and packs of object directory src into
+// dst, files dst has already are kept. Packs go before their indexes, so
+// readers never see an index without its pack.
+func copyObjectFiles(src, dst string) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if _, err := hex.DecodeString(entry.Name()); err != nil || len(entry.Name()) != 2 || !entry.IsDir() {
+			continue
+		}
+
+		objects, err := ioutil.ReadDir(filepath.Join(src, entry.Name()))
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			relpath := filepath.Join(entry.Name(), object.Name())
+			if err = linkOrCopy(filepath.Join(src, relpath), filepath.Join(dst, relpath)); err != nil {
+				return err
+			}
+		}
+	}
+
+	packs, err := ioutil.ReadDir(filepath.Join(src, "pack"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, ext := range []string{".pack", ".idx"} {
+		for _, pack := range packs {
+			if filepath.Ext(pack.Name()) != ext {
+				continue
+			}
+			relpath := filepath.Join("pack", pack.Name())
+			if err = linkOrCopy(filepath.Join(src, relpath), filepath.Join(dst, relpath)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// linkOrCopy hard links file src to dst, or copies it if it cannot be
+// linked. Existing dst is kept.
+func linkOrCopy(src, dst string) error {
+	if isExist(dst) {
+		return nil
+	}
+
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0444)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
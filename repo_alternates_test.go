@@ -0,0 +1,109 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFile reads content of relpath in the tree of commit id.
+func readFile(t *testing.T, repo *Repository, id sha1, relpath string) string {
+	node, err := repo.loadWalkNode(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := repo.lookupPath(node.tree, relpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := repo.readBlob(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestForkRepositoryDissociate(t *testing.T) {
+	src := newTestRepository(t)
+	id := testCommitFiles(t, src, map[string]string{"dir/a": "a\n"}, 100)
+	if err := src.repo.WriteRef(BRANCH_PREFIX+"master", id.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "git-module-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	dst := filepath.Join(dir, "fork.git")
+
+	if err = ForkRepository(src.Path, dst); err != nil {
+		t.Fatal(err)
+	}
+	fork, err := OpenRepository(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// objects are borrowed, not copied
+	if _, _, err = fork.repo.StatObject(sha2oidp(id)); err == nil {
+		t.Fatal("fork has its own copy of the commit")
+	}
+	if got := readFile(t, fork, id, "dir/a"); got != "a\n" {
+		t.Fatalf("got content %q through alternates", got)
+	}
+	if ref, err := fork.repo.ReadRef(BRANCH_PREFIX + "master"); err != nil || ref != id.String() {
+		t.Fatalf("got branch %q, %v", ref, err)
+	}
+
+	if err = fork.Dissociate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(src.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err = OpenRepository(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fork.alternates) != 0 {
+		t.Errorf("fork still has %d alternates", len(fork.alternates))
+	}
+	if got := readFile(t, fork, id, "dir/a"); got != "a\n" {
+		t.Errorf("got content %q after dissociate", got)
+	}
+}
+
+func TestAlternateObjectDirName(t *testing.T) {
+	src := newTestRepository(t)
+	id := testCommitFiles(t, src, map[string]string{"a": "a\n"}, 100)
+
+	// an object directory of any name can be borrowed from
+	dir, err := ioutil.TempDir("", "git-module-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store := filepath.Join(dir, "store")
+	if err = copyObjectFiles(src.objectsDir(), store); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newTestRepository(t)
+	if err = ioutil.WriteFile(repo.alternatesPath(), []byte(store+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err = OpenRepository(repo.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.alternates) != 1 || repo.alternates[0].objectsDir() != store {
+		t.Fatalf("got alternates %v, want %s", repo.alternates, store)
+	}
+	if got := readFile(t, repo, id, "a"); got != "a\n" {
+		t.Errorf("got content %q through alternates", got)
+	}
+}
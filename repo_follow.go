@@ -0,0 +1,111 @@
+package git
+
+import (
+	"container/list"
+	"io"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// FileCommit is a commit found while following history of a single file.
+// Path is the name the file had in that commit, it differs from requested
+// one for commits made before the file was renamed.
+type FileCommit struct {
+	*Commit
+	Path string
+}
+
+// newFollowWalk prepares walk tracing file across renames starting at revision.
+func (repo *Repository) newFollowWalk(revision, file string) (*revWalk, error) {
+	oid, err := rawgit.ResolveName(repo.repo, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	walk := newRevWalk(repo)
+	walk.limitToPath(file, true)
+	if err = walk.push(sha1(*oid)); err != nil {
+		return nil, err
+	}
+
+	return walk, nil
+}
+
+// FileCommitsCountFollow is like FileCommitsCount, but also counts commits
+// made to the file under its previous names.
+func (repo *Repository) FileCommitsCountFollow(revision, file string) (int64, error) {
+	walk, err := repo.newFollowWalk(revision, file)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for {
+		_, err = walk.next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
+
+// CommitsByFileAndRangeFollow is like CommitsByFileAndRange, but follows
+// the file across renames. List elements are *FileCommit.
+func (repo *Repository) CommitsByFileAndRangeFollow(revision, file string, page int) (*list.List, error) {
+	walk, err := repo.newFollowWalk(revision, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.followPage(walk, (page-1)*CommitsRangeSize, CommitsRangeSize)
+}
+
+func (repo *Repository) followPage(walk *revWalk, skip, limit int) (*list.List, error) {
+	result := list.New()
+	for result.Len() < limit {
+		node, err := walk.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		commit, err := node.commit(repo)
+		if err != nil {
+			return nil, err
+		}
+
+		result.PushBack(&FileCommit{Commit: commit, Path: node.path})
+	}
+
+	return result, nil
+}
+
+// GetCommitByPathFollow returns the last commit of relpath object along with
+// the path it had in that commit.
+func (c *Commit) GetCommitByPathFollow(relpath string) (*FileCommit, error) {
+	walk := newRevWalk(c.repo)
+	walk.limitToPath(relpath, true)
+	if err := walk.push(c.ID); err != nil {
+		return nil, err
+	}
+
+	result, err := c.repo.followPage(walk, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Len() == 0 {
+		// no entries
+		return nil, nil
+	}
+
+	return result.Front().Value.(*FileCommit), nil
+}
@@ -0,0 +1,145 @@
+package git
+
+import (
+	"io"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// GraphEdge connects a column of one graph row to a column of the next row.
+// Parent is the commit the line is heading to, it may lie beyond the
+// requested limit.
+type GraphEdge struct {
+	From   int
+	To     int
+	Parent sha1
+}
+
+// GraphRow is a layout of a single commit in the commit graph.
+type GraphRow struct {
+	Commit *Commit
+	// Column of the commit itself.
+	Column int
+	// Lanes lists columns of lines passing through the row without touching
+	// the commit.
+	Lanes []int
+	// Edges lists lines going from this row down to the next one, including
+	// lines from the commit to its parents.
+	Edges []GraphEdge
+	// Width is the number of columns used by the row.
+	Width int
+}
+
+// graphLayout assigns columns to commits coming from the walk.
+type graphLayout struct {
+	// lanes holds commits awaited by each column, zero id means free column
+	lanes []sha1
+	prev  *GraphRow
+}
+
+func (l *graphLayout) allocate(id sha1, after int) int {
+	for idx := after; idx < len(l.lanes); idx++ {
+		if l.lanes[idx] == (sha1{}) {
+			l.lanes[idx] = id
+			return idx
+		}
+	}
+
+	l.lanes = append(l.lanes, id)
+	return len(l.lanes) - 1
+}
+
+func (l *graphLayout) find(id sha1) int {
+	for idx, awaited := range l.lanes {
+		if awaited == id {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (l *graphLayout) place(node *walkNode) *GraphRow {
+	row := &GraphRow{Column: l.find(node.id)}
+	if row.Column == -1 {
+		row.Column = l.allocate(node.id, 0)
+	}
+
+	// lines coming from the previous row join the commit
+	if l.prev != nil {
+		for idx := range l.prev.Edges {
+			if l.prev.Edges[idx].Parent == node.id {
+				l.prev.Edges[idx].To = row.Column
+			}
+		}
+	}
+
+	for idx, awaited := range l.lanes {
+		if awaited == node.id {
+			l.lanes[idx] = sha1{}
+		} else if awaited != (sha1{}) {
+			row.Lanes = append(row.Lanes, idx)
+			row.Edges = append(row.Edges, GraphEdge{From: idx, To: idx, Parent: awaited})
+		}
+	}
+
+	for pidx, parent := range node.parents {
+		column := l.find(parent)
+		if column == -1 {
+			if pidx == 0 && l.lanes[row.Column] == (sha1{}) {
+				column = row.Column
+				l.lanes[column] = parent
+			} else {
+				column = l.allocate(parent, row.Column+1)
+			}
+		}
+		row.Edges = append(row.Edges, GraphEdge{From: row.Column, To: column, Parent: parent})
+	}
+
+	for len(l.lanes) > 0 && l.lanes[len(l.lanes)-1] == (sha1{}) {
+		l.lanes = l.lanes[:len(l.lanes)-1]
+	}
+
+	row.Width = len(l.lanes)
+	if row.Column >= row.Width {
+		row.Width = row.Column + 1
+	}
+
+	l.prev = row
+	return row
+}
+
+// CommitGraph lays out history of given refs for drawing a commit graph like
+// `git log --graph`. It returns at most limit rows, in the same order as
+// CommitsByRange walks commits.
+func (repo *Repository) CommitGraph(refs []string, limit int) ([]*GraphRow, error) {
+	walk := newRevWalk(repo)
+	for _, ref := range refs {
+		oid, err := rawgit.ResolveName(repo.repo, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = walk.push(sha1(*oid)); err != nil {
+			return nil, err
+		}
+	}
+
+	layout := &graphLayout{}
+	rows := []*GraphRow{}
+	for len(rows) < limit {
+		node, err := walk.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		row := layout.place(node)
+		if row.Commit, err = node.commit(repo); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
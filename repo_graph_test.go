@@ -0,0 +1,90 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+type graphRowWant struct {
+	commit sha1
+	column int
+	lanes  []int
+	edges  []GraphEdge
+	width  int
+}
+
+func checkGraph(t *testing.T, rows []*GraphRow, want []graphRowWant) {
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for idx, row := range rows {
+		w := want[idx]
+		if row.Commit.ID != w.commit || row.Column != w.column || row.Width != w.width {
+			t.Errorf("row %d: got commit %s at column %d of %d, want %s at %d of %d",
+				idx, row.Commit.ID, row.Column, row.Width, w.commit, w.column, w.width)
+		}
+		if len(row.Lanes) != len(w.lanes) || (len(w.lanes) > 0 && !reflect.DeepEqual(row.Lanes, w.lanes)) {
+			t.Errorf("row %d: got lanes %v, want %v", idx, row.Lanes, w.lanes)
+		}
+		if len(row.Edges) != len(w.edges) || (len(w.edges) > 0 && !reflect.DeepEqual(row.Edges, w.edges)) {
+			t.Errorf("row %d: got edges %v, want %v", idx, row.Edges, w.edges)
+		}
+	}
+}
+
+func TestCommitGraphMerge(t *testing.T) {
+	repo := newTestRepository(t)
+
+	root := testCommitFiles(t, repo, map[string]string{"file": "root"}, 100)
+	left := testCommitFiles(t, repo, map[string]string{"file": "left"}, 200, root)
+	right := testCommitFiles(t, repo, map[string]string{"file": "right"}, 300, root)
+	merge := testCommitFiles(t, repo, map[string]string{"file": "merge"}, 400, left, right)
+
+	rows, err := repo.CommitGraph([]string{merge.String()}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// * merge
+	// |\
+	// | * right
+	// * | left
+	// |/
+	// * root, drawn where the last line to it ends
+	checkGraph(t, rows, []graphRowWant{
+		{merge, 0, nil, []GraphEdge{{0, 0, left}, {0, 1, right}}, 2},
+		{right, 1, []int{0}, []GraphEdge{{0, 0, left}, {1, 1, root}}, 2},
+		{left, 0, []int{1}, []GraphEdge{{1, 1, root}, {0, 1, root}}, 2},
+		{root, 1, nil, nil, 2},
+	})
+
+	if rows, err = repo.CommitGraph([]string{merge.String()}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1].Edges[1].Parent != root {
+		t.Errorf("limited graph: got %d rows", len(rows))
+	}
+}
+
+func TestCommitGraphCrissCross(t *testing.T) {
+	repo := newTestRepository(t)
+
+	root := testCommitFiles(t, repo, map[string]string{"file": "root"}, 100)
+	a := testCommitFiles(t, repo, map[string]string{"file": "a"}, 200, root)
+	b := testCommitFiles(t, repo, map[string]string{"file": "b"}, 300, root)
+	c := testCommitFiles(t, repo, map[string]string{"file": "c"}, 400, a, b)
+	d := testCommitFiles(t, repo, map[string]string{"file": "d"}, 500, b, a)
+
+	rows, err := repo.CommitGraph([]string{c.String(), d.String()}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGraph(t, rows, []graphRowWant{
+		{d, 0, nil, []GraphEdge{{0, 0, b}, {0, 1, a}}, 2},
+		{c, 2, []int{0, 1}, []GraphEdge{{0, 0, b}, {1, 1, a}, {2, 1, a}, {2, 0, b}}, 3},
+		{b, 0, []int{1}, []GraphEdge{{1, 1, a}, {0, 0, root}}, 2},
+		{a, 1, []int{0}, []GraphEdge{{0, 0, root}, {1, 0, root}}, 2},
+		{root, 0, nil, nil, 1},
+	})
+}
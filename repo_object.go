@@ -0,0 +1,195 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// raw object access helpers shared by native walkers and diff code
+
+// gitDir returns path to the git directory of the repository, either bare
+// or with working directory.
+func (repo *Repository) gitDir() string {
+	if isDir(filepath.Join(repo.Path, "objects")) {
+		return repo.Path
+	}
+	return filepath.Join(repo.Path, ".git")
+}
+
+// objectsDir returns path to object database of the repository.
+func (repo *Repository) objectsDir() string {
+	if repo.objectDir != "" {
+		return repo.objectDir
+	}
+	return filepath.Join(repo.gitDir(), "objects")
+}
+
+// withFallback returns a handle of the repository which reads objects
+// missing in it from other. Neither repository is changed.
+func (repo *Repository) withFallback(other *Repository) *Repository {
+	return &Repository{
+		Path:       repo.Path,
+		repo:       repo.repo,
+		statsCache: repo.statsCache,
+		graph:      repo.graph,
+		fallback:   other,
+		overlay:    repo.overlay,
+		alternates: repo.alternates,
+		objectDir:  repo.objectDir,
+	}
+}
+
+// borrowed returns repositories read for objects missing in the repository,
+// the fallback one first, then alternates.
+func (repo *Repository) borrowed() []*Repository {
+	if repo.fallback == nil {
+		return repo.alternates
+	}
+	return append([]*Repository{repo.fallback}, repo.alternates...)
+}
+
+// objectOwner returns the repository storing the object, which is either
+// the repository itself or one it borrows objects from. It returns nil if
+// there is no such object.
+func (repo *Repository) objectOwner(id sha1) *Repository {
+	if repo.overlayObject(id) != nil {
+		return repo
+	}
+	if _, _, err := repo.repo.StatObject(sha2oidp(id)); err == nil {
+		return repo
+	}
+	for _, other := range repo.borrowed() {
+		if owner := other.objectOwner(id); owner != nil {
+			return owner
+		}
+	}
+	return nil
+}
+
+// openRawCommit parses commit object. Commits kept in the memory overlay
+// have no parsed form, they are read by loadWalkNode only.
+func (repo *Repository) openRawCommit(id sha1) (*rawgit.Commit, error) {
+	if repo.overlayObject(id) != nil {
+		return nil, fmt.Errorf("commit %s is not stored yet", id)
+	}
+
+	raw, err := repo.repo.OpenCommit(sha2oidp(id))
+	if err != nil {
+		for _, other := range repo.borrowed() {
+			if raw, otherErr := other.openRawCommit(id); otherErr == nil {
+				return raw, nil
+			}
+		}
+	}
+	return raw, err
+}
+
+// openRawTree parses tree object. Trees kept in the memory overlay are
+// read by readTreeEntries instead.
+func (repo *Repository) openRawTree(id sha1) (*rawgit.Tree, error) {
+	if repo.overlayObject(id) != nil {
+		return nil, fmt.Errorf("tree %s is not stored yet", id)
+	}
+
+	raw, err := repo.repo.OpenTree(sha2oidp(id))
+	if err != nil {
+		for _, other := range repo.borrowed() {
+			if raw, otherErr := other.openRawTree(id); otherErr == nil {
+				return raw, nil
+			}
+		}
+	}
+	return raw, err
+}
+
+// readBlob reads the whole object content into memory.
+func (repo *Repository) readBlob(id sha1) ([]byte, error) {
+	if object := repo.overlayObject(id); object != nil {
+		return object.data, nil
+	}
+
+	_, body, err := repo.repo.OpenObject(sha2oidp(id))
+	if err != nil {
+		for _, other := range repo.borrowed() {
+			if data, otherErr := other.readBlob(id); otherErr == nil {
+				return data, nil
+			}
+		}
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// blobSize returns size of the object without reading its content.
+func (repo *Repository) blobSize(id sha1) (int64, error) {
+	if object := repo.overlayObject(id); object != nil {
+		return int64(len(object.data)), nil
+	}
+
+	info, _, err := repo.repo.StatObject(sha2oidp(id))
+	if err != nil {
+		for _, other := range repo.borrowed() {
+			if size, otherErr := other.blobSize(id); otherErr == nil {
+				return size, nil
+			}
+		}
+		return 0, err
+	}
+
+	return int64(info.Size), nil
+}
+
+func rawTreeID(raw *rawgit.Commit) sha1 {
+	return sha1(*raw.TreeOID)
+}
+
+// pathEntry is a tree entry resolved by its full path.
+type pathEntry struct {
+	ID   sha1
+	Mode EntryMode
+}
+
+func (e *pathEntry) isDir() bool {
+	return e.Mode == ENTRY_MODE_TREE
+}
+
+func sameEntry(a, b *pathEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID && a.Mode == b.Mode
+}
+
+// lookupPath resolves relpath inside the tree treeID. It returns nil entry
+// if there is no such path.
+func (repo *Repository) lookupPath(treeID sha1, relpath string) (*pathEntry, error) {
+	relpath = strings.Trim(path.Clean(relpath), "/")
+	if relpath == "" || relpath == "." {
+		return &pathEntry{ID: treeID, Mode: ENTRY_MODE_TREE}, nil
+	}
+
+	cur := &pathEntry{ID: treeID, Mode: ENTRY_MODE_TREE}
+	for _, name := range strings.Split(relpath, "/") {
+		if !cur.isDir() {
+			return nil, nil
+		}
+
+		entries, err := repo.readTreeEntries(cur.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if cur = entries[name]; cur == nil {
+			return nil, nil
+		}
+	}
+
+	return cur, nil
+}
@@ -4,68 +4,121 @@
 
 package git
 
-import "container/list"
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+)
 
 // PullRequestInfo represents needed information for a pull request.
 type PullRequestInfo struct {
 	MergeBase string
 	Commits   *list.List
 	NumFiles  int
-}
-
-// GetMergeBase checks and returns merge base of two branches.
-func (repo *Repository) GetMergeBase(base, head string) (string, error) {
-	panic("not implemented!")
-	//stdout, err := NewCommand("merge-base", base, head).RunInDir(repo.Path)
-	//return strings.TrimSpace(stdout), err
-	return "", nil
+	// Files are changes of the head branch since the merge base
+	Files []*FileStats
 }
 
 // GetPullRequestInfo generates and returns pull request information
-// between base and head branches of repositories.
+// between base and head branches of repositories. The base branch is read
+// from repository at basePath, which may be the same repository or a fork,
+// objects of it are looked up directly and neither repository is changed.
 func (repo *Repository) GetPullRequestInfo(basePath, baseBranch, headBranch string) (_ *PullRequestInfo, err error) {
-	// Add a temporary remote
-	/*
-		tmpRemote := strconv.FormatInt(time.Now().UnixNano(), 10)
-		if err = repo.AddRemote(tmpRemote, basePath, true); err != nil {
-			return nil, fmt.Errorf("AddRemote: %v", err)
-		}
-		defer func() {
-			repo.RemoveRemote(tmpRemote)
-		}()
+	baseRepo, err := OpenRepository(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRepository: %v", err)
+	}
 
-		remoteBranch := "remotes/" + tmpRemote + "/" + baseBranch
-		prInfo := new(PullRequestInfo)
-		prInfo.MergeBase, err = repo.GetMergeBase(remoteBranch, headBranch)
-		if err != nil {
-			return nil, fmt.Errorf("GetMergeBase: %v", err)
-		}
+	baseID, err := baseRepo.resolveRevision(BRANCH_PREFIX + baseBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	headID, err := repo.resolveRevision(BRANCH_PREFIX + headBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	// merge base is an ancestor of head, everything else is read from the
+	// head repository itself
+	view := repo.withFallback(baseRepo)
+	bases, err := view.mergeBases(baseID, []sha1{headID})
+	if err != nil {
+		return nil, fmt.Errorf("GetMergeBase: %v", err)
+	}
+	if len(bases) == 0 {
+		return nil, ErrNoMergeBase{Revisions: []string{baseBranch, headBranch}}
+	}
+
+	prInfo := &PullRequestInfo{MergeBase: bases[0].String()}
+	if prInfo.Commits, err = repo.commitsSince(bases[0], headID); err != nil {
+		return nil, err
+	}
+
+	baseTree, err := repo.revisionTree(prInfo.MergeBase)
+	if err != nil {
+		return nil, err
+	}
 
-		logs, err := NewCommand("log", prInfo.MergeBase+"..."+headBranch, _PRETTY_LOG_FORMAT).RunInDirBytes(repo.Path)
+	headTree, err := repo.revisionTree(headID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := repo.diffTrees(baseTree, headTree, DiffTreeOptions{DetectRenames: true})
+	if err != nil {
+		return nil, err
+	}
+
+	prInfo.NumFiles = len(changes)
+	prInfo.Files = make([]*FileStats, 0, len(changes))
+	for _, change := range changes {
+		file, err := repo.fileStats(change)
 		if err != nil {
 			return nil, err
 		}
-		prInfo.Commits, err = repo.parsePrettyFormatLogToList(logs)
+		prInfo.Files = append(prInfo.Files, file)
+	}
+
+	return prInfo, nil
+}
+
+// commitsSince lists commits reachable from head but not from base, newest
+// first, as `git log base..head` does.
+func (repo *Repository) commitsSince(base, head sha1) (*list.List, error) {
+	walk := newRevWalk(repo)
+	if err := walk.hide(base); err != nil {
+		return nil, err
+	}
+	if err := walk.push(head); err != nil {
+		return nil, err
+	}
+
+	commits := list.New()
+	for {
+		node, err := walk.next()
+		if err == io.EOF {
+			return commits, nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("parsePrettyFormatLogToList: %v", err)
+			return nil, err
 		}
 
-		// Count number of changed files.
-		stdout, err := NewCommand("diff", "--name-only", remoteBranch+"..."+headBranch).RunInDir(repo.Path)
+		commit, err := node.commit(repo)
 		if err != nil {
 			return nil, err
 		}
-		prInfo.NumFiles = len(strings.Split(stdout, "\n")) - 1
-
-		return prInfo, nil
-	*/
-	panic("not implemented!")
-	return nil, nil
+		commits.PushBack(commit)
+	}
 }
 
 // GetPatch generates and returns patch data between given revisions.
+// Use WritePatch to avoid buffering large patches.
 func (repo *Repository) GetPatch(base, head string) ([]byte, error) {
-	//return NewCommand("diff", "-p", "--binary", base, head).RunInDirBytes(repo.Path)
-	panic("not implemented!")
-	return nil, nil
+	buf := &bytes.Buffer{}
+	if err := repo.WritePatch(buf, base, head, DefaultPatchOptions()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
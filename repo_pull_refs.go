@@ -0,0 +1,314 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Pull request refs, the way GitHub keeps them: refs/pull/<index>/head
+// points at the head commit of a pull request and refs/pull/<index>/merge
+// at a test merge of it into the base branch, so CI can fetch both.
+
+const PULL_PREFIX = "refs/pull/"
+
+func pullHeadRef(index int64) string {
+	return fmt.Sprintf("%s%d/head", PULL_PREFIX, index)
+}
+
+func pullMergeRef(index int64) string {
+	return fmt.Sprintf("%s%d/merge", PULL_PREFIX, index)
+}
+
+// UpdatePullHead points head ref of pull request index at the tip of
+// headBranch of headRepo, which is either the repository itself or a fork
+// of it. Objects the repository does not have are copied from the fork
+// first. It returns the head commit.
+func (repo *Repository) UpdatePullHead(index int64, headRepo *Repository, headBranch string) (sha1, error) {
+	headID, err := headRepo.resolveRevision(BRANCH_PREFIX + headBranch)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	if err = repo.copyObjects(headRepo, headID); err != nil {
+		return sha1{}, err
+	}
+
+	return headID, repo.repo.WriteRef(pullHeadRef(index), headID.String())
+}
+
+// UpdatePullMerge merges head ref of pull request index into baseBranch in
+// memory and points merge ref of the pull request at the merge commit. If
+// the merge has conflicts, the merge ref is removed and ErrMergeConflict
+// lists them.
+func (repo *Repository) UpdatePullMerge(index int64, baseBranch string, committer *Signature) (sha1, error) {
+	baseID, err := repo.resolveRevision(BRANCH_PREFIX + baseBranch)
+	if err != nil {
+		return sha1{}, err
+	}
+
+	headID, err := repo.resolveRevision(pullHeadRef(index))
+	if err != nil {
+		return sha1{}, err
+	}
+
+	message := fmt.Sprintf("Merge %s into %s\n", headID, baseID)
+	mergeID, err := repo.MergeCommits(baseID.String(), headID.String(), message, committer, nil, DefaultMergeOptions())
+	if IsErrMergeConflict(err) {
+		if removeErr := repo.deleteRef(pullMergeRef(index)); removeErr != nil {
+			return sha1{}, removeErr
+		}
+		return sha1{}, err
+	}
+	if err != nil {
+		return sha1{}, err
+	}
+
+	return mergeID, repo.repo.WriteRef(pullMergeRef(index), mergeID.String())
+}
+
+// RemovePullRefs deletes refs of pull request index, as done once it is
+// closed. Objects are left for garbage collection.
+func (repo *Repository) RemovePullRefs(index int64) error {
+	for _, name := range []string{pullHeadRef(index), pullMergeRef(index)} {
+		if err := repo.deleteRef(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPullRefsHidden sets whether `git upload-pack` leaves pull request refs
+// out of ref advertisement, through uploadpack.hideRefs configuration.
+// Hidden refs are not cloned, but they cannot be fetched by name either.
+func (repo *Repository) SetPullRefsHidden(hidden bool) error {
+	path := filepath.Join(repo.gitDir(), "config")
+	return updateLocked(path, func(data []byte) ([]byte, bool, error) {
+		// the setting is dropped wherever it is, then put back into the
+		// first uploadpack section if needed
+		lines := []string{}
+		section, header := "", -1
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "[") {
+				section = strings.ToLower(strings.Trim(trimmed, "[] \t"))
+				if section == "uploadpack" && header == -1 {
+					header = len(lines)
+				}
+			}
+
+			if section == "uploadpack" && isPullHideRefs(trimmed) {
+				continue
+			}
+			lines = append(lines, line)
+		}
+
+		setting := "\thideRefs = " + PULL_PREFIX
+		switch {
+		case !hidden:
+		case header == -1:
+			lines = append(lines, "[uploadpack]", setting)
+		default:
+			lines = append(lines[:header+1], append([]string{setting}, lines[header+1:]...)...)
+		}
+		return []byte(strings.Join(lines, "\n") + "\n"), true, nil
+	})
+}
+
+// isPullHideRefs tells whether config line hides pull request refs.
+func isPullHideRefs(line string) bool {
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "hiderefs") {
+		return false
+	}
+	value := strings.Trim(strings.TrimSpace(parts[1]), "\"")
+	return strings.TrimSuffix(value, "/")+"/" == PULL_PREFIX
+}
+
+// copyObjects copies commit tip and objects it reaches from src, unless the
+// repository has them already. As when git fetches, an object which is
+// there is taken to come with everything it reaches. Commits are written
+// after their parents and trees after their entries, so an interrupted
+// copy leaves nothing incomplete behind.
+func (repo *Repository) copyObjects(src *Repository, tip sha1) error {
+	// depth-first walk writing commits in post-order, once all parents of
+	// a commit are written
+	type frame struct {
+		node *walkNode
+		next int
+	}
+	stack := []*frame{}
+	seen := map[sha1]bool{}
+	visit := func(id sha1) error {
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		if repo.objectOwner(id) != nil {
+			return nil
+		}
+
+		node, err := src.loadWalkNode(id)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, &frame{node: node})
+		return nil
+	}
+
+	if err := visit(tip); err != nil {
+		return err
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next < len(top.node.parents) {
+			top.next++
+			if err := visit(top.node.parents[top.next-1]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		if err := repo.copyTree(src, top.node.tree); err != nil {
+			return err
+		}
+		if err := repo.copyObject(src, OBJECT_COMMIT, top.node.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies tree id with entries missing in the repository from src.
+func (repo *Repository) copyTree(src *Repository, id sha1) error {
+	if repo.objectOwner(id) != nil {
+		return nil
+	}
+
+	entries, err := src.readTreeEntries(id)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		switch {
+		case entry.isDir():
+			err = repo.copyTree(src, entry.ID)
+		case entry.Mode == ENTRY_MODE_COMMIT:
+			// submodule commits live in other repositories
+		case repo.objectOwner(entry.ID) == nil:
+			err = repo.copyObject(src, OBJECT_BLOB, entry.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.copyObject(src, OBJECT_TREE, id)
+}
+
+func (repo *Repository) copyObject(src *Repository, typ ObjectType, id sha1) error {
+	data, err := src.readBlob(id)
+	if err != nil {
+		return err
+	}
+
+	written, err := repo.writeObject(typ, data)
+	if err != nil {
+		return err
+	}
+	if written != id {
+		return fmt.Errorf("object %s of %s is corrupt", id, src.Path)
+	}
+	return nil
+}
+
+// deleteRef removes ref, both loose and packed. Missing ref is not an error.
+func (repo *Repository) deleteRef(name string) error {
+	gitDir := repo.gitDir()
+	path := filepath.Join(gitDir, filepath.FromSlash(name))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// directories left empty are removed, as git does
+	refsDir := filepath.Join(gitDir, "refs")
+	for dir := filepath.Dir(path); dir != refsDir && strings.HasPrefix(dir, refsDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return repo.deletePackedRef(name)
+}
+
+// deletePackedRef rewrites packed-refs without ref name and its peeled
+// value.
+func (repo *Repository) deletePackedRef(name string) error {
+	path := filepath.Join(repo.gitDir(), "packed-refs")
+	if !isFile(path) {
+		return nil
+	}
+
+	return updateLocked(path, func(data []byte) ([]byte, bool, error) {
+		buf := &bytes.Buffer{}
+		found, skipping := false, false
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			switch {
+			case line == "":
+				continue
+			case strings.HasPrefix(line, "^"):
+				if skipping {
+					continue
+				}
+			default:
+				fields := strings.Fields(line)
+				skipping = len(fields) == 2 && fields[1] == name
+				if skipping {
+					found = true
+					continue
+				}
+			}
+			buf.WriteString(line)
+		}
+		return buf.Bytes(), found, nil
+	})
+}
+
+// updateLocked rewrites the file at path holding path.lock, like git does
+// it, so that concurrent writers fail instead of losing changes of each
+// other. Update gets current content, empty if there is no file, and tells
+// whether the file has to be replaced with the returned content.
+func updateLocked(path string, update func(data []byte) ([]byte, bool, error)) error {
+	lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = nil, nil
+	}
+
+	changed := false
+	if err == nil {
+		data, changed, err = update(data)
+	}
+	if err == nil && changed {
+		_, err = lock.Write(data)
+	}
+	if closeErr := lock.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && changed {
+		err = os.Rename(lock.Name(), path)
+	}
+	if err != nil || !changed {
+		os.Remove(lock.Name())
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetPullRefsHidden(t *testing.T) {
+	repo := newTestRepository(t)
+	path := filepath.Join(repo.gitDir(), "config")
+
+	hides := func() int {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "hideRefs = "+PULL_PREFIX)
+	}
+
+	for _, hidden := range []bool{true, true, false} {
+		if err := repo.SetPullRefsHidden(hidden); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[bool]int{true: 1, false: 0}[hidden]; hides() != want {
+			t.Errorf("hidden %v: got %d settings, want %d", hidden, hides(), want)
+		}
+	}
+
+	// a config being changed by someone else is left alone
+	if err := ioutil.WriteFile(path+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetPullRefsHidden(true); err == nil {
+		t.Error("expected error while config is locked")
+	}
+	if hides() != 0 {
+		t.Error("locked config is changed")
+	}
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Error("lock of someone else is removed")
+	}
+}
+
+func TestCopyObjectsParentsFirst(t *testing.T) {
+	src := newTestRepository(t)
+	dst := newTestRepository(t)
+
+	commit := func(tree *Tree, when int64, parents ...sha1) sha1 {
+		sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+		id, err := writeCommit(src, tree.ID, parents, sig, nil, "message\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	root := commit(testTree(t, src, map[string]string{"root": "lost\n"}), 100)
+	left := commit(testTree(t, src, map[string]string{"left": "left\n"}), 200, root)
+	right := commit(testTree(t, src, map[string]string{"right": "right\n"}), 300, root)
+	merge := commit(testTree(t, src, map[string]string{"merge": "merge\n"}), 400, left, right)
+
+	// the root commit cannot be copied, so nothing depending on it may be
+	lost := hashObject(OBJECT_BLOB, []byte("lost\n")).String()
+	if err := os.Remove(filepath.Join(src.objectsDir(), lost[:2], lost[2:])); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dst.copyObjects(src, merge); err == nil {
+		t.Fatal("expected error for missing object")
+	}
+	for _, id := range []sha1{root, left, right, merge} {
+		if dst.objectOwner(id) != nil {
+			t.Errorf("commit %s is copied without its parents", id)
+		}
+	}
+}
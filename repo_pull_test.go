@@ -0,0 +1,52 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetPullRequestInfoAcrossForks(t *testing.T) {
+	baseRepo := newTestRepository(t)
+	root := testCommitFiles(t, baseRepo, map[string]string{"a": "a\n"}, 100)
+
+	headRepo := newTestRepository(t)
+	if err := copyObjectFiles(baseRepo.objectsDir(), headRepo.objectsDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the newer base commit is in the base repository only
+	upstream := testCommitFiles(t, baseRepo, map[string]string{"a": "a\n", "u": "u\n"}, 200, root)
+	if err := baseRepo.repo.WriteRef(BRANCH_PREFIX+"master", upstream.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	first := testCommitFiles(t, headRepo, map[string]string{"a": "changed\n"}, 300, root)
+	second := testCommitFiles(t, headRepo, map[string]string{"a": "changed\n", "b": "b\n"}, 400, first)
+	if err := headRepo.repo.WriteRef(BRANCH_PREFIX+"feature", second.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	baseObjects, headObjects := objectFiles(t, baseRepo), objectFiles(t, headRepo)
+	info, err := headRepo.GetPullRequestInfo(baseRepo.Path, "master", "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.MergeBase != root.String() {
+		t.Errorf("got merge base %s, want %s", info.MergeBase, root)
+	}
+	if got := commitIDs(info.Commits); !sameIDs(got, []sha1{second, first}) {
+		t.Errorf("got commits %v, want %v", got, []sha1{second, first})
+	}
+	paths := []string{}
+	for _, file := range info.Files {
+		paths = append(paths, file.Path)
+	}
+	if info.NumFiles != 2 || !reflect.DeepEqual(paths, []string{"a", "b"}) {
+		t.Errorf("got %d files %v", info.NumFiles, paths)
+	}
+
+	if !reflect.DeepEqual(objectFiles(t, baseRepo), baseObjects) || !reflect.DeepEqual(objectFiles(t, headRepo), headObjects) {
+		t.Error("objects of the repositories changed")
+	}
+}
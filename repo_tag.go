@@ -34,7 +34,12 @@ func (repo *Repository) GetTag(name string) (*Tag, error) {
 		return nil, err
 	}
 
-	info, _, err := repo.repo.StatObject(oid)
+	owner := repo.objectOwner(sha1(*oid))
+	if owner == nil {
+		return nil, ErrNotExist{oid.String(), ""}
+	}
+
+	info, _, err := owner.repo.StatObject(oid)
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +55,7 @@ func (repo *Repository) GetTag(name string) (*Tag, error) {
 	}
 
 	if info.GetOType() == rawgit.OTypeTag {
-		obj, err := repo.repo.OpenTag(oid)
+		obj, err := owner.repo.OpenTag(oid)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,303 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	cryptosha1 "crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Native object writing: objects are stored loose, the same way `git
+// hash-object -w` does, so everything reading the repository sees them.
+
+// objectWriter stores new objects.
+type objectWriter interface {
+	writeObject(typ ObjectType, data []byte) (sha1, error)
+}
+
+// hashObject returns id of the object with given type and content.
+func hashObject(typ ObjectType, data []byte) sha1 {
+	h := cryptosha1.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, len(data))
+	h.Write(data)
+
+	var id sha1
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// writeObject stores object as a loose one unless it already exists loose.
+func (repo *Repository) writeObject(typ ObjectType, data []byte) (sha1, error) {
+	id := hashObject(typ, data)
+	hex := id.String()
+	dir := filepath.Join(repo.objectsDir(), hex[:2])
+	path := filepath.Join(dir, hex[2:])
+	if isFile(path) {
+		return id, nil
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	fmt.Fprintf(zw, "%s %d\x00", typ, len(data))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return sha1{}, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return sha1{}, err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return sha1{}, err
+	}
+
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0444)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return sha1{}, err
+	}
+
+	return id, nil
+}
+
+// memoryObjects keeps objects in memory instead of the repository, for
+// intermediate results which must not be stored.
+type memoryObjects struct {
+	objects map[sha1]*memoryObject
+}
+
+type memoryObject struct {
+	typ  ObjectType
+	data []byte
+}
+
+func newMemoryObjects() *memoryObjects {
+	return &memoryObjects{objects: map[sha1]*memoryObject{}}
+}
+
+func (m *memoryObjects) writeObject(typ ObjectType, data []byte) (sha1, error) {
+	id := hashObject(typ, data)
+	m.objects[id] = &memoryObject{typ: typ, data: data}
+	return id, nil
+}
+
+// writeTo stores all objects kept in memory.
+func (m *memoryObjects) writeTo(w objectWriter) error {
+	for _, object := range m.objects {
+		if _, err := w.writeObject(object.typ, object.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withOverlay returns a handle of the repository which reads objects from
+// overlay first.
+func (repo *Repository) withOverlay(overlay *memoryObjects) *Repository {
+	return &Repository{
+		Path:       repo.Path,
+		repo:       repo.repo,
+		statsCache: repo.statsCache,
+		graph:      repo.graph,
+		fallback:   repo.fallback,
+		overlay:    overlay,
+		alternates: repo.alternates,
+		objectDir:  repo.objectDir,
+	}
+}
+
+// overlayObject returns object kept in memory overlay, if any.
+func (repo *Repository) overlayObject(id sha1) *memoryObject {
+	if repo.overlay == nil {
+		return nil
+	}
+	return repo.overlay.objects[id]
+}
+
+// WriteBlob stores content read from r as a blob, the same way `git
+// hash-object -w` does, and returns its id.
+func (repo *Repository) WriteBlob(r io.Reader) (sha1, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return sha1{}, err
+	}
+	return repo.writeObject(OBJECT_BLOB, data)
+}
+
+// WriteTreeEntry is an entry of a tree to write.
+type WriteTreeEntry struct {
+	Name string
+	Mode EntryMode
+	ID   sha1
+}
+
+// WriteTree stores a tree of entries given in any order and returns its id,
+// as `git mktree` does. Objects of entries must exist, except commits of
+// submodules.
+func (repo *Repository) WriteTree(entries []WriteTreeEntry) (sha1, error) {
+	tree := make(map[string]*pathEntry, len(entries))
+	for _, entry := range entries {
+		if err := validateTreeEntry(entry.Name, entry.Mode); err != nil {
+			return sha1{}, err
+		}
+		if _, ok := tree[entry.Name]; ok {
+			return sha1{}, fmt.Errorf("duplicate tree entry %q", entry.Name)
+		}
+		if entry.Mode != ENTRY_MODE_COMMIT && repo.objectOwner(entry.ID) == nil {
+			return sha1{}, ErrNotExist{entry.ID.String(), entry.Name}
+		}
+		tree[entry.Name] = &pathEntry{ID: entry.ID, Mode: entry.Mode}
+	}
+
+	return repo.writeObject(OBJECT_TREE, encodeTree(tree))
+}
+
+// validateTreeEntry checks that name and mode can be stored in a tree.
+func validateTreeEntry(name string, mode EntryMode) error {
+	switch mode {
+	case ENTRY_MODE_BLOB, ENTRY_MODE_EXEC, ENTRY_MODE_SYMLINK, ENTRY_MODE_COMMIT, ENTRY_MODE_TREE:
+	default:
+		return fmt.Errorf("invalid mode %o of tree entry %q", mode, name)
+	}
+
+	if !validTreeName(name) {
+		return fmt.Errorf("invalid tree entry name %q", name)
+	}
+	return nil
+}
+
+// encodeTree serializes entries in git tree format and order.
+func encodeTree(entries map[string]*pathEntry) []byte {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return treeOrderKey(names[i], entries[names[i]]) < treeOrderKey(names[j], entries[names[j]])
+	})
+
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		entry := entries[name]
+		fmt.Fprintf(buf, "%o %s\x00", entry.Mode, name)
+		buf.Write(entry.ID[:])
+	}
+	return buf.Bytes()
+}
+
+// writeCommit stores a commit object. Nil committer means the author.
+func writeCommit(w objectWriter, tree sha1, parents []sha1, author, committer *Signature, message string) (sha1, error) {
+	if committer == nil {
+		committer = author
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(buf, "author %s\n", encodeSignature(author))
+	fmt.Fprintf(buf, "committer %s\n", encodeSignature(committer))
+	buf.WriteString("\n")
+	buf.WriteString(message)
+	if message != "" && message[len(message)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	return w.writeObject(OBJECT_COMMIT, buf.Bytes())
+}
+
+func encodeSignature(sig *Signature) string {
+	return fmt.Sprintf("%s <%s> %d %s", cleanIdent(sig.Name), cleanIdent(sig.Email), sig.When.Unix(), sig.When.Format("-0700"))
+}
+
+// cleanIdent strips characters which would break the signature line, the
+// same way git does for names and emails: newlines and angle brackets are
+// dropped and punctuation or whitespace is trimmed from both ends.
+func cleanIdent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '<' || r == '>' {
+			return -1
+		}
+		return r
+	}, s)
+
+	return strings.TrimFunc(s, func(r rune) bool {
+		return r <= ' ' || strings.ContainsRune(".,:;<>\"\\'", r)
+	})
+}
+
+// decodeCommit parses what the history walker needs of commit object
+// content: tree, parents and commit time.
+func decodeCommit(id sha1, data []byte) (*walkNode, error) {
+	node := &walkNode{id: id}
+	for len(data) > 0 {
+		eol := bytes.IndexByte(data, '\n')
+		if eol <= 0 {
+			break
+		}
+		line := string(data[:eol])
+		data = data[eol+1:]
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			node.tree, err = NewIDFromString(line[len("tree "):])
+		case strings.HasPrefix(line, "parent "):
+			var parent sha1
+			parent, err = NewIDFromString(line[len("parent "):])
+			node.parents = append(node.parents, parent)
+		case strings.HasPrefix(line, "committer "):
+			fields := strings.Fields(line[strings.LastIndexByte(line, '>')+1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("malformed committer of commit %s", id)
+			}
+			node.when, err = strconv.ParseInt(fields[0], 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed commit %s: %v", id, err)
+		}
+	}
+	return node, nil
+}
+
+// decodeTree parses entries of tree object content.
+func decodeTree(data []byte) (map[string]*pathEntry, error) {
+	entries := map[string]*pathEntry{}
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space == -1 || nul < space || len(data) < nul+21 {
+			return nil, fmt.Errorf("malformed tree object")
+		}
+
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &pathEntry{Mode: EntryMode(mode)}
+		copy(entry.ID[:], data[nul+1:nul+21])
+		entries[string(data[space+1:nul])] = entry
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
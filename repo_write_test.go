@@ -0,0 +1,59 @@
+package git
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeSignature(t *testing.T) {
+	tests := []struct {
+		name, email string
+		want        string
+	}{
+		{"A U Thor", "author@example.com", "A U Thor <author@example.com> 1500000000 +0000"},
+		{" A U Thor. ", "<author@example.com>", "A U Thor <author@example.com> 1500000000 +0000"},
+		{"Evil\ncommitter Mallory <m@example.com>", "a>b\n", "Evilcommitter Mallory m@example.com <ab> 1500000000 +0000"},
+	}
+
+	for _, test := range tests {
+		sig := &Signature{Name: test.name, Email: test.email, When: time.Unix(1500000000, 0).UTC()}
+		if got := encodeSignature(sig); got != test.want {
+			t.Errorf("%q <%q>: got %q, want %q", test.name, test.email, got, test.want)
+		}
+	}
+}
+
+func TestDecodeCommit(t *testing.T) {
+	sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1500000000, 0).UTC()}
+	objects := newMemoryObjects()
+	id, err := writeCommit(objects, testID(1), []sha1{testID(2), testID(3)}, sig, nil, "parent of nothing\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := decodeCommit(id, objects.objects[id].data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.id != id || node.tree != testID(1) || node.when != sig.When.Unix() {
+		t.Errorf("got node %+v", node)
+	}
+	if len(node.parents) != 2 || node.parents[0] != testID(2) || node.parents[1] != testID(3) {
+		t.Errorf("got parents %v", node.parents)
+	}
+}
+
+func TestWriteTreeInvalidNames(t *testing.T) {
+	repo := newTestRepository(t)
+	blob, err := repo.WriteBlob(strings.NewReader("blob\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", ".", "..", ".git", ".Git", "a/b", "a\x00"} {
+		if _, err := repo.WriteTree([]WriteTreeEntry{{Name: name, Mode: ENTRY_MODE_BLOB, ID: blob}}); err == nil {
+			t.Errorf("name %q: expected error", name)
+		}
+	}
+}
@@ -3,11 +3,14 @@ package git
 import (
 	"container/list"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/mechmind/git-go/git"
-	"github.com/mechmind/git-go/history"
 	"github.com/mechmind/git-go/rawgit"
 )
 
@@ -22,23 +25,90 @@ type Repository struct {
 	Path string
 
 	repo *git.Repository
+
+	// graph is shared by all handles of the repository, so that the
+	// commit-graph file is parsed once
+	graph *commitGraphFile
+
+	// statsCache keeps change summaries of recently viewed commits
+	statsCache *lruCache
+
+	// fallback is read for objects missing in the repository, it lets
+	// native walkers look into another repository without fetching from it
+	fallback *Repository
+	// overlay keeps objects written in memory only
+	overlay *memoryObjects
+	// alternates are repositories objects are borrowed from, as listed in
+	// objects/info/alternates
+	alternates []*Repository
+	// objectDir is set for alternates opened on an object directory, which
+	// may be named other than "objects"
+	objectDir string
 }
 
+// InitRepository creates an empty repository at path, the same way `git
+// init` does. Files of an existing repository are left as they are.
 func InitRepository(path string, bare bool) error {
-	// FIXME: implement
+	gitDir := path
+	if !bare {
+		gitDir = filepath.Join(path, ".git")
+	}
+
+	for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags", "hooks", "info"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, dir), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	config := "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n"
+	if bare {
+		config += "\tbare = true\n"
+	} else {
+		config += "\tbare = false\n\tlogallrefupdates = true\n"
+	}
+
+	files := []struct{ name, content string }{
+		{"HEAD", "ref: " + BRANCH_PREFIX + "master\n"},
+		{"config", config},
+		{"description", "Unnamed repository; edit this file 'description' to name the repository.\n"},
+	}
+	for _, file := range files {
+		filename := filepath.Join(gitDir, file.name)
+		if isExist(filename) {
+			continue
+		}
+		if err := ioutil.WriteFile(filename, []byte(file.content), 0644); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func OpenRepository(path string) (*Repository, error) {
+	return openRepository(path, "", 0)
+}
+
+// openRepository opens repository with its alternates, depth counts
+// repositories borrowing objects from it. Non-empty objectDir is the object
+// directory of the repository when it is not the usual one.
+func openRepository(path, objectDir string, depth int) (*Repository, error) {
 	repo, err := git.OpenRepository(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Repository{
-		Path: path,
-		repo: repo,
-	}, nil
+	result := &Repository{
+		Path:       path,
+		repo:       repo,
+		graph:      &commitGraphFile{},
+		statsCache: newLRUCache(StatsCacheSize),
+		objectDir:  objectDir,
+	}
+
+	if result.alternates, err = result.openAlternates(depth); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 type CloneRepoOptions struct {
@@ -169,7 +239,7 @@ func (repo *Repository) GetBranchCommitID(name string) (string, error) {
 }
 
 func (repo *Repository) getCommit(id sha1) (*Commit, error) {
-	commit, err := repo.repo.OpenCommit(sha2oidp(id))
+	commit, err := repo.openRawCommit(id)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +257,7 @@ func (repo *Repository) GetTagCommitID(name string) (string, error) {
 }
 
 func (repo *Repository) openCommit(oid *rawgit.OID) (*Commit, error) {
-	commit, err := repo.repo.OpenCommit(oid)
+	commit, err := repo.openRawCommit(sha1(*oid))
 	if err != nil {
 		return nil, err
 	}
@@ -264,17 +334,21 @@ func (repo *Repository) FileCommitsCount(revision, file string) (int64, error) {
 }
 
 func (repo *Repository) fileCommitsCount(commit *Commit, file string) (int64, error) {
-	pathCb := history.MakePathChecker(commit.repo.repo, file)
-	cmp := history.MakePathComparator(commit.repo.repo, file)
-	counter, result := history.MakeCounter(pathCb)
-
-	hist := history.New(commit.repo.repo)
-	_, err := hist.WalkFilteredHistory(sha2oidp(commit.ID), counter, cmp)
+	walk, err := repo.newPathWalk(commit.ID, file)
 	if err != nil {
 		return 0, err
 	}
 
-	return int64(result()), nil
+	var count int64
+	for {
+		_, err = walk.next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return 0, err
+		}
+		count++
+	}
 }
 
 func (repo *Repository) CommitsByFileAndRange(revision, file string, page int) (*list.List, error) {
@@ -297,17 +371,12 @@ func (repo *Repository) CommitsByFileAndRange(revision, file string, page int) (
 }
 
 func (repo *Repository) commitsByFileAndRange(commit *Commit, file string, page int) (*list.List, error) {
-	pathCb := history.MakePathChecker(commit.repo.repo, file)
-	cmp := history.MakePathComparator(commit.repo.repo, file)
-	pager := history.MakePager(commit.repo.repo, pathCb, (page-1)*CommitsRangeSize, CommitsRangeSize)
-
-	hist := history.New(commit.repo.repo)
-	result, err := hist.WalkFilteredHistory(sha2oidp(commit.ID), pager, cmp)
+	walk, err := repo.newPathWalk(commit.ID, file)
 	if err != nil {
 		return nil, err
 	}
 
-	return repo.raw2commitList(result)
+	return repo.commitList(walk, (page-1)*CommitsRangeSize, CommitsRangeSize, nil)
 }
 
 func (repo *Repository) FilesCountBetween(startCommitID, endCommitID string) (int, error) {
@@ -363,8 +432,7 @@ func (repo *Repository) CommitsCountBetween(start, end string) (int64, error) {
 // repo_tree.go ports
 
 func (repo *Repository) getTree(id sha1) (*Tree, error) {
-	_, _, err := repo.repo.StatObject(sha2oidp(id))
-	if err != nil {
+	if repo.objectOwner(id) == nil {
 		return nil, ErrNotExist{id.String(), ""}
 	}
 
@@ -379,17 +447,3 @@ func (repo *Repository) GetTree(idStr string) (*Tree, error) {
 	}
 	return repo.getTree(id)
 }
-
-// own helpers
-
-func (repo *Repository) raw2commitList(src *list.List) (*list.List, error) {
-	var err error
-	for cur := src.Front(); cur != nil; cur = cur.Next() {
-		cur.Value, err = raw2commit(repo, cur.Value.(*rawgit.Commit))
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return src, nil
-}
@@ -0,0 +1,615 @@
+package git
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"io"
+	"sort"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// walkNode is a commit as seen by the native history walker.
+type walkNode struct {
+	id      sha1
+	parents []sha1
+	tree    sha1
+	when    int64
+	// generation number from commit-graph, 0 if unknown
+	generation uint32
+
+	// parsed commit object, loaded lazily for nodes read from commit-graph
+	raw *rawgit.Commit
+
+	// path of the filtered file in this commit, set by path-limited walks
+	path string
+}
+
+// loadWalkNode reads commit from commit-graph if possible, otherwise parses
+// commit object, which may be kept in the memory overlay.
+func (repo *Repository) loadWalkNode(id sha1) (*walkNode, error) {
+	if object := repo.overlayObject(id); object != nil {
+		return decodeCommit(id, object.data)
+	}
+
+	if graph := repo.commitGraph(); graph != nil {
+		if pos, ok := graph.lookup(id); ok {
+			node, err := graph.node(pos)
+			if err == nil {
+				return node, nil
+			}
+			log("ignoring commit-graph entry of %s in %s: %v", id, repo.Path, err)
+		}
+	}
+
+	raw, err := repo.openRawCommit(id)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &walkNode{
+		id:      id,
+		parents: make([]sha1, len(raw.ParentOIDs)),
+		tree:    rawTreeID(raw),
+		when:    raw.Committer.Time.Unix(),
+		raw:     raw,
+	}
+	for idx, parent := range raw.ParentOIDs {
+		node.parents[idx] = sha1(*parent)
+	}
+
+	return node, nil
+}
+
+// rawCommit returns parsed commit object of the node.
+func (node *walkNode) rawCommit(repo *Repository) (*rawgit.Commit, error) {
+	if node.raw != nil {
+		return node.raw, nil
+	}
+
+	raw, err := repo.openRawCommit(node.id)
+	if err != nil {
+		return nil, err
+	}
+
+	node.raw = raw
+	return raw, nil
+}
+
+// commit returns full commit for the node.
+func (node *walkNode) commit(repo *Repository) (*Commit, error) {
+	raw, err := node.rawCommit(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw2commit(repo, raw)
+}
+
+type queueItem struct {
+	node *walkNode
+	seq  int
+}
+
+// nodeQueue orders commits by committer date, newest first, the same way
+// `git log` does by default. Commits with equal dates keep insertion order.
+type nodeQueue []queueItem
+
+func (q nodeQueue) Len() int { return len(q) }
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].node.when != q[j].node.when {
+		return q[i].node.when > q[j].node.when
+	}
+	return q[i].seq < q[j].seq
+}
+func (q nodeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(queueItem)) }
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// revWalk walks commit history natively, without help of history package.
+// It can optionally limit history to commits touching a single path and
+// follow that path across renames.
+type revWalk struct {
+	ctx   context.Context
+	repo  *Repository
+	queue nodeQueue
+	seen  map[sha1]bool
+	seq   int
+
+	// nodes loaded ahead of time by path simplification, waiting to be queued
+	pending map[sha1]*walkNode
+	// resolved path entries of queued nodes
+	entries map[sha1]*pathEntry
+
+	path            string
+	follow          bool
+	renameThreshold int
+
+	// commits excluded by hide, nil if there are none
+	hidden map[sha1]bool
+	// commits popped by limit and those of them kept for serving
+	walked  map[sha1]*walkNode
+	limited []*walkNode
+}
+
+func newRevWalk(repo *Repository) *revWalk {
+	return &revWalk{
+		ctx:     context.Background(),
+		repo:    repo,
+		seen:    map[sha1]bool{},
+		pending: map[sha1]*walkNode{},
+		entries: map[sha1]*pathEntry{},
+
+		renameThreshold: DefaultRenameThreshold,
+	}
+}
+
+// limitToPath restricts walk to commits changing relpath. If follow is set,
+// the walk continues with the old name when the file turns out to be renamed.
+func (w *revWalk) limitToPath(relpath string, follow bool) {
+	w.path = relpath
+	w.follow = follow
+}
+
+// withContext makes walk stop with ctx error once ctx is done.
+func (w *revWalk) withContext(ctx context.Context) {
+	w.ctx = ctx
+}
+
+// push adds a starting point for the walk.
+func (w *revWalk) push(id sha1) error {
+	if w.seen[id] {
+		return nil
+	}
+	w.seen[id] = true
+
+	node, ok := w.pending[id]
+	if ok {
+		delete(w.pending, id)
+	} else {
+		var err error
+		node, err = w.repo.loadWalkNode(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	heap.Push(&w.queue, queueItem{node: node, seq: w.seq})
+	w.seq++
+	return nil
+}
+
+// hide excludes id and all its ancestors from the walk. It must be called
+// before the walk starts and does not combine with path limiting.
+func (w *revWalk) hide(id sha1) error {
+	if w.hidden == nil {
+		w.hidden = map[sha1]bool{}
+	}
+	return w.markHidden(id)
+}
+
+// markHidden flags id and its ancestors already walked as uninteresting.
+// Unseen commits are queued, so that the flag reaches their parents once
+// they are popped, the same way git propagates UNINTERESTING.
+func (w *revWalk) markHidden(id sha1) error {
+	stack := []sha1{id}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w.hidden[id] {
+			continue
+		}
+		w.hidden[id] = true
+
+		if node, ok := w.walked[id]; ok {
+			stack = append(stack, node.parents...)
+		} else if err := w.push(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limit walks history until only hidden commits are queued and keeps the
+// rest in walk order, as git does before showing history with excluded
+// commits. Commits flagged hidden later are dropped when served.
+func (w *revWalk) limit() error {
+	w.walked = map[sha1]*walkNode{}
+	// generation of the oldest kept commit, 0 if unknown
+	minGeneration := ^uint32(0)
+	slop := limitSlop
+	for w.queue.Len() > 0 {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+
+		if w.everybodyHidden() {
+			if w.beyondGeneration(minGeneration) {
+				break
+			}
+			// without generation numbers skewed dates are covered by a
+			// few more commits only
+			if slop--; slop == 0 {
+				break
+			}
+		} else {
+			slop = limitSlop
+		}
+
+		node := heap.Pop(&w.queue).(queueItem).node
+		w.walked[node.id] = node
+		if w.hidden[node.id] {
+			for _, parent := range node.parents {
+				if err := w.markHidden(parent); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := w.pushAll(node.parents); err != nil {
+			return err
+		}
+		w.limited = append(w.limited, node)
+		if node.generation < minGeneration {
+			minGeneration = node.generation
+		}
+	}
+
+	w.queue = nil
+	return nil
+}
+
+// limitSlop is how many hidden commits limit walks past the point where
+// nothing interesting is queued, as SLOP of git.
+const limitSlop = 5
+
+// everybodyHidden tells whether all queued commits are hidden.
+func (w *revWalk) everybodyHidden() bool {
+	for _, item := range w.queue {
+		if !w.hidden[item.node.id] {
+			return false
+		}
+	}
+	return true
+}
+
+// beyondGeneration tells whether no queued commit can reach a commit of
+// given generation, which needs a greater generation of its own.
+func (w *revWalk) beyondGeneration(generation uint32) bool {
+	if generation == 0 {
+		return false
+	}
+	for _, item := range w.queue {
+		if item.node.generation == 0 || item.node.generation > generation {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *revWalk) pushAll(ids []sha1) error {
+	for _, id := range ids {
+		if err := w.push(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next returns the next commit of the walk or io.EOF when history is exhausted.
+func (w *revWalk) next() (*walkNode, error) {
+	if w.hidden != nil {
+		return w.nextLimited()
+	}
+
+	for w.queue.Len() > 0 {
+		if err := w.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		node := heap.Pop(&w.queue).(queueItem).node
+		if w.path == "" {
+			if err := w.pushAll(node.parents); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+
+		interesting, parents, err := w.simplify(node)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = w.pushAll(parents); err != nil {
+			return nil, err
+		}
+
+		if interesting {
+			return node, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+// nextLimited serves commits of a walk having hidden commits.
+func (w *revWalk) nextLimited() (*walkNode, error) {
+	if w.walked == nil {
+		if err := w.limit(); err != nil {
+			return nil, err
+		}
+	}
+
+	for len(w.limited) > 0 {
+		node := w.limited[0]
+		w.limited = w.limited[1:]
+		if !w.hidden[node.id] {
+			return node, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// frontier returns commits queued for walking, in the order they would be
+// visited if the walk went on.
+func (w *revWalk) frontier() []sha1 {
+	queue := make(nodeQueue, len(w.queue))
+	copy(queue, w.queue)
+
+	ids := make([]sha1, 0, len(queue))
+	for queue.Len() > 0 {
+		ids = append(ids, heap.Pop(&queue).(queueItem).node.id)
+	}
+	return ids
+}
+
+// entryAt resolves the filtered path in given commit.
+func (w *revWalk) entryAt(node *walkNode) (*pathEntry, error) {
+	if entry, ok := w.entries[node.id]; ok {
+		return entry, nil
+	}
+
+	entry, err := w.repo.lookupPath(node.tree, w.path)
+	if err != nil {
+		return nil, err
+	}
+
+	w.entries[node.id] = entry
+	return entry, nil
+}
+
+// parentNode loads parent commit, keeping it around until it gets queued.
+func (w *revWalk) parentNode(id sha1) (*walkNode, error) {
+	if node, ok := w.pending[id]; ok {
+		return node, nil
+	}
+
+	node, err := w.repo.loadWalkNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !w.seen[id] {
+		w.pending[id] = node
+	}
+	return node, nil
+}
+
+// simplify decides whether the node touches the filtered path and which
+// parents should be walked further. Like git, when the path is the same as
+// in one of the parents, only that parent is followed.
+func (w *revWalk) simplify(node *walkNode) (bool, []sha1, error) {
+	entry, err := w.entryAt(node)
+	delete(w.entries, node.id)
+	if err != nil {
+		return false, nil, err
+	}
+
+	node.path = w.path
+	if len(node.parents) == 0 {
+		return entry != nil, nil, nil
+	}
+
+	absent := true
+	for _, id := range node.parents {
+		parent, err := w.parentNode(id)
+		if err != nil {
+			return false, nil, err
+		}
+
+		parentEntry, err := w.entryAt(parent)
+		if err != nil {
+			return false, nil, err
+		}
+
+		if sameEntry(entry, parentEntry) {
+			w.dropPending(node.parents, id)
+			return false, []sha1{id}, nil
+		}
+
+		if parentEntry != nil {
+			absent = false
+		}
+	}
+
+	if w.follow && entry != nil && absent {
+		source, err := w.findRenameSource(node, entry)
+		if err != nil {
+			return false, nil, err
+		}
+
+		if source != "" {
+			// all cached entries were resolved with the new name
+			w.path = source
+			w.entries = map[sha1]*pathEntry{}
+		}
+	}
+
+	return true, node.parents, nil
+}
+
+// dropPending forgets preloaded parents which are not going to be walked.
+func (w *revWalk) dropPending(parents []sha1, keep sha1) {
+	for _, id := range parents {
+		if id != keep {
+			delete(w.pending, id)
+			delete(w.entries, id)
+		}
+	}
+}
+
+// findRenameSource looks for the file that was renamed to the followed path
+// in given commit. Exact renames win, otherwise the most similar file removed
+// by the commit is chosen if it passes the rename threshold.
+func (w *revWalk) findRenameSource(node *walkNode, entry *pathEntry) (string, error) {
+	parent, err := w.parentNode(node.parents[0])
+	if err != nil {
+		return "", err
+	}
+
+	deleted := map[string]*pathEntry{}
+	err = w.repo.diffTreeIDs(parent.tree, node.tree, "", nil, func(relpath string, from, to *pathEntry) error {
+		if from != nil && to == nil && from.Mode != ENTRY_MODE_COMMIT {
+			deleted[relpath] = from
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(deleted))
+	for relpath := range deleted {
+		names = append(names, relpath)
+	}
+	sort.Strings(names)
+
+	for _, relpath := range names {
+		if deleted[relpath].ID == entry.ID {
+			return relpath, nil
+		}
+	}
+
+	if len(deleted) == 0 || entry.Mode == ENTRY_MODE_COMMIT {
+		return "", nil
+	}
+
+	dstSize, err := w.repo.blobSize(entry.ID)
+	if err != nil {
+		return "", err
+	}
+
+	var dst []byte
+	var best string
+	bestScore := w.renameThreshold - 1
+	for _, relpath := range names {
+		candidate := deleted[relpath]
+		srcSize, err := w.repo.blobSize(candidate.ID)
+		if err != nil {
+			return "", err
+		}
+
+		if !sizesCouldMatch(srcSize, dstSize, w.renameThreshold) {
+			continue
+		}
+
+		if dst == nil {
+			if dst, err = w.repo.readBlob(entry.ID); err != nil {
+				return "", err
+			}
+		}
+
+		src, err := w.repo.readBlob(candidate.ID)
+		if err != nil {
+			return "", err
+		}
+
+		score := similarity(src, dst)
+		if score > bestScore {
+			best, bestScore = relpath, score
+		}
+	}
+
+	return best, nil
+}
+
+// newPathWalk prepares walk of history of id, limited to relpath unless it
+// is empty.
+func (repo *Repository) newPathWalk(id sha1, relpath string) (*revWalk, error) {
+	walk := newRevWalk(repo)
+	if relpath != "" {
+		walk.limitToPath(relpath, false)
+	}
+	if err := walk.push(id); err != nil {
+		return nil, err
+	}
+	return walk, nil
+}
+
+// commitList returns commits of the walk accepted by match, or all of them
+// if match is nil, leaving out the first skip ones. Zero limit means no
+// limit.
+func (repo *Repository) commitList(walk *revWalk, skip, limit int, match func(node *walkNode) (bool, error)) (*list.List, error) {
+	result := list.New()
+	for limit == 0 || result.Len() < limit {
+		node, err := walk.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if match != nil {
+			ok, err := match(node)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		commit, err := node.commit(repo)
+		if err != nil {
+			return nil, err
+		}
+		result.PushBack(commit)
+	}
+
+	return result, nil
+}
+
+// countReachable counts commits reachable from id, including id itself.
+// Order does not matter here, so commits are visited depth first.
+func (repo *Repository) countReachable(id sha1) (int64, error) {
+	seen := map[sha1]bool{id: true}
+	stack := []sha1{id}
+	for len(stack) > 0 {
+		node, err := repo.loadWalkNode(stack[len(stack)-1])
+		if err != nil {
+			return 0, err
+		}
+		stack = stack[:len(stack)-1]
+
+		for _, parent := range node.parents {
+			if !seen[parent] {
+				seen[parent] = true
+				stack = append(stack, parent)
+			}
+		}
+	}
+
+	return int64(len(seen)), nil
+}
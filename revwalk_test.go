@@ -0,0 +1,99 @@
+package git
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// testCommit stores a commit with an empty tree dated when.
+func testCommit(t *testing.T, repo *Repository, when int64, parents ...sha1) sha1 {
+	tree, err := repo.writeObject(OBJECT_TREE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+	id, err := writeCommit(repo, tree, parents, sig, nil, "message\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// testCommitFiles stores a commit of files with given content dated when.
+func testCommitFiles(t *testing.T, repo *Repository, files map[string]string, when int64, parents ...sha1) sha1 {
+	sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(when, 0).UTC()}
+	id, err := writeCommit(repo, testTree(t, repo, files).ID, parents, sig, nil, "message\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// walkIDs returns all commits of the walk.
+func walkIDs(t *testing.T, walk *revWalk) []sha1 {
+	ids := []sha1{}
+	for {
+		node, err := walk.next()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, node.id)
+	}
+}
+
+func TestRevWalkHide(t *testing.T) {
+	repo := newTestRepository(t)
+
+	root := testCommit(t, repo, 100)
+	base := testCommit(t, repo, 200, root)
+	side := testCommit(t, repo, 300, root)
+	merge := testCommit(t, repo, 400, base, side)
+	head := testCommit(t, repo, 500, merge)
+
+	walk := newRevWalk(repo)
+	if err := walk.hide(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := walk.push(head); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := walkIDs(t, walk)
+	want := []sha1{head, merge, side}
+	if len(ids) != len(want) {
+		t.Fatalf("got %d commits, want %d", len(ids), len(want))
+	}
+	for idx := range want {
+		if ids[idx] != want[idx] {
+			t.Errorf("commit %d: got %s, want %s", idx, ids[idx], want[idx])
+		}
+	}
+}
+
+func TestRevWalkHideSkewedDates(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// the hidden commit is dated before its ancestors, so they are popped
+	// first and get flagged only later
+	root := testCommit(t, repo, 100)
+	shared := testCommit(t, repo, 200, root)
+	base := testCommit(t, repo, 50, shared)
+	head := testCommit(t, repo, 300, shared)
+
+	walk := newRevWalk(repo)
+	if err := walk.hide(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := walk.push(head); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := walkIDs(t, walk); len(ids) != 1 || ids[0] != head {
+		t.Errorf("got %v, want only the head", ids)
+	}
+}
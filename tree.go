@@ -98,6 +98,9 @@ func (t *Tree) SubTree(rpath string) (*Tree, error) {
 		if err != nil {
 			return nil, err
 		}
+		if !te.IsDir() {
+			return nil, ErrNotExist{te.ID.String(), rpath}
+		}
 
 		g, err = t.repo.getTree(te.ID)
 		if err != nil {
@@ -128,18 +131,76 @@ func raw2treeEntries(ptree *Tree, items []rawgit.TreeItem) (Entries, error) {
 	return entries, nil
 }
 
+// path2treeEntries converts decoded entries of a tree kept in memory, in
+// the order git stores them.
+func path2treeEntries(ptree *Tree, decoded map[string]*pathEntry) Entries {
+	entries := make(Entries, 0, len(decoded))
+	for name, item := range decoded {
+		typ := OBJECT_BLOB
+		switch item.Mode {
+		case ENTRY_MODE_TREE:
+			typ = OBJECT_TREE
+		case ENTRY_MODE_COMMIT:
+			typ = OBJECT_COMMIT
+		}
+
+		entries = append(entries, &TreeEntry{
+			ID:   item.ID,
+			Type: typ,
+
+			mode: item.Mode,
+			name: name,
+
+			ptree: ptree,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return treeOrderKey(entries[i].name, decoded[entries[i].name]) < treeOrderKey(entries[j].name, decoded[entries[j].name])
+	})
+	return entries
+}
+
+// loadRaw reads the tree object on first access.
+func (t *Tree) loadRaw() error {
+	if t.raw != nil {
+		return nil
+	}
+
+	raw, err := t.repo.openRawTree(t.ID)
+	if err != nil {
+		return err
+	}
+
+	t.raw = raw
+	return nil
+}
+
 func (t *Tree) ListEntries() (Entries, error) {
 	if t.entriesParsed {
 		return t.entries, nil
 	}
-	t.entriesParsed = true
 
-	entries, err := raw2treeEntries(t, t.raw.Items)
-	if err != nil {
-		return nil, err
+	var entries Entries
+	if object := t.repo.overlayObject(t.ID); object != nil {
+		decoded, err := decodeTree(object.data)
+		if err != nil {
+			return nil, err
+		}
+		entries = path2treeEntries(t, decoded)
+	} else {
+		if err := t.loadRaw(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if entries, err = raw2treeEntries(t, t.raw.Items); err != nil {
+			return nil, err
+		}
 	}
 
 	t.entries = entries
+	t.entriesParsed = true
 	return t.entries, nil
 }
 
@@ -154,13 +215,13 @@ func (te *TreeEntry) Size() int64 {
 		return te.size
 	}
 
-	info, _, err := te.ptree.repo.repo.StatObject(sha2oidp(te.ID))
+	size, err := te.ptree.repo.blobSize(te.ID)
 	if err != nil {
 		return 0
 	}
 
 	te.sized = true
-	te.size = int64(info.Size)
+	te.size = size
 
 	return te.size
 }
@@ -0,0 +1,98 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TreeBuilder edits a tree at nested paths and writes the result. Only
+// subtrees on edited paths are read, and only trees which changed are
+// written, so edits of large trees stay cheap.
+type TreeBuilder struct {
+	repo   *Repository
+	editor *treeEditor
+}
+
+// NewTreeBuilder starts editing base, nil base stands for an empty tree.
+func (repo *Repository) NewTreeBuilder(base *Tree) *TreeBuilder {
+	var id sha1
+	if base != nil {
+		id = base.ID
+	}
+	return &TreeBuilder{repo: repo, editor: repo.newTreeEditor(id)}
+}
+
+// cleanTreePath returns relpath without leading and trailing slashes, or an
+// error if it cannot be a path in a tree. Empty, "." and ".." components are
+// rejected rather than resolved.
+func cleanTreePath(relpath string) (string, error) {
+	cleaned := strings.Trim(relpath, "/")
+	if err := checkTreePath(cleaned); err != nil {
+		return "", fmt.Errorf("invalid tree path %q", relpath)
+	}
+	return cleaned, nil
+}
+
+// Insert puts an existing object at relpath, replacing what is there.
+// Missing directories are created, files standing in their way are
+// replaced.
+func (b *TreeBuilder) Insert(relpath string, id sha1, mode EntryMode) error {
+	relpath, err := cleanTreePath(relpath)
+	if err != nil {
+		return err
+	}
+
+	_, name := splitPath(relpath)
+	if err = validateTreeEntry(name, mode); err != nil {
+		return err
+	}
+	if mode != ENTRY_MODE_COMMIT && b.repo.objectOwner(id) == nil {
+		return ErrNotExist{id.String(), relpath}
+	}
+
+	return b.editor.set(relpath, id, mode)
+}
+
+// InsertContent puts a blob with data at relpath, the same way Insert does.
+// The blob is stored when the tree is written.
+func (b *TreeBuilder) InsertContent(relpath string, data []byte, mode EntryMode) error {
+	relpath, err := cleanTreePath(relpath)
+	if err != nil {
+		return err
+	}
+
+	_, name := splitPath(relpath)
+	if err = validateTreeEntry(name, mode); err != nil {
+		return err
+	}
+	if mode == ENTRY_MODE_TREE || mode == ENTRY_MODE_COMMIT {
+		return fmt.Errorf("invalid mode %o of blob %q", mode, relpath)
+	}
+
+	return b.editor.setContent(relpath, data, mode)
+}
+
+// Delete removes the entry at relpath, with its content if it is a
+// directory. Directories left empty are removed too.
+func (b *TreeBuilder) Delete(relpath string) error {
+	relpath, err := cleanTreePath(relpath)
+	if err != nil {
+		return err
+	}
+
+	entry, err := b.editor.get(relpath)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return ErrNotExist{"", relpath}
+	}
+
+	return b.editor.remove(relpath)
+}
+
+// Write stores changed trees and new blobs and returns id of the root tree.
+// The builder can be edited and written again afterwards.
+func (b *TreeBuilder) Write() (sha1, error) {
+	return b.editor.write(b.repo)
+}
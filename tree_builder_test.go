@@ -0,0 +1,79 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTreeBuilder(t *testing.T) {
+	repo := newTestRepository(t)
+
+	blob, err := repo.WriteBlob(bytes.NewReader([]byte("blob\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := repo.NewTreeBuilder(nil)
+	if err = b.Insert("/dir/sub/file/", blob, ENTRY_MODE_BLOB); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.InsertContent("run.sh", []byte("#!/bin/sh\n"), ENTRY_MODE_EXEC); err != nil {
+		t.Fatal(err)
+	}
+	base, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := repo.lookupPath(base, "dir/sub/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameEntry(entry, &pathEntry{ID: blob, Mode: ENTRY_MODE_BLOB}) {
+		t.Errorf("got entry %+v", entry)
+	}
+	if entry, err = repo.lookupPath(base, "run.sh"); err != nil || entry == nil || entry.Mode != ENTRY_MODE_EXEC {
+		t.Errorf("got entry %+v, error %v", entry, err)
+	}
+
+	// deleting the only file removes directories holding it
+	b = repo.NewTreeBuilder(NewTree(repo, base))
+	if err = b.Delete("dir/sub/file"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := b.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := treeNames(t, repo, id); len(names) != 1 || names[0] != "run.sh" {
+		t.Errorf("got root entries %v, want only run.sh", names)
+	}
+}
+
+func TestTreeBuilderErrors(t *testing.T) {
+	repo := newTestRepository(t)
+	b := repo.NewTreeBuilder(nil)
+
+	if err := b.Insert("missing", testID(1), ENTRY_MODE_BLOB); !IsErrNotExist(err) {
+		t.Errorf("insert of missing object: got %v", err)
+	}
+	if err := b.Delete("missing"); !IsErrNotExist(err) {
+		t.Errorf("delete of missing path: got %v", err)
+	}
+	blob, err := repo.WriteBlob(strings.NewReader("blob\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, relpath := range []string{"", "/", "dir/..", "../x", "a/../../b", "a//b", "./a", "dir/.git/hooks/x", ".GIT", "x\x00"} {
+		if err := b.InsertContent(relpath, nil, ENTRY_MODE_BLOB); err == nil {
+			t.Errorf("insert content at %q: expected error", relpath)
+		}
+		if err := b.Insert(relpath, blob, ENTRY_MODE_BLOB); err == nil {
+			t.Errorf("insert at %q: expected error", relpath)
+		}
+	}
+	if err := b.InsertContent("dir", nil, ENTRY_MODE_TREE); err == nil {
+		t.Error("insert of content as a tree: expected error")
+	}
+}
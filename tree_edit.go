@@ -0,0 +1,226 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// treeEditor applies path level changes on top of an existing tree. Only
+// subtrees on changed paths are read, and only modified trees are written
+// back. New blob content is kept in memory until the tree is written.
+type treeEditor struct {
+	repo *Repository
+	root *editNode
+}
+
+// editNode is a directory being edited.
+type editNode struct {
+	id       sha1
+	loaded   bool
+	modified bool
+	entries  map[string]*editEntry
+}
+
+type editEntry struct {
+	pathEntry
+	// data is content of a blob which is not written yet
+	data []byte
+	// dir is set once the subtree was opened for editing
+	dir *editNode
+}
+
+// newTreeEditor starts editing the tree, zero id stands for an empty tree.
+func (repo *Repository) newTreeEditor(treeID sha1) *treeEditor {
+	return &treeEditor{repo: repo, root: &editNode{id: treeID}}
+}
+
+func (e *treeEditor) load(node *editNode) error {
+	if node.loaded {
+		return nil
+	}
+
+	entries, err := e.repo.readTreeEntries(node.id)
+	if err != nil {
+		return err
+	}
+
+	node.entries = make(map[string]*editEntry, len(entries))
+	for name, entry := range entries {
+		node.entries[name] = &editEntry{pathEntry: *entry}
+	}
+	node.loaded = true
+	return nil
+}
+
+// dir returns the directory node for path components, creating missing
+// directories when create is set. Nil means there is no such directory.
+func (e *treeEditor) dir(names []string, create bool) (*editNode, error) {
+	node := e.root
+	for _, name := range names {
+		if err := e.load(node); err != nil {
+			return nil, err
+		}
+
+		entry := node.entries[name]
+		switch {
+		case entry != nil && entry.isDir():
+		case !create:
+			return nil, nil
+		default:
+			// missing, or a file in the way which gets replaced
+			entry = &editEntry{pathEntry: pathEntry{Mode: ENTRY_MODE_TREE}, dir: &editNode{loaded: true, entries: map[string]*editEntry{}}}
+			node.entries[name] = entry
+		}
+
+		if entry.dir == nil {
+			entry.dir = &editNode{id: entry.ID}
+		}
+		node = entry.dir
+	}
+
+	if err := e.load(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func splitPath(relpath string) ([]string, string) {
+	names := strings.Split(relpath, "/")
+	return names[:len(names)-1], names[len(names)-1]
+}
+
+// validTreeName tells whether name can be an entry of a tree. Like git,
+// empty names, "." and ".." are rejected as fsck does, and ".git" in any
+// case is rejected as dangerous on checkout.
+func validTreeName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.EqualFold(name, ".git") &&
+		!strings.ContainsAny(name, "/\x00")
+}
+
+// checkTreePath returns an error if any component of relpath cannot be a
+// name of a tree entry.
+func checkTreePath(relpath string) error {
+	for _, name := range strings.Split(relpath, "/") {
+		if !validTreeName(name) {
+			return fmt.Errorf("invalid tree path %q", relpath)
+		}
+	}
+	return nil
+}
+
+// get returns the entry at path or nil if there is none.
+func (e *treeEditor) get(relpath string) (*editEntry, error) {
+	dirs, name := splitPath(relpath)
+	node, err := e.dir(dirs, false)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	return node.entries[name], nil
+}
+
+// content returns blob content of the entry.
+func (e *treeEditor) content(entry *editEntry) ([]byte, error) {
+	if entry.data != nil {
+		return entry.data, nil
+	}
+	return e.repo.diffContent(entry.ID, entry.Mode)
+}
+
+func (e *treeEditor) markModified(dirs []string) {
+	node := e.root
+	node.modified = true
+	for _, name := range dirs {
+		node = node.entries[name].dir
+		node.modified = true
+	}
+}
+
+// set puts an existing object at path.
+func (e *treeEditor) set(relpath string, id sha1, mode EntryMode) error {
+	dirs, name := splitPath(relpath)
+	node, err := e.dir(dirs, true)
+	if err != nil {
+		return err
+	}
+
+	node.entries[name] = &editEntry{pathEntry: pathEntry{ID: id, Mode: mode}}
+	e.markModified(dirs)
+	return nil
+}
+
+// setContent puts a blob with given content at path.
+func (e *treeEditor) setContent(relpath string, data []byte, mode EntryMode) error {
+	dirs, name := splitPath(relpath)
+	node, err := e.dir(dirs, true)
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		data = []byte{}
+	}
+	node.entries[name] = &editEntry{pathEntry: pathEntry{ID: hashObject(OBJECT_BLOB, data), Mode: mode}, data: data}
+	e.markModified(dirs)
+	return nil
+}
+
+// remove deletes the entry at path, directories left empty are removed too.
+func (e *treeEditor) remove(relpath string) error {
+	dirs, name := splitPath(relpath)
+	node, err := e.dir(dirs, false)
+	if err != nil || node == nil {
+		return err
+	}
+
+	delete(node.entries, name)
+	e.markModified(dirs)
+	return nil
+}
+
+// write stores modified trees and new blobs and returns id of the root tree.
+func (e *treeEditor) write(w objectWriter) (sha1, error) {
+	id, _, err := e.writeNode(w, e.root)
+	return id, err
+}
+
+// writeNode stores the directory and returns its id, and whether the tree
+// written has no entries.
+func (e *treeEditor) writeNode(w objectWriter, node *editNode) (sha1, bool, error) {
+	if !node.modified {
+		return node.id, false, nil
+	}
+
+	entries := make(map[string]*pathEntry, len(node.entries))
+	for name, entry := range node.entries {
+		switch {
+		case entry.dir != nil:
+			id, empty, err := e.writeNode(w, entry.dir)
+			if err != nil {
+				return sha1{}, false, err
+			}
+			if empty {
+				// git does not keep empty directories
+				delete(node.entries, name)
+				continue
+			}
+			entry.ID = id
+
+		case entry.data != nil:
+			if _, err := w.writeObject(OBJECT_BLOB, entry.data); err != nil {
+				return sha1{}, false, err
+			}
+			entry.data = nil
+		}
+
+		entries[name] = &entry.pathEntry
+	}
+
+	id, err := w.writeObject(OBJECT_TREE, encodeTree(entries))
+	if err != nil {
+		return sha1{}, false, err
+	}
+
+	node.id = id
+	node.modified = false
+	return id, len(entries) == 0, nil
+}
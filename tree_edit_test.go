@@ -0,0 +1,191 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestRepository creates an empty bare repository removed once the test
+// is over.
+func newTestRepository(t *testing.T) *Repository {
+	dir, err := ioutil.TempDir("", "git-module-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err = InitRepository(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := OpenRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// treeNames returns sorted names of entries of tree id.
+func treeNames(t *testing.T, repo *Repository, id sha1) []string {
+	entries, err := repo.readTreeEntries(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestTreeEditorRemoveNested(t *testing.T) {
+	repo := newTestRepository(t)
+
+	editor := repo.newTreeEditor(sha1{})
+	for _, relpath := range []string{"a/b/c/d", "x"} {
+		if err := editor.setContent(relpath, []byte(relpath+"\n"), ENTRY_MODE_BLOB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base, err := editor.write(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor = repo.newTreeEditor(base)
+	if err = editor.remove("a/b/c/d"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := editor.write(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := treeNames(t, repo, id); len(names) != 1 || names[0] != "x" {
+		t.Fatalf("got root entries %v, want only x", names)
+	}
+
+	// pruned directories stay away when the tree is written again
+	if err = editor.setContent("y", []byte("y\n"), ENTRY_MODE_BLOB); err != nil {
+		t.Fatal(err)
+	}
+	if id, err = editor.write(repo); err != nil {
+		t.Fatal(err)
+	}
+	if names := treeNames(t, repo, id); len(names) != 2 || names[0] != "x" || names[1] != "y" {
+		t.Fatalf("got root entries %v, want x and y", names)
+	}
+}
+
+func TestTreeEditorRemoveAll(t *testing.T) {
+	repo := newTestRepository(t)
+
+	editor := repo.newTreeEditor(sha1{})
+	if err := editor.setContent("a/b/c", []byte("c\n"), ENTRY_MODE_BLOB); err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.remove("a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := editor.write(newMemoryObjects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != hashObject(OBJECT_TREE, nil) {
+		t.Errorf("got %s, want the empty tree", id)
+	}
+}
+
+func TestEncodeTreeOrder(t *testing.T) {
+	entries := map[string]*pathEntry{
+		"a.c": {ID: testID(1), Mode: ENTRY_MODE_BLOB},
+		"a":   {ID: testID(2), Mode: ENTRY_MODE_TREE},
+		"a-b": {ID: testID(3), Mode: ENTRY_MODE_BLOB},
+		"b":   {ID: testID(4), Mode: ENTRY_MODE_EXEC},
+	}
+
+	data := encodeTree(entries)
+	decoded, err := decodeTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(entries) {
+		t.Fatalf("got %d entries, want %d", len(decoded), len(entries))
+	}
+	for name, entry := range entries {
+		if !sameEntry(decoded[name], entry) {
+			t.Errorf("entry %s: got %+v, want %+v", name, decoded[name], entry)
+		}
+	}
+
+	// directories sort as if they had a trailing slash: "a-b" < "a.c" < "a/"
+	names := []string{}
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		names = append(names, string(data[space+1:nul]))
+		data = data[nul+21:]
+	}
+	want := []string{"a-b", "a.c", "a", "b"}
+	for idx := range want {
+		if names[idx] != want[idx] {
+			t.Fatalf("got order %v, want %v", names, want)
+		}
+	}
+}
+
+func TestOverlayReads(t *testing.T) {
+	repo := newTestRepository(t)
+	view := repo.withOverlay(newMemoryObjects())
+
+	editor := view.newTreeEditor(sha1{})
+	if err := editor.setContent("dir/file", []byte("content\n"), ENTRY_MODE_BLOB); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := editor.write(view.overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1500000000, 0).UTC()}
+	id, err := writeCommit(view.overlay, tree, nil, sig, nil, "message\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.objectOwner(id) != nil {
+		t.Fatal("commit kept in memory is found in the repository")
+	}
+	if view.objectOwner(id) != view {
+		t.Error("commit kept in memory is not found through the overlay")
+	}
+
+	node, err := view.loadWalkNode(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.tree != tree || len(node.parents) != 0 || node.when != sig.When.Unix() {
+		t.Errorf("got node %+v", node)
+	}
+
+	entry, err := view.lookupPath(tree, "dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Mode != ENTRY_MODE_BLOB {
+		t.Fatalf("got entry %+v", entry)
+	}
+
+	entries, err := NewTree(view, tree).ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "dir" || !entries[0].IsDir() || entries[0].Type != OBJECT_TREE {
+		t.Errorf("got entries %+v", entries)
+	}
+}
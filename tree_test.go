@@ -0,0 +1,85 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestCommitTree(t *testing.T) {
+	repo := newTestRepository(t)
+	tree := testTree(t, repo, map[string]string{"dir/file": "content\n", "top": "top\n"})
+	id := testCommitFiles(t, repo, map[string]string{"dir/file": "content\n", "top": "top\n"}, 100)
+
+	commit, err := repo.GetCommit(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.TreeID() != tree.ID {
+		t.Fatalf("got tree %s, want %s", commit.TreeID(), tree.ID)
+	}
+
+	entries, err := commit.ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "dir" || !entries[0].IsDir() || entries[1].Name() != "top" {
+		t.Errorf("got entries %+v", entries)
+	}
+
+	entry, err := commit.GetTreeEntryByPath("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.IsDir() || entry.Size() != int64(len("content\n")) {
+		t.Errorf("got entry %+v", entry)
+	}
+
+	blob, err := commit.GetBlobByPath("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := blob.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "content\n" {
+		t.Errorf("got content %q", data)
+	}
+
+	if _, err = commit.GetBlobByPath("dir"); !IsErrNotExist(err) {
+		t.Errorf("blob of a directory: got %v", err)
+	}
+	if _, err = commit.GetTreeEntryByPath("top/file"); !IsErrNotExist(err) {
+		t.Errorf("path under a file: got %v", err)
+	}
+}
+
+func TestCommitMissingTree(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// the tree is read only when entries are asked for
+	sig := &Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(100, 0).UTC()}
+	id, err := writeCommit(repo, testID(1), nil, sig, nil, "message\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := repo.GetCommit(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.TreeID() != testID(1) {
+		t.Errorf("got tree %s, want %s", commit.TreeID(), testID(1))
+	}
+	if _, err = commit.ListEntries(); err == nil {
+		t.Error("expected error listing missing tree")
+	}
+	if _, err = commit.GetTreeEntryByPath("file"); err == nil {
+		t.Error("expected error looking up path in missing tree")
+	}
+}
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"errors"
+	"sort"
+)
+
+// TreeWalkOptions controls recursive tree walks.
+type TreeWalkOptions struct {
+	// Paths limits the walk to a pathspec: plain paths select themselves
+	// and everything below, globs are matched against whole paths
+	Paths []string
+	// MaxDepth limits how deep the walk descends, entries of the tree itself
+	// are at depth 1. Trees at the limit are reported without their content.
+	// Zero means no limit.
+	MaxDepth int
+	// IncludeTrees reports subtrees too, before their content, as
+	// `ls-tree -r -t` does
+	IncludeTrees bool
+	// WithSize fills sizes of blobs
+	WithSize bool
+}
+
+// TreeWalkEntry is an entry found by a tree walk.
+type TreeWalkEntry struct {
+	// Path is the full path relative to the walked tree
+	Path string
+	Mode EntryMode
+	Type ObjectType
+	ID   sha1
+	// Size of a blob, zero for other entries or if sizes were not asked for
+	Size int64
+}
+
+// TreeWalkFunc receives entries of a tree walk. Returning ErrSkipTree for a
+// subtree skips its content. Returning it for a file or submodule skips the
+// remaining entries of the directory it is in, as filepath.SkipDir does. Any
+// other error stops the walk.
+type TreeWalkFunc func(entry *TreeWalkEntry) error
+
+// ErrSkipTree is returned by TreeWalkFunc to skip content of a subtree, or
+// the rest of the directory when returned for a file.
+var ErrSkipTree = errors.New("skip this tree")
+
+// Walk reports entries of the tree recursively, in the same order as
+// `git ls-tree -r` does. Subtrees which cannot contain anything selected by
+// the pathspec are not read.
+func (t *Tree) Walk(opts TreeWalkOptions, fn TreeWalkFunc) error {
+	return t.repo.walkTree(t.ID, "", 1, pathSpec(opts.Paths), &opts, fn)
+}
+
+func (repo *Repository) walkTree(id sha1, prefix string, depth int, spec pathSpec, opts *TreeWalkOptions, fn TreeWalkFunc) error {
+	entries, err := repo.readTreeEntries(id)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return treeOrderKey(names[i], entries[names[i]]) < treeOrderKey(names[j], entries[names[j]])
+	})
+
+	for _, name := range names {
+		entry := entries[name]
+		relpath := prefix + name
+		walkEntry := &TreeWalkEntry{Path: relpath, Mode: entry.Mode, ID: entry.ID}
+
+		switch {
+		case entry.isDir():
+			walkEntry.Type = OBJECT_TREE
+		case entry.Mode == ENTRY_MODE_COMMIT:
+			walkEntry.Type = OBJECT_COMMIT
+		default:
+			walkEntry.Type = OBJECT_BLOB
+		}
+
+		if !entry.isDir() {
+			if !spec.matches(relpath) {
+				continue
+			}
+			if opts.WithSize && walkEntry.Type == OBJECT_BLOB {
+				if walkEntry.Size, err = repo.blobSize(entry.ID); err != nil {
+					return err
+				}
+			}
+			if err = fn(walkEntry); err == ErrSkipTree {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !spec.mayMatchUnder(relpath) && !spec.matches(relpath) {
+			continue
+		}
+
+		// trees at the depth limit are reported in place of their content
+		atLimit := opts.MaxDepth > 0 && depth >= opts.MaxDepth
+		if opts.IncludeTrees || atLimit {
+			err = fn(walkEntry)
+			if err == ErrSkipTree {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if atLimit {
+			continue
+		}
+
+		if err = repo.walkTree(entry.ID, relpath+"/", depth+1, spec, opts, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
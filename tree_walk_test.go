@@ -0,0 +1,125 @@
+package git
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func walkPaths(t *testing.T, tree *Tree, opts TreeWalkOptions, fn TreeWalkFunc) []string {
+	paths := []string{}
+	err := tree.Walk(opts, func(entry *TreeWalkEntry) error {
+		paths = append(paths, entry.Path)
+		if fn != nil {
+			return fn(entry)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestTreeWalk(t *testing.T) {
+	repo := newTestRepository(t)
+	tree := testTree(t, repo, map[string]string{
+		"a.txt":         "a",
+		"dir/b.txt":     "bb",
+		"dir/sub/c.txt": "ccc",
+		"dir/z.txt":     "zzzz",
+		"e.txt":         "eeeee",
+	})
+
+	skip := func(path string) TreeWalkFunc {
+		return func(entry *TreeWalkEntry) error {
+			if entry.Path == path {
+				return ErrSkipTree
+			}
+			return nil
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		opts TreeWalkOptions
+		fn   TreeWalkFunc
+		want []string
+	}{
+		{"all", TreeWalkOptions{}, nil,
+			[]string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/z.txt", "e.txt"}},
+		{"paths", TreeWalkOptions{Paths: []string{"e.txt", "dir/sub"}}, nil,
+			[]string{"dir/sub/c.txt", "e.txt"}},
+		{"depth 1", TreeWalkOptions{MaxDepth: 1}, nil,
+			[]string{"a.txt", "dir", "e.txt"}},
+		{"depth 2", TreeWalkOptions{MaxDepth: 2}, nil,
+			[]string{"a.txt", "dir/b.txt", "dir/sub", "dir/z.txt", "e.txt"}},
+		{"trees", TreeWalkOptions{IncludeTrees: true}, nil,
+			[]string{"a.txt", "dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt", "dir/z.txt", "e.txt"}},
+		{"trees and depth", TreeWalkOptions{IncludeTrees: true, MaxDepth: 2}, nil,
+			[]string{"a.txt", "dir", "dir/b.txt", "dir/sub", "dir/z.txt", "e.txt"}},
+		{"skip tree", TreeWalkOptions{IncludeTrees: true}, skip("dir/sub"),
+			[]string{"a.txt", "dir", "dir/b.txt", "dir/sub", "dir/z.txt", "e.txt"}},
+		// skipping at a file leaves out the rest of its directory
+		{"skip file", TreeWalkOptions{}, skip("dir/b.txt"),
+			[]string{"a.txt", "dir/b.txt", "e.txt"}},
+		{"skip top file", TreeWalkOptions{}, skip("a.txt"),
+			[]string{"a.txt"}},
+	} {
+		if got := walkPaths(t, tree, test.opts, test.fn); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTreeWalkSize(t *testing.T) {
+	repo := newTestRepository(t)
+	tree := testTree(t, repo, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "bb",
+	})
+
+	for _, withSize := range []bool{false, true} {
+		sizes := map[string]int64{}
+		err := tree.Walk(TreeWalkOptions{IncludeTrees: true, WithSize: withSize}, func(entry *TreeWalkEntry) error {
+			sizes[entry.Path] = entry.Size
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]int64{"a.txt": 0, "dir": 0, "dir/b.txt": 0}
+		if withSize {
+			want["a.txt"], want["dir/b.txt"] = 1, 2
+		}
+		if !reflect.DeepEqual(sizes, want) {
+			t.Errorf("WithSize %v: got %v, want %v", withSize, sizes, want)
+		}
+	}
+}
+
+func TestTreeWalkError(t *testing.T) {
+	repo := newTestRepository(t)
+	tree := testTree(t, repo, map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+		"e.txt":     "e",
+	})
+
+	stop := errors.New("stop")
+	paths := []string{}
+	err := tree.Walk(TreeWalkOptions{}, func(entry *TreeWalkEntry) error {
+		paths = append(paths, entry.Path)
+		if entry.Path == "dir/b.txt" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+	if want := []string{"a.txt", "dir/b.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %q, want %q", paths, want)
+	}
+}
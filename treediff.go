@@ -0,0 +1,124 @@
+package git
+
+import (
+	"sort"
+)
+
+// treeDiffFn receives a single changed leaf entry. One of from and to is nil
+// when the entry was added or deleted.
+type treeDiffFn func(relpath string, from, to *pathEntry) error
+
+// readTreeEntries returns entries of the tree keyed by name. Zero id stands
+// for an empty tree.
+func (repo *Repository) readTreeEntries(id sha1) (map[string]*pathEntry, error) {
+	entries := map[string]*pathEntry{}
+	if id == (sha1{}) {
+		return entries, nil
+	}
+
+	if object := repo.overlayObject(id); object != nil {
+		return decodeTree(object.data)
+	}
+
+	tree, err := repo.openRawTree(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range tree.Items {
+		item := &tree.Items[idx]
+		entries[item.Name] = &pathEntry{ID: sha1(*item.GetOID()), Mode: EntryMode(item.Mode)}
+	}
+
+	return entries, nil
+}
+
+// treeOrderKey returns name as git compares it when sorting tree entries:
+// directories sort as if they had trailing slash.
+func treeOrderKey(name string, entries ...*pathEntry) string {
+	for _, entry := range entries {
+		if entry != nil && entry.isDir() {
+			return name + "/"
+		}
+	}
+	return name
+}
+
+// diffTreeIDs walks two trees side by side and reports every leaf entry that
+// differs between them, in the same order as git does. Subtrees are descended
+// only when their ids differ and spec may match something inside, so
+// unchanged parts of the tree are never read. Zero id stands for an empty
+// tree.
+func (repo *Repository) diffTreeIDs(oldID, newID sha1, prefix string, spec pathSpec, fn treeDiffFn) error {
+	if oldID == newID {
+		return nil
+	}
+
+	oldEntries, err := repo.readTreeEntries(oldID)
+	if err != nil {
+		return err
+	}
+
+	newEntries, err := repo.readTreeEntries(newID)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(oldEntries)+len(newEntries))
+	for name := range oldEntries {
+		names = append(names, name)
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return treeOrderKey(names[i], oldEntries[names[i]], newEntries[names[i]]) <
+			treeOrderKey(names[j], oldEntries[names[j]], newEntries[names[j]])
+	})
+
+	for _, name := range names {
+		from, to := oldEntries[name], newEntries[name]
+		if sameEntry(from, to) {
+			continue
+		}
+
+		relpath := prefix + name
+		if (from != nil && from.isDir()) || (to != nil && to.isDir()) {
+			if !spec.mayMatchUnder(relpath) {
+				continue
+			}
+		} else if !spec.matches(relpath) {
+			continue
+		}
+
+		switch {
+		case from != nil && to != nil && from.isDir() && to.isDir():
+			err = repo.diffTreeIDs(from.ID, to.ID, relpath+"/", spec, fn)
+		case from != nil && from.isDir():
+			// tree replaced by a file or removed completely
+			if to != nil && spec.matches(relpath) {
+				err = fn(relpath, nil, to)
+			}
+			if err == nil {
+				err = repo.diffTreeIDs(from.ID, sha1{}, relpath+"/", spec, fn)
+			}
+		case to != nil && to.isDir():
+			if from != nil && spec.matches(relpath) {
+				err = fn(relpath, from, nil)
+			}
+			if err == nil {
+				err = repo.diffTreeIDs(sha1{}, to.ID, relpath+"/", spec, fn)
+			}
+		default:
+			err = fn(relpath, from, to)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
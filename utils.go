@@ -5,6 +5,7 @@
 package git
 
 import (
+	"container/list"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -39,6 +40,57 @@ func (oc *objectCache) Get(id string) (interface{}, bool) {
 	return obj, has
 }
 
+// lruCache is a thread-safe cache keeping at most size most recently used
+// objects.
+type lruCache struct {
+	lock  sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key string
+	obj interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lruCache) Set(key string, obj interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*lruItem).obj = obj
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{key, obj})
+	if c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*lruItem)
+		delete(c.items, oldest.key)
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruItem).obj, true
+}
+
 // isDir returns true if given path is a directory,
 // or returns false when it's a file or does not exist.
 func isDir(dir string) bool {